@@ -0,0 +1,139 @@
+package resurgo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// archiveMagic starts every ar archive.
+	archiveMagic = "!<arch>\n"
+	// archiveHeaderSize is the size of the header preceding each member.
+	archiveHeaderSize = 60
+)
+
+// archiveMember is an object stored in an ar archive.
+type archiveMember struct {
+	name string
+	r    *io.SectionReader
+}
+
+// DetectFunctionsFromArchive parses an ar archive (.a) from r and analyzes
+// each ELF relocatable object it holds like DetectFunctionsFromObject.
+// Results carry the name of the member they come from. Members that are not
+// ELF files, such as the archive symbol table, are skipped. Both the GNU and
+// BSD conventions for long member names are supported.
+func DetectFunctionsFromArchive(r io.ReaderAt) ([]ObjectFunctions, error) {
+	return DetectFunctionsFromArchiveContext(context.Background(), r, Options{})
+}
+
+// DetectFunctionsFromArchiveWithOptions is like DetectFunctionsFromArchive
+// but applies the settings in opts to each member.
+func DetectFunctionsFromArchiveWithOptions(r io.ReaderAt, opts Options) ([]ObjectFunctions, error) {
+	return DetectFunctionsFromArchiveContext(context.Background(), r, opts)
+}
+
+// DetectFunctionsFromArchiveContext is like
+// DetectFunctionsFromArchiveWithOptions but stops with the context error as
+// soon as ctx is done, and reports progress to opts.Progress, labelled with
+// the member and section names.
+func DetectFunctionsFromArchiveContext(ctx context.Context, r io.ReaderAt, opts Options) ([]ObjectFunctions, error) {
+	members, err := readArchiveMembers(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []ObjectFunctions
+	for _, m := range members {
+		magic := make([]byte, 4)
+		if _, err := m.r.ReadAt(magic, 0); err != nil || string(magic) != "\x7fELF" {
+			opts.logger().Debug("skipping non-ELF archive member", "member", m.name)
+			continue
+		}
+
+		objects, err := detectObjectFunctions(ctx, m.r, m.name, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to analyze archive member %s: %w", m.name, err)
+		}
+		result = append(result, objects...)
+	}
+
+	return result, nil
+}
+
+// readArchiveMembers returns the members of the ar archive read from r,
+// excluding the GNU symbol and long name tables.
+func readArchiveMembers(r io.ReaderAt) ([]archiveMember, error) {
+	magic := make([]byte, len(archiveMagic))
+	if _, err := r.ReadAt(magic, 0); err != nil || string(magic) != archiveMagic {
+		return nil, fmt.Errorf("not an ar archive")
+	}
+
+	var members []archiveMember
+	var longNames []byte
+	hdr := make([]byte, archiveHeaderSize)
+	for off := int64(len(archiveMagic)); ; {
+		if _, err := r.ReadAt(hdr, off); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read archive header at offset 0x%x: %w", off, err)
+		}
+		if string(hdr[58:60]) != "`\n" {
+			return nil, fmt.Errorf("malformed archive header at offset 0x%x", off)
+		}
+
+		size, err := strconv.ParseUint(strings.TrimSpace(string(hdr[48:58])), 10, 63)
+		if err != nil {
+			return nil, fmt.Errorf("malformed archive member size at offset 0x%x: %w", off, err)
+		}
+
+		data := off + archiveHeaderSize
+		// Members are aligned to even offsets.
+		off = data + int64(size+size%2)
+
+		name := strings.TrimRight(string(hdr[0:16]), " ")
+		dataSize := int64(size)
+		switch {
+		case name == "/" || name == "/SYM64/":
+			continue
+		case name == "//":
+			// Read through a section reader, so that a corrupt size does
+			// not allocate more than the archive holds.
+			longNames, err = io.ReadAll(io.NewSectionReader(r, data, dataSize))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read archive long names: %w", err)
+			}
+			continue
+		case strings.HasPrefix(name, "#1/"):
+			// BSD: the name is stored at the start of the member data.
+			n, err := strconv.ParseInt(name[3:], 10, 64)
+			if err != nil || n < 0 || n > dataSize {
+				return nil, fmt.Errorf("malformed archive member name %q", name)
+			}
+			buf := make([]byte, n)
+			if _, err := r.ReadAt(buf, data); err != nil {
+				return nil, fmt.Errorf("failed to read archive member name: %w", err)
+			}
+			name = string(bytes.TrimRight(buf, "\x00"))
+			data += n
+			dataSize -= n
+		case strings.HasPrefix(name, "/"):
+			// GNU: the name is stored in the long names table.
+			i, err := strconv.ParseUint(name[1:], 10, 64)
+			if err != nil || i >= uint64(len(longNames)) {
+				return nil, fmt.Errorf("malformed archive member name %q", name)
+			}
+			name, _, _ = strings.Cut(string(longNames[i:]), "/\n")
+		default:
+			name = strings.TrimSuffix(name, "/")
+		}
+
+		members = append(members, archiveMember{name: name, r: io.NewSectionReader(r, data, dataSize)})
+	}
+
+	return members, nil
+}
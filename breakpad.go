@@ -0,0 +1,259 @@
+package resurgo
+
+import (
+	"bufio"
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// breakpadArch returns the Breakpad name of arch and the names of its
+// DWARF registers, by number.
+func breakpadArch(arch Arch) (string, []string) {
+	switch arch {
+	case ArchAMD64:
+		return "x86_64", []string{
+			"$rax", "$rdx", "$rcx", "$rbx", "$rsi", "$rdi", "$rbp", "$rsp",
+			"$r8", "$r9", "$r10", "$r11", "$r12", "$r13", "$r14", "$r15", "$rip",
+		}
+	case ArchARM64:
+		regs := make([]string, 0, 33)
+		for i := range 31 {
+			regs = append(regs, fmt.Sprintf("x%d", i))
+		}
+		return "arm64", append(regs, "sp", "pc")
+	}
+	return "", nil
+}
+
+// WriteBreakpadSymbols writes to w the Breakpad symbol file of the ELF
+// executable or shared object read from r, with a FUNC record for each
+// candidate, so that crash reports of stripped binaries can be symbolized.
+// name is the name of the module, usually the base name of its file.
+//
+// The module is identified by its GNU build ID, or, without one, by a hash
+// of the start of .text, as Breakpad's dump_syms does. Functions are named
+// and sized as by WriteELFSymbols; candidates lying outside the sections
+// have no size and get a PUBLIC record instead. When the binary has an
+// .eh_frame section, its call frame information is written as STACK CFI
+// records, but for the FDEs using DWARF expressions. Addresses are relative
+// to the first loadable segment.
+func WriteBreakpadSymbols(w io.Writer, r io.ReaderAt, name string, candidates []FunctionCandidate) error {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return fmt.Errorf("failed to parse ELF file: %w", err)
+	}
+	if f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN {
+		return fmt.Errorf("not an ELF executable or shared object: %s", f.Type)
+	}
+	arch, err := elfArch(f.Machine)
+	if err != nil {
+		return err
+	}
+	archName, regs := breakpadArch(arch)
+
+	buildID, err := elfBuildID(f)
+	if err != nil {
+		return err
+	}
+	id := buildID
+	if id == nil {
+		if id, err = textHash(f); err != nil {
+			return err
+		}
+	}
+
+	var frames []frameEntry
+	if sec := f.Section(".eh_frame"); sec != nil && sec.Type != elf.SHT_NOBITS {
+		data, err := sec.Data()
+		if err != nil {
+			return fmt.Errorf("failed to read .eh_frame section: %w", err)
+		}
+		if frames, err = parseEHFrame(data, sec.Addr, f.ByteOrder); err != nil {
+			return err
+		}
+	}
+
+	var base uint64
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_LOAD {
+			base = p.Vaddr
+			break
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "MODULE Linux %s %s %s\n", archName, breakpadModuleID(id), name)
+	if buildID != nil {
+		fmt.Fprintf(bw, "INFO CODE_ID %s\n", strings.ToUpper(hex.EncodeToString(buildID)))
+	}
+
+	candidates = sortedFunctions(candidates)
+	var public []FunctionCandidate
+	for i, c := range candidates {
+		var size uint64
+		if index := elfSectionOf(f, c.Address); index >= 0 {
+			size = functionSize(candidates, i, f.Sections[index].Addr+f.Sections[index].Size)
+		}
+		if size == 0 || c.Address < base {
+			public = append(public, c)
+			continue
+		}
+		fmt.Fprintf(bw, "FUNC %x %x 0 %s\n", c.Address-base, size, symbolName(c))
+	}
+	for _, c := range public {
+		if c.Address >= base {
+			fmt.Fprintf(bw, "PUBLIC %x 0 %s\n", c.Address-base, symbolName(c))
+		}
+	}
+
+	for _, fe := range frames {
+		if fe.begin < base {
+			continue
+		}
+		writeStackCFI(bw, fe, base, regs)
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write Breakpad symbols: %w", err)
+	}
+	return nil
+}
+
+// writeStackCFI writes the STACK CFI records of fe: a STACK CFI INIT
+// record with the rules at its start, followed by a STACK CFI record with
+// the rules changed at each later row. Undefined registers cannot be
+// expressed and are left out, and so is fe if its CFA is computed from a
+// register without a name in regs.
+func writeStackCFI(w io.Writer, fe frameEntry, base uint64, regs []string) {
+	for _, row := range fe.rows {
+		if row.state.cfaReg >= uint64(len(regs)) {
+			return
+		}
+	}
+
+	regName := func(reg uint64) (string, bool) {
+		if reg == fe.ra {
+			return ".ra", true
+		}
+		if reg < uint64(len(regs)) {
+			return regs[reg], true
+		}
+		return "", false
+	}
+	rule := func(reg uint64, r cfiRule) (string, bool) {
+		name, ok := regName(reg)
+		if !ok {
+			return "", false
+		}
+		switch r.kind {
+		case cfiSavedAt:
+			return fmt.Sprintf("%s: .cfa %d + ^", name, r.offset), true
+		case cfiValue:
+			return fmt.Sprintf("%s: .cfa %d +", name, r.offset), true
+		case cfiRegister:
+			if r.reg >= uint64(len(regs)) {
+				return "", false
+			}
+			return fmt.Sprintf("%s: %s", name, regs[r.reg]), true
+		case cfiSameValue:
+			if reg >= uint64(len(regs)) {
+				return "", false
+			}
+			return fmt.Sprintf("%s: %s", name, regs[reg]), true
+		}
+		return "", false
+	}
+	// rules returns the rules of state, or those differing from prev: the
+	// CFA, the return address, then the other registers by number.
+	rules := func(state cfiState, prev *cfiState) []string {
+		var out []string
+		if prev == nil || state.cfaReg != prev.cfaReg || state.cfaOffset != prev.cfaOffset {
+			out = append(out, fmt.Sprintf(".cfa: %s %d +", regs[state.cfaReg], state.cfaOffset))
+		}
+		numbers := slices.Sorted(maps.Keys(state.regs))
+		if i := slices.Index(numbers, fe.ra); i > 0 {
+			numbers = append(append([]uint64{fe.ra}, numbers[:i]...), numbers[i+1:]...)
+		}
+		for _, reg := range numbers {
+			r := state.regs[reg]
+			if prev != nil {
+				if p, ok := prev.regs[reg]; ok && p == r {
+					continue
+				}
+			}
+			if s, ok := rule(reg, r); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+
+	first := fe.rows[0]
+	fmt.Fprintf(w, "STACK CFI INIT %x %x %s\n", fe.begin-base, fe.size, strings.Join(rules(first.state, nil), " "))
+	for i, row := range fe.rows[1:] {
+		if changed := rules(row.state, &fe.rows[i].state); len(changed) > 0 {
+			fmt.Fprintf(w, "STACK CFI %x %s\n", row.loc-base, strings.Join(changed, " "))
+		}
+	}
+}
+
+// breakpadModuleID returns the Breakpad module ID of a binary identified by
+// id: its first 16 bytes as a GUID, with the first three fields
+// byte-swapped, followed by an age of 0.
+func breakpadModuleID(id []byte) string {
+	var guid [16]byte
+	copy(guid[:], id)
+	slices.Reverse(guid[0:4])
+	slices.Reverse(guid[4:6])
+	slices.Reverse(guid[6:8])
+	return strings.ToUpper(hex.EncodeToString(guid[:])) + "0"
+}
+
+// ntGNUBuildID is the type of the note holding the GNU build ID.
+const ntGNUBuildID = 3
+
+// elfBuildID returns the GNU build ID of f, or nil if it has none.
+func elfBuildID(f *elf.File) ([]byte, error) {
+	for _, sec := range f.Sections {
+		if sec.Type != elf.SHT_NOTE {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s section: %w", sec.Name, err)
+		}
+		// Notes past a malformed one are not read.
+		for note, err := range elfNotes(data, f.ByteOrder) {
+			if err != nil {
+				break
+			}
+			if note.typ == ntGNUBuildID && note.name == "GNU" {
+				return note.desc, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// textHash returns the identifier of f used by Breakpad without a build
+// ID: the XOR of the 16-byte blocks of the first page of .text.
+func textHash(f *elf.File) ([]byte, error) {
+	sec := f.Section(".text")
+	if sec == nil {
+		return nil, fmt.Errorf("no build ID and no .text section found")
+	}
+	page := make([]byte, min(sec.Size, 4096))
+	if _, err := io.ReadFull(sec.Open(), page); err != nil {
+		return nil, fmt.Errorf("failed to read .text section: %w", err)
+	}
+	id := make([]byte, 16)
+	for i, b := range page {
+		id[i%16] ^= b
+	}
+	return id, nil
+}
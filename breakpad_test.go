@@ -0,0 +1,123 @@
+package resurgo_test
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+// writeBreakpad compiles testdata/demo-app.c as a position-independent
+// executable without optimizations, with the given build ID option, and
+// returns the lines of its Breakpad symbol file and the address of main.
+func writeBreakpad(t *testing.T, buildID string) ([]string, uint64) {
+	t.Helper()
+	binPath := filepath.Join(t.TempDir(), "demo-app")
+	cmd := exec.Command("gcc", "-O0", "-fPIE", "-pie", "-Wl,--build-id="+buildID, "-o", binPath, "testdata/demo-app.c")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile testdata/demo-app.c: %v\n%s", err, out)
+	}
+	data, err := os.ReadFile(binPath)
+	if err != nil {
+		t.Fatalf("failed to read compiled binary: %v", err)
+	}
+
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to parse ELF file: %v", err)
+	}
+	syms, err := f.Symbols()
+	if err != nil {
+		t.Fatalf("failed to read symbols: %v", err)
+	}
+	var main uint64
+	for _, s := range syms {
+		if s.Name == "main" {
+			main = s.Value
+		}
+	}
+
+	candidates, err := resurgo.DetectFunctionsFromELF(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("DetectFunctionsFromELF: %v", err)
+	}
+	var out bytes.Buffer
+	if err := resurgo.WriteBreakpadSymbols(&out, bytes.NewReader(data), "demo-app", candidates); err != nil {
+		t.Fatalf("WriteBreakpadSymbols: %v", err)
+	}
+	var lines []string
+	sc := bufio.NewScanner(&out)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	return lines, main
+}
+
+func TestWriteBreakpadSymbols(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found, skipping")
+	}
+
+	lines, main := writeBreakpad(t, "0x0123456789abcdef0123456789abcdef01234567")
+	if len(lines) < 3 {
+		t.Fatalf("expected records, got %q", lines)
+	}
+	// The first three fields of the GUID are byte-swapped.
+	if want := "MODULE Linux x86_64 67452301AB89EFCD0123456789ABCDEF0 demo-app"; lines[0] != want {
+		t.Errorf("expected %q, got %q", want, lines[0])
+	}
+	if want := "INFO CODE_ID 0123456789ABCDEF0123456789ABCDEF01234567"; lines[1] != want {
+		t.Errorf("expected %q, got %q", want, lines[1])
+	}
+
+	// The first segment of the executable is loaded at 0: addresses are
+	// unchanged.
+	prefix := fmt.Sprintf("FUNC %x ", main)
+	if !slices.ContainsFunc(lines, func(l string) bool {
+		return strings.HasPrefix(l, prefix) && strings.HasSuffix(l, fmt.Sprintf(" 0 sub_%x", main))
+	}) {
+		t.Errorf("expected a FUNC record for main at 0x%x", main)
+	}
+
+	// main pushes rbp, then uses it as the frame pointer.
+	i := slices.IndexFunc(lines, func(l string) bool {
+		return strings.HasPrefix(l, fmt.Sprintf("STACK CFI INIT %x ", main))
+	})
+	if i < 0 || i+3 > len(lines) {
+		t.Fatalf("expected STACK CFI records for main at 0x%x", main)
+	}
+	if !strings.HasSuffix(lines[i], " .cfa: $rsp 8 + .ra: .cfa -8 + ^") {
+		t.Errorf("unexpected initial rules: %q", lines[i])
+	}
+	for j, want := range []string{
+		fmt.Sprintf("STACK CFI %x .cfa: $rsp 16 + $rbp: .cfa -16 + ^", main+1),
+		fmt.Sprintf("STACK CFI %x .cfa: $rbp 16 +", main+4),
+	} {
+		if got := lines[i+1+j]; got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestWriteBreakpadSymbols_NoBuildID(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found, skipping")
+	}
+
+	lines, _ := writeBreakpad(t, "none")
+	fields := strings.Fields(lines[0])
+	if len(fields) != 5 || fields[0] != "MODULE" || len(fields[3]) != 33 {
+		t.Errorf("expected a MODULE record with a hash of .text, got %q", lines[0])
+	}
+	if strings.HasPrefix(lines[1], "INFO CODE_ID") {
+		t.Errorf("expected no INFO CODE_ID record without a build ID, got %q", lines[1])
+	}
+}
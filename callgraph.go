@@ -0,0 +1,371 @@
+package resurgo
+
+import (
+	"cmp"
+	"slices"
+)
+
+// CallGraphEdge is a call, or a tail call, from one function to another.
+type CallGraphEdge struct {
+	// Caller is the entry of the function holding the call site.
+	Caller uint64 `json:"caller"`
+	// Callee is the entry of the function called.
+	Callee uint64 `json:"callee"`
+	// Site is the address of the call site.
+	Site uint64       `json:"site"`
+	Type CallSiteType `json:"type"`
+	// AddressMode is the addressing mode of the call site, when the graph
+	// is built from call sites by NewCallGraphFromCallSites.
+	AddressMode AddressingMode `json:"address_mode,omitempty"`
+}
+
+// CallGraph is the graph of the calls between function candidates. Each
+// call site is attributed to the function containing it: the candidate with
+// the highest address not above it.
+type CallGraph struct {
+	// functions are the sorted function entries, and index their position.
+	functions []uint64
+	index     map[uint64]int
+	// candidates are the candidates of the functions, by position.
+	candidates []FunctionCandidate
+	// out and in hold the edges from and to each function, by position,
+	// sorted by call site.
+	out, in [][]CallGraphEdge
+}
+
+// NewCallGraph builds the call graph of candidates, as returned by
+// DetectFunctions: the calls recorded in CalledFrom, and the tail calls
+// recorded in JumpedFrom. Call sites below the first candidate belong to no
+// function and are ignored.
+func NewCallGraph(candidates []FunctionCandidate) *CallGraph {
+	g := newCallGraph(candidates)
+	for _, c := range candidates {
+		for _, site := range c.CalledFrom {
+			g.add(CallGraphEdge{Callee: c.Address, Site: site, Type: CallSiteCall})
+		}
+		for _, site := range c.JumpedFrom {
+			g.add(CallGraphEdge{Callee: c.Address, Site: site, Type: CallSiteJump})
+		}
+	}
+	g.sort()
+	return g
+}
+
+// NewCallGraphFromCallSites builds the call graph of candidates from the
+// call sites returned by DetectCallSites, rather than from CalledFrom and
+// JumpedFrom, so that edges carry the addressing mode of their call site.
+// Only call sites targeting a candidate are kept, and jumps classified as
+// JumpInternal are ignored.
+func NewCallGraphFromCallSites(candidates []FunctionCandidate, callSites []CallSiteEdge) *CallGraph {
+	g := newCallGraph(candidates)
+	for _, cs := range callSites {
+		if cs.JumpKind == JumpInternal {
+			continue
+		}
+		if _, ok := g.index[cs.TargetAddr]; !ok {
+			continue
+		}
+		g.add(CallGraphEdge{
+			Callee:      cs.TargetAddr,
+			Site:        cs.SourceAddr,
+			Type:        cs.Type,
+			AddressMode: cs.AddressMode,
+		})
+	}
+	g.sort()
+	return g
+}
+
+// newCallGraph returns the graph of the functions of candidates, without
+// edges.
+func newCallGraph(candidates []FunctionCandidate) *CallGraph {
+	g := &CallGraph{index: make(map[uint64]int, len(candidates))}
+	g.candidates = slices.Clone(candidates)
+	slices.SortStableFunc(g.candidates, func(a, b FunctionCandidate) int {
+		return cmp.Compare(a.Address, b.Address)
+	})
+	g.candidates = slices.CompactFunc(g.candidates, func(a, b FunctionCandidate) bool {
+		return a.Address == b.Address
+	})
+	g.functions = make([]uint64, len(g.candidates))
+	for i, c := range g.candidates {
+		g.functions[i] = c.Address
+		g.index[c.Address] = i
+	}
+	g.out = make([][]CallGraphEdge, len(g.functions))
+	g.in = make([][]CallGraphEdge, len(g.functions))
+	return g
+}
+
+// add attributes the call site of e to its caller and adds e to the graph.
+// e.Callee must be a function of the graph.
+func (g *CallGraph) add(e CallGraphEdge) {
+	caller, ok := g.Function(e.Site)
+	if !ok {
+		return
+	}
+	e.Caller = caller
+	g.out[g.index[caller]] = append(g.out[g.index[caller]], e)
+	g.in[g.index[e.Callee]] = append(g.in[g.index[e.Callee]], e)
+}
+
+// sort orders the edges of each function by call site and drops
+// duplicates.
+func (g *CallGraph) sort() {
+	for i := range g.functions {
+		slices.SortFunc(g.out[i], compareEdges)
+		g.out[i] = slices.Compact(g.out[i])
+		slices.SortFunc(g.in[i], compareEdges)
+		g.in[i] = slices.Compact(g.in[i])
+	}
+}
+
+func compareEdges(a, b CallGraphEdge) int {
+	return cmp.Or(cmp.Compare(a.Site, b.Site), cmp.Compare(a.Callee, b.Callee))
+}
+
+// Functions returns the entries of the functions in the graph, sorted.
+func (g *CallGraph) Functions() []uint64 {
+	return slices.Clone(g.functions)
+}
+
+// Candidate returns the candidate of the function at fn, and false if fn
+// is not the entry of a function of the graph.
+func (g *CallGraph) Candidate(fn uint64) (FunctionCandidate, bool) {
+	i, ok := g.index[fn]
+	if !ok {
+		return FunctionCandidate{}, false
+	}
+	return g.candidates[i], true
+}
+
+// Function returns the entry of the function containing addr, and false if
+// addr lies below the first function.
+func (g *CallGraph) Function(addr uint64) (uint64, bool) {
+	i, found := slices.BinarySearch(g.functions, addr)
+	if found {
+		return addr, true
+	}
+	if i == 0 {
+		return 0, false
+	}
+	return g.functions[i-1], true
+}
+
+// Edges returns the edges of the graph, sorted by call site.
+func (g *CallGraph) Edges() []CallGraphEdge {
+	var edges []CallGraphEdge
+	for _, out := range g.out {
+		edges = append(edges, out...)
+	}
+	slices.SortFunc(edges, compareEdges)
+	return edges
+}
+
+// Calls returns the edges from the function at fn, sorted by call site.
+func (g *CallGraph) Calls(fn uint64) []CallGraphEdge {
+	i, ok := g.index[fn]
+	if !ok {
+		return nil
+	}
+	return slices.Clone(g.out[i])
+}
+
+// CalledBy returns the edges to the function at fn, sorted by call site.
+func (g *CallGraph) CalledBy(fn uint64) []CallGraphEdge {
+	i, ok := g.index[fn]
+	if !ok {
+		return nil
+	}
+	return slices.Clone(g.in[i])
+}
+
+// Callees returns the entries of the functions called by the function at
+// fn, sorted.
+func (g *CallGraph) Callees(fn uint64) []uint64 {
+	i, ok := g.index[fn]
+	if !ok {
+		return nil
+	}
+	return g.neighbours(g.out[i], func(e CallGraphEdge) uint64 { return e.Callee })
+}
+
+// Callers returns the entries of the functions calling the function at fn,
+// sorted.
+func (g *CallGraph) Callers(fn uint64) []uint64 {
+	i, ok := g.index[fn]
+	if !ok {
+		return nil
+	}
+	return g.neighbours(g.in[i], func(e CallGraphEdge) uint64 { return e.Caller })
+}
+
+func (g *CallGraph) neighbours(edges []CallGraphEdge, end func(CallGraphEdge) uint64) []uint64 {
+	fns := make([]uint64, 0, len(edges))
+	for _, e := range edges {
+		fns = append(fns, end(e))
+	}
+	slices.Sort(fns)
+	return slices.Compact(fns)
+}
+
+// Roots returns the entries of the functions that no function calls,
+// sorted. They are the entry points of the program, callbacks, and
+// functions only called indirectly.
+func (g *CallGraph) Roots() []uint64 {
+	var roots []uint64
+	for i, fn := range g.functions {
+		if !slices.ContainsFunc(g.in[i], func(e CallGraphEdge) bool { return e.Caller != fn }) {
+			roots = append(roots, fn)
+		}
+	}
+	return roots
+}
+
+// Reachable returns the entries of the functions reachable through calls
+// from the functions containing entries, including those, sorted.
+func (g *CallGraph) Reachable(entries ...uint64) []uint64 {
+	seen := g.reach(entries)
+	var fns []uint64
+	for i, fn := range g.functions {
+		if seen[i] {
+			fns = append(fns, fn)
+		}
+	}
+	return fns
+}
+
+// Dead returns the entries of the functions not reachable through calls
+// from the functions containing entries, sorted. Functions only called
+// indirectly, such as callbacks, are reported unless listed in entries.
+func (g *CallGraph) Dead(entries ...uint64) []uint64 {
+	seen := g.reach(entries)
+	var fns []uint64
+	for i, fn := range g.functions {
+		if !seen[i] {
+			fns = append(fns, fn)
+		}
+	}
+	return fns
+}
+
+// reach marks the functions reachable from the functions containing
+// entries, by position.
+func (g *CallGraph) reach(entries []uint64) []bool {
+	seen := make([]bool, len(g.functions))
+	var work []int
+	for _, addr := range entries {
+		if fn, ok := g.Function(addr); ok && !seen[g.index[fn]] {
+			seen[g.index[fn]] = true
+			work = append(work, g.index[fn])
+		}
+	}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, e := range g.out[i] {
+			if j := g.index[e.Callee]; !seen[j] {
+				seen[j] = true
+				work = append(work, j)
+			}
+		}
+	}
+	return seen
+}
+
+// StronglyConnectedComponents returns the strongly connected components of
+// the graph: the sets of functions that can all call each other, directly
+// or not. Each component is sorted, and a component comes before the
+// components of its callers, so callees come first.
+func (g *CallGraph) StronglyConnectedComponents() [][]uint64 {
+	// Tarjan's algorithm, with an explicit stack.
+	const unvisited = -1
+	n := len(g.functions)
+	order, low := make([]int, n), make([]int, n)
+	for i := range order {
+		order[i] = unvisited
+	}
+	onStack := make([]bool, n)
+	var stack []int
+	var components [][]uint64
+
+	type frame struct{ v, next int }
+	counter := 0
+	for root := range n {
+		if order[root] != unvisited {
+			continue
+		}
+		frames := []frame{{v: root}}
+		order[root], low[root] = counter, counter
+		counter++
+		stack = append(stack, root)
+		onStack[root] = true
+
+		for len(frames) > 0 {
+			f := &frames[len(frames)-1]
+			if f.next < len(g.out[f.v]) {
+				w := g.index[g.out[f.v][f.next].Callee]
+				f.next++
+				switch {
+				case order[w] == unvisited:
+					order[w], low[w] = counter, counter
+					counter++
+					stack = append(stack, w)
+					onStack[w] = true
+					frames = append(frames, frame{v: w})
+				case onStack[w]:
+					low[f.v] = min(low[f.v], order[w])
+				}
+				continue
+			}
+
+			v := f.v
+			frames = frames[:len(frames)-1]
+			if len(frames) > 0 {
+				parent := frames[len(frames)-1].v
+				low[parent] = min(low[parent], low[v])
+			}
+			if low[v] != order[v] {
+				continue
+			}
+			var component []uint64
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				component = append(component, g.functions[w])
+				if w == v {
+					break
+				}
+			}
+			slices.Sort(component)
+			components = append(components, component)
+		}
+	}
+	return components
+}
+
+// Recursive returns the strongly connected components of recursive
+// functions: mutually recursive functions, and functions calling
+// themselves.
+func (g *CallGraph) Recursive() [][]uint64 {
+	var recursive [][]uint64
+	for _, component := range g.StronglyConnectedComponents() {
+		if len(component) > 1 || slices.Contains(g.Callees(component[0]), component[0]) {
+			recursive = append(recursive, component)
+		}
+	}
+	return recursive
+}
+
+// TopologicalOrder returns the entries of the functions ordered so that
+// callers come before their callees. Recursive functions, which have no
+// such order, are kept together in address order.
+func (g *CallGraph) TopologicalOrder() []uint64 {
+	components := g.StronglyConnectedComponents()
+	fns := make([]uint64, 0, len(g.functions))
+	for _, component := range slices.Backward(components) {
+		fns = append(fns, component...)
+	}
+	return fns
+}
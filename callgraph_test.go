@@ -0,0 +1,129 @@
+package resurgo_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+// Call graph:
+//
+//	main → A, B
+//	A    → C, D (tail call)
+//	B    → B
+//	C    → A
+//	E    → D  (never called)
+const (
+	fnMain = 0x1000
+	fnA    = 0x1100
+	fnB    = 0x1200
+	fnC    = 0x1300
+	fnD    = 0x1400
+	fnE    = 0x1500
+)
+
+func testCallGraph() *resurgo.CallGraph {
+	return resurgo.NewCallGraph([]resurgo.FunctionCandidate{
+		{Address: fnMain},
+		{Address: fnA, CalledFrom: []uint64{0x1005, 0x1305}},
+		{Address: fnB, CalledFrom: []uint64{0x100a, 0x1210}},
+		{Address: fnC, CalledFrom: []uint64{0x1110}},
+		// 0x800 lies below the first function.
+		{Address: fnD, CalledFrom: []uint64{0x1505, 0x800}, JumpedFrom: []uint64{0x1120}},
+		{Address: fnE},
+	})
+}
+
+func TestCallGraph_Queries(t *testing.T) {
+	g := testCallGraph()
+
+	if fn, ok := g.Function(0x1120); !ok || fn != fnA {
+		t.Errorf("Function(0x1120): expected 0x%x, got 0x%x (%v)", fnA, fn, ok)
+	}
+	if _, ok := g.Function(0x800); ok {
+		t.Error("Function(0x800): expected no function")
+	}
+
+	if got, want := g.Callees(fnMain), []uint64{fnA, fnB}; !slices.Equal(got, want) {
+		t.Errorf("Callees(main): expected %x, got %x", want, got)
+	}
+	if got, want := g.Callers(fnD), []uint64{fnA, fnE}; !slices.Equal(got, want) {
+		t.Errorf("Callers(D): expected %x, got %x", want, got)
+	}
+	want := []resurgo.CallGraphEdge{
+		{Caller: fnA, Callee: fnC, Site: 0x1110, Type: resurgo.CallSiteCall},
+		{Caller: fnA, Callee: fnD, Site: 0x1120, Type: resurgo.CallSiteJump},
+	}
+	if got := g.Calls(fnA); !slices.Equal(got, want) {
+		t.Errorf("Calls(A): expected %+v, got %+v", want, got)
+	}
+	if got := len(g.Edges()); got != 7 {
+		t.Errorf("Edges: expected 7, got %d", got)
+	}
+	if got, want := g.Roots(), []uint64{fnMain, fnE}; !slices.Equal(got, want) {
+		t.Errorf("Roots: expected %x, got %x", want, got)
+	}
+}
+
+func TestCallGraph_Reachability(t *testing.T) {
+	g := testCallGraph()
+
+	if got, want := g.Reachable(fnMain), []uint64{fnMain, fnA, fnB, fnC, fnD}; !slices.Equal(got, want) {
+		t.Errorf("Reachable(main): expected %x, got %x", want, got)
+	}
+	// An address within main stands for main.
+	if got, want := g.Dead(fnMain+4), []uint64{fnE}; !slices.Equal(got, want) {
+		t.Errorf("Dead(main): expected %x, got %x", want, got)
+	}
+}
+
+func TestCallGraph_Components(t *testing.T) {
+	g := testCallGraph()
+
+	recursive := g.Recursive()
+	slices.SortFunc(recursive, slices.Compare)
+	if want := [][]uint64{{fnA, fnC}, {fnB}}; !slices.EqualFunc(recursive, want, slices.Equal) {
+		t.Errorf("Recursive: expected %x, got %x", want, recursive)
+	}
+	if got := len(g.StronglyConnectedComponents()); got != 5 {
+		t.Errorf("StronglyConnectedComponents: expected 5, got %d", got)
+	}
+
+	order := g.TopologicalOrder()
+	if len(order) != 6 {
+		t.Fatalf("TopologicalOrder: expected 6 functions, got %x", order)
+	}
+	pos := make(map[uint64]int)
+	for i, fn := range order {
+		pos[fn] = i
+	}
+	for _, e := range g.Edges() {
+		if e.Caller == e.Callee || (e.Caller == fnC && e.Callee == fnA) {
+			continue // recursion
+		}
+		if pos[e.Caller] > pos[e.Callee] {
+			t.Errorf("TopologicalOrder %x: caller 0x%x after callee 0x%x", order, e.Caller, e.Callee)
+		}
+	}
+}
+
+func TestNewCallGraph_DetectFunctions(t *testing.T) {
+	code, base := buildSyntheticAMD64()
+	candidates, err := resurgo.DetectFunctions(code, base, resurgo.ArchAMD64)
+	if err != nil {
+		t.Fatalf("DetectFunctions: %v", err)
+	}
+	g := resurgo.NewCallGraph(candidates)
+
+	// main calls funcA, funcB and funcC, which tail calls funcK.
+	if got, want := g.Callees(base), []uint64{base + 0x40, base + 0x80, base + 0xc0}; !slices.Equal(got, want) {
+		t.Errorf("Callees(main): expected %x, got %x", want, got)
+	}
+	if got, want := g.Callers(base+0x2c0), []uint64{base + 0xc0}; !slices.Equal(got, want) {
+		t.Errorf("Callers(funcK): expected %x, got %x", want, got)
+	}
+	if dead := g.Dead(base); !slices.Contains(dead, base+0x200) {
+		t.Errorf("Dead(main): expected funcH, got %x", dead)
+	}
+}
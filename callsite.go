@@ -2,7 +2,7 @@ package resurgo
 
 import (
 	"cmp"
-	"debug/elf"
+	"context"
 	"fmt"
 	"io"
 	"slices"
@@ -43,11 +43,18 @@ const (
 
 // CallSiteEdge represents a detected call site (call or jump to a function).
 type CallSiteEdge struct {
-	SourceAddr  uint64          `json:"source_addr"`
-	TargetAddr  uint64          `json:"target_addr"`
-	Type        CallSiteType `json:"type"`
-	AddressMode AddressingMode  `json:"address_mode"`
-	Confidence  Confidence      `json:"confidence"`
+	SourceAddr  uint64         `json:"source_addr"`
+	TargetAddr  uint64         `json:"target_addr"`
+	Type        CallSiteType   `json:"type"`
+	AddressMode AddressingMode `json:"address_mode"`
+	Confidence  Confidence     `json:"confidence"`
+	// TargetSymbol is the symbol referenced by the relocation applied to
+	// the call site, when analyzing relocatable objects.
+	TargetSymbol string `json:"target_symbol,omitempty"`
+	// JumpKind classifies jumps once the extents of functions are known,
+	// by DetectFunctions and ClassifyJumps. It is empty for calls and
+	// unclassified jumps.
+	JumpKind JumpKind `json:"jump_kind,omitempty"`
 }
 
 // DetectionType represents how a function was detected.
@@ -58,7 +65,9 @@ const (
 	DetectionPrologueOnly DetectionType = "prologue-only"
 	DetectionCallTarget   DetectionType = "call-target"
 	DetectionJumpTarget   DetectionType = "jump-target"
-	DetectionBoth         DetectionType = "both" // Prologue + called/jumped to
+	DetectionBoth         DetectionType = "both"    // Prologue + called/jumped to
+	DetectionSymbol       DetectionType = "symbol"  // MiniDebugInfo symbol only
+	DetectionPadding      DetectionType = "padding" // Aligned address after padding only
 )
 
 // FunctionCandidate represents a potential function detected through
@@ -70,6 +79,24 @@ type FunctionCandidate struct {
 	CalledFrom    []uint64      `json:"called_from,omitempty"`
 	JumpedFrom    []uint64      `json:"jumped_from,omitempty"`
 	Confidence    Confidence    `json:"confidence"`
+	// AfterPadding is set when the candidate is at an aligned address
+	// right after padding between functions.
+	AfterPadding bool `json:"after_padding,omitempty"`
+	// NoReturn is set when the function never returns to its caller, as
+	// inferred by EvidenceNoReturn.
+	NoReturn bool `json:"no_return,omitempty"`
+	// Name is the symbol naming the function, when known from
+	// MiniDebugInfo.
+	Name string `json:"name,omitempty"`
+	// Size is the size in bytes of the function, when known from
+	// MiniDebugInfo, and zero otherwise.
+	Size uint64 `json:"size,omitempty"`
+	// Hash is the hex SHA-256 of the bytes of the function, with address
+	// operands masked, when set by HashFunctionsFromELF.
+	Hash string `json:"hash,omitempty"`
+	// Fingerprint holds the similarity hashes of the function, when set by
+	// FingerprintFunctionsFromELF.
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
 }
 
 // DetectCallSites analyzes raw machine code bytes and returns detected
@@ -78,11 +105,64 @@ type FunctionCandidate struct {
 // architecture-specific detection logic. This function performs no I/O and
 // works with any binary format.
 func DetectCallSites(code []byte, baseAddr uint64, arch Arch) ([]CallSiteEdge, error) {
+	return DetectCallSitesWithOptions(code, baseAddr, arch, Options{})
+}
+
+// DetectCallSitesWithOptions is like DetectCallSites but honours the
+// conditional jump, minimum confidence and address range settings in opts.
+func DetectCallSitesWithOptions(code []byte, baseAddr uint64, arch Arch, opts Options) ([]CallSiteEdge, error) {
+	return DetectCallSitesContext(context.Background(), code, baseAddr, arch, opts)
+}
+
+// DetectCallSitesContext is like DetectCallSitesWithOptions but stops with
+// the context error as soon as ctx is done, and reports progress to
+// opts.Progress.
+func DetectCallSitesContext(ctx context.Context, code []byte, baseAddr uint64, arch Arch, opts Options) ([]CallSiteEdge, error) {
+	edges, err := detectCallSites(ctx, memSource(code), baseAddr, arch, opts)
+	if err != nil {
+		return nil, err
+	}
+	return opts.filterCallSites(edges), nil
+}
+
+func detectCallSites(ctx context.Context, src source, baseAddr uint64, arch Arch, opts Options) ([]CallSiteEdge, error) {
+	newSweeper, err := callSiteSweepers(src.code, baseAddr, arch, opts)
+	if err != nil {
+		return nil, err
+	}
+	edges, err := sweepAll(src, newSweeper, opts, newTracker(ctx, opts, PhaseCallSites, src.size))
+	if err != nil {
+		return nil, err
+	}
+	opts.logger().Debug("detected call sites",
+		"arch", arch, "base", baseAddr, "size", src.size, "count", len(edges))
+
+	return edges, nil
+}
+
+// scanCallSites decodes code and passes each detected call site to yield, in
+// source address order. Decoding stops as soon as yield returns false.
+func scanCallSites(code []byte, baseAddr uint64, arch Arch, opts Options, yield func(CallSiteEdge) bool) error {
+	newSweeper, err := callSiteSweepers(code, baseAddr, arch, opts)
+	if err != nil {
+		return err
+	}
+	sweep(newSweeper(0), len(code), yield)
+	return nil
+}
+
+// callSiteSweepers returns a constructor of call site sweepers over code,
+// starting at a given offset, for the given architecture.
+func callSiteSweepers(code []byte, baseAddr uint64, arch Arch, opts Options) (func(start int) sweeper[CallSiteEdge], error) {
 	switch arch {
 	case ArchAMD64:
-		return detectCallSitesAMD64(code, baseAddr)
+		return func(start int) sweeper[CallSiteEdge] {
+			return &callSiteSweeperAMD64{code: code, baseAddr: baseAddr, offset: start, conditional: opts.IncludeConditionalJumps, kernel: opts.kernel}
+		}, nil
 	case ArchARM64:
-		return detectCallSitesARM64(code, baseAddr)
+		return func(start int) sweeper[CallSiteEdge] {
+			return &callSiteSweeperARM64{code: code, baseAddr: baseAddr, offset: start, conditional: opts.IncludeConditionalJumps}
+		}, nil
 	default:
 		return nil, fmt.Errorf("unsupported architecture: %s", arch)
 	}
@@ -92,49 +172,55 @@ func DetectCallSites(code []byte, baseAddr uint64, arch Arch) ([]CallSiteEdge, e
 // the .text section, and returns detected call sites.
 // The architecture is inferred from the ELF header.
 func DetectCallSitesFromELF(r io.ReaderAt) ([]CallSiteEdge, error) {
-	f, err := elf.NewFile(r)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse ELF file: %w", err)
-	}
-	defer f.Close()
+	return DetectCallSitesFromELFWithOptions(r, Options{})
+}
 
-	textSec := f.Section(".text")
-	if textSec == nil {
-		return nil, fmt.Errorf("no .text section found")
-	}
+// DetectCallSitesFromELFWithOptions is like DetectCallSitesFromELF but
+// analyzes the sections listed in opts and applies its remaining settings.
+// Edges are kept only if their target lies within one of the analyzed
+// sections. Results are sorted by source address.
+func DetectCallSitesFromELFWithOptions(r io.ReaderAt, opts Options) ([]CallSiteEdge, error) {
+	return DetectCallSitesFromELFContext(context.Background(), r, opts)
+}
 
-	code, err := textSec.Data()
-	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("failed to read .text section: %w", err)
+// DetectCallSitesFromELFContext is like DetectCallSitesFromELFWithOptions but
+// stops with the context error as soon as ctx is done, and reports progress
+// to opts.Progress.
+func DetectCallSitesFromELFContext(ctx context.Context, r io.ReaderAt, opts Options) ([]CallSiteEdge, error) {
+	arch, sections, err := readELFSections(r, opts)
+	if err != nil {
+		return nil, err
 	}
 
 	var edges []CallSiteEdge
-	switch f.Machine {
-	case elf.EM_X86_64:
-		edges, err = detectCallSitesAMD64(code, textSec.Addr)
-	case elf.EM_AARCH64:
-		edges, err = detectCallSitesARM64(code, textSec.Addr)
-	default:
-		return nil, fmt.Errorf("unsupported ELF machine: %s", f.Machine)
-	}
+	for _, sec := range sections {
+		opts.logger().Debug("analyzing ELF section",
+			"section", sec.name, "addr", sec.addr, "size", sec.src.size)
 
-	if err != nil {
-		return nil, err
+		opts.section = sec.name
+		secEdges, err := detectCallSites(ctx, sec.src, sec.addr, arch, opts)
+		if err != nil {
+			return nil, err
+		}
+		edges = append(edges, opts.filterCallSites(secEdges)...)
 	}
 
-	// Filter edges to only include targets within the .text section
+	// Filter edges to only include targets within the analyzed sections
 	filtered := make([]CallSiteEdge, 0, len(edges))
-	textStart := textSec.Addr
-	textEnd := textSec.Addr + textSec.Size
 	for _, edge := range edges {
-		// Only include edges with resolvable targets within .text
+		// Only include edges with resolvable targets within the sections
 		if edge.Confidence != ConfidenceNone &&
-			edge.TargetAddr >= textStart &&
-			edge.TargetAddr < textEnd {
+			slices.ContainsFunc(sections, func(s codeSection) bool { return s.contains(edge.TargetAddr) }) {
 			filtered = append(filtered, edge)
 		}
 	}
 
+	if len(sections) > 1 {
+		slices.SortStableFunc(filtered, func(a, b CallSiteEdge) int {
+			return cmp.Compare(a.SourceAddr, b.SourceAddr)
+		})
+	}
+
 	return filtered, nil
 }
 
@@ -142,17 +228,122 @@ func DetectCallSitesFromELF(r io.ReaderAt) ([]CallSiteEdge, error) {
 // function entry points with higher confidence. Functions detected by both methods
 // receive the highest confidence rating.
 func DetectFunctions(code []byte, baseAddr uint64, arch Arch) ([]FunctionCandidate, error) {
-	// Detect prologues
-	prologues, err := DetectPrologues(code, baseAddr, arch)
+	return DetectFunctionsWithOptions(code, baseAddr, arch, Options{})
+}
+
+// DetectFunctionsWithOptions is like DetectFunctions but combines only the
+// evidence sources enabled in opts and applies its remaining settings.
+func DetectFunctionsWithOptions(code []byte, baseAddr uint64, arch Arch, opts Options) ([]FunctionCandidate, error) {
+	return DetectFunctionsContext(context.Background(), code, baseAddr, arch, opts)
+}
+
+// DetectFunctionsContext is like DetectFunctionsWithOptions but stops with
+// the context error as soon as ctx is done, and reports the progress of each
+// phase to opts.Progress.
+func DetectFunctionsContext(ctx context.Context, code []byte, baseAddr uint64, arch Arch, opts Options) ([]FunctionCandidate, error) {
+	result, err := detectFunctions(ctx, memSource(code), baseAddr, arch, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to detect prologues: %w", err)
+		return nil, err
+	}
+	return opts.filterCandidates(result), nil
+}
+
+// detectFunctions runs the evidence sources enabled in opts over code and
+// merges their results, without applying the result filters of opts.
+func detectFunctions(ctx context.Context, src source, baseAddr uint64, arch Arch, opts Options) ([]FunctionCandidate, error) {
+	ev, err := detectEvidence(ctx, src, baseAddr, arch, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sections := []codeSection{{name: opts.section, addr: baseAddr, src: src}}
+	var noreturn noReturnSet
+	if opts.uses(EvidenceNoReturn) {
+		if noreturn, err = refineNoReturn(ctx, sections, &ev, arch, opts); err != nil {
+			return nil, err
+		}
+	}
+	if err := classifyEvidence(ctx, sections, &ev, arch, noreturn, opts); err != nil {
+		return nil, err
 	}
 
-	// Detect call sites
-	edges, err := DetectCallSites(code, baseAddr, arch)
+	candidates, err := mergeEvidence(ctx, ev, baseAddr, arch, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to detect call sites: %w", err)
+		return nil, err
+	}
+	markNoReturn(candidates, noreturn)
+	return candidates, nil
+}
+
+// evidence holds the detection signals gathered from code.
+type evidence struct {
+	prologues []Prologue
+	edges     []CallSiteEdge
+	gaps      []Gap
+}
+
+// append appends the signals of other to ev.
+func (ev *evidence) append(other evidence) {
+	ev.prologues = append(ev.prologues, other.prologues...)
+	ev.edges = append(ev.edges, other.edges...)
+	ev.gaps = append(ev.gaps, other.gaps...)
+}
+
+// detectEvidence detects the prologues, call sites and padding in src
+// enabled by opts.Sources.
+func detectEvidence(ctx context.Context, src source, baseAddr uint64, arch Arch, opts Options) (evidence, error) {
+	var ev evidence
+	if opts.uses(EvidencePrologue) {
+		var err error
+		ev.prologues, err = detectPrologues(ctx, src, baseAddr, arch, opts)
+		if err != nil {
+			return evidence{}, fmt.Errorf("failed to detect prologues: %w", err)
+		}
+	}
+
+	if opts.uses(EvidenceCallSite) {
+		var err error
+		ev.edges, err = detectCallSites(ctx, src, baseAddr, arch, opts)
+		if err != nil {
+			return evidence{}, fmt.Errorf("failed to detect call sites: %w", err)
+		}
+	}
+
+	if opts.uses(EvidencePadding) {
+		var err error
+		ev.gaps, err = detectPadding(ctx, src, baseAddr, arch, opts)
+		if err != nil {
+			return evidence{}, fmt.Errorf("failed to detect padding: %w", err)
+		}
+	}
+
+	return ev, nil
+}
+
+// mergeEvidence merges prologues, call sites and padding into function
+// candidates, reporting the merge phase.
+func mergeEvidence(ctx context.Context, ev evidence, baseAddr uint64, arch Arch, opts Options) ([]FunctionCandidate, error) {
+	n := len(ev.prologues) + len(ev.edges) + len(ev.gaps)
+	t := newTracker(ctx, opts, PhaseMerge, n)
+	if err := t.start(); err != nil {
+		return nil, err
+	}
+	result := mergeCandidates(ev, functionAlignment(arch))
+	if err := t.advance(n); err != nil {
+		return nil, err
 	}
+	opts.logger().Debug("merged function candidates",
+		"arch", arch, "base", baseAddr, "prologues", len(ev.prologues),
+		"call_sites", len(ev.edges), "gaps", len(ev.gaps), "count", len(result))
+
+	return result, nil
+}
+
+// mergeCandidates combines detected prologues, call site edges and padding
+// into function candidates sorted by address. Padding is only taken into
+// account when it ends on a multiple of align.
+func mergeCandidates(ev evidence, align uint64) []FunctionCandidate {
+	prologues, edges := ev.prologues, ev.edges
 
 	// Build a map of function candidates by address
 	candidates := make(map[uint64]*FunctionCandidate)
@@ -169,8 +360,10 @@ func DetectFunctions(code []byte, baseAddr uint64, arch Arch) ([]FunctionCandida
 
 	// Process call site edges  - include both high-confidence (direct calls)
 	// and medium-confidence (unconditional jumps, which may be tail calls).
+	// Jumps classified as internal never make candidates.
 	for _, edge := range edges {
-		if edge.Confidence != ConfidenceHigh && edge.Confidence != ConfidenceMedium {
+		if edge.Confidence != ConfidenceHigh && edge.Confidence != ConfidenceMedium ||
+			edge.JumpKind == JumpInternal {
 			continue
 		}
 
@@ -209,6 +402,54 @@ func DetectFunctions(code []byte, baseAddr uint64, arch Arch) ([]FunctionCandida
 		}
 	}
 
+	// Low-confidence edges (conditional jumps) are only present when
+	// requested through Options. They never upgrade an existing candidate
+	// and only add low-confidence jump targets.
+	for _, edge := range edges {
+		if edge.Confidence != ConfidenceLow || edge.JumpKind == JumpInternal {
+			continue
+		}
+
+		if candidate, exists := candidates[edge.TargetAddr]; exists {
+			candidate.JumpedFrom = append(candidate.JumpedFrom, edge.SourceAddr)
+			continue
+		}
+		candidates[edge.TargetAddr] = &FunctionCandidate{
+			Address:       edge.TargetAddr,
+			DetectionType: DetectionJumpTarget,
+			CalledFrom:    []uint64{},
+			JumpedFrom:    []uint64{edge.SourceAddr},
+			Confidence:    ConfidenceLow,
+		}
+	}
+
+	// An aligned address right after padding is the entry of a function
+	// the compiler aligned. It is independent evidence: it raises the
+	// confidence of a candidate by one level, and otherwise adds a
+	// low-confidence candidate.
+	for _, g := range ev.gaps {
+		addr := g.End()
+		if addr%align != 0 {
+			continue
+		}
+		if candidate, exists := candidates[addr]; exists {
+			candidate.AfterPadding = true
+			switch candidate.Confidence {
+			case ConfidenceMedium:
+				candidate.Confidence = ConfidenceHigh
+			case ConfidenceLow:
+				candidate.Confidence = ConfidenceMedium
+			}
+			continue
+		}
+		candidates[addr] = &FunctionCandidate{
+			Address:       addr,
+			DetectionType: DetectionPadding,
+			AfterPadding:  true,
+			Confidence:    ConfidenceLow,
+		}
+	}
+
 	// Convert map to sorted slice
 	result := make([]FunctionCandidate, 0, len(candidates))
 	for _, candidate := range candidates {
@@ -219,7 +460,7 @@ func DetectFunctions(code []byte, baseAddr uint64, arch Arch) ([]FunctionCandida
 		return cmp.Compare(a.Address, b.Address)
 	})
 
-	return result, nil
+	return result
 }
 
 // DetectFunctionsFromELF parses an ELF binary from the given reader, extracts
@@ -227,76 +468,168 @@ func DetectFunctions(code []byte, baseAddr uint64, arch Arch) ([]FunctionCandida
 // prologue detection and call site analysis.
 // The architecture is inferred from the ELF header.
 func DetectFunctionsFromELF(r io.ReaderAt) ([]FunctionCandidate, error) {
-	f, err := elf.NewFile(r)
+	return DetectFunctionsFromELFWithOptions(r, Options{})
+}
+
+// DetectFunctionsFromELFWithOptions is like DetectFunctionsFromELF but
+// analyzes the sections listed in opts and applies its remaining settings.
+// Each section is analyzed independently. Results are sorted by address.
+//
+// Compressed sections (SHF_COMPRESSED with zlib or zstd) are decompressed
+// transparently, and read whole even when opts sets a window size. When
+// the binary carries MiniDebugInfo (.gnu_debugdata), its function symbols
+// are merged into the candidates as EvidenceSymbol: they name the
+// candidate at their address and raise it to high confidence, or add a
+// DetectionSymbol candidate. Unreadable MiniDebugInfo is logged and
+// ignored.
+func DetectFunctionsFromELFWithOptions(r io.ReaderAt, opts Options) ([]FunctionCandidate, error) {
+	return DetectFunctionsFromELFContext(context.Background(), r, opts)
+}
+
+// DetectFunctionsFromELFContext is like DetectFunctionsFromELFWithOptions but
+// stops with the context error as soon as ctx is done, and reports the
+// progress of each phase to opts.Progress.
+func DetectFunctionsFromELFContext(ctx context.Context, r io.ReaderAt, opts Options) ([]FunctionCandidate, error) {
+	arch, sections, err := readELFSections(r, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse ELF file: %w", err)
+		return nil, err
+	}
+	if opts.uses(EvidenceNoReturn) {
+		if opts.noreturn, err = readELFNoReturn(r, arch, opts); err != nil {
+			return nil, err
+		}
 	}
-	defer f.Close()
 
-	textSec := f.Section(".text")
-	if textSec == nil {
-		return nil, fmt.Errorf("no .text section found")
+	var result []FunctionCandidate
+	for _, sec := range sections {
+		opts.logger().Debug("analyzing ELF section",
+			"section", sec.name, "addr", sec.addr, "size", sec.src.size)
+
+		opts.section = sec.name
+		candidates, err := detectFunctions(ctx, sec.src, sec.addr, arch, opts)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, opts.filterCandidates(candidates)...)
 	}
 
-	code, err := textSec.Data()
-	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("failed to read .text section: %w", err)
+	sorted := len(sections) == 1
+	if opts.uses(EvidenceSymbol) {
+		// MiniDebugInfo is extra evidence: a corrupt section leaves the
+		// candidates found in the code unchanged.
+		syms, err := readMiniDebugInfo(r)
+		if err != nil {
+			opts.logger().Warn("ignoring unreadable MiniDebugInfo", "error", err)
+		}
+		if len(syms) > 0 {
+			opts.logger().Debug("merging MiniDebugInfo symbols", "count", len(syms))
+			result = opts.filterCandidates(mergeSymbols(result, syms, sections))
+			sorted = false
+		}
 	}
 
-	switch f.Machine {
-	case elf.EM_X86_64:
-		return DetectFunctions(code, textSec.Addr, ArchAMD64)
-	case elf.EM_AARCH64:
-		return DetectFunctions(code, textSec.Addr, ArchARM64)
-	default:
-		return nil, fmt.Errorf("unsupported ELF machine: %s", f.Machine)
+	if !sorted {
+		slices.SortStableFunc(result, func(a, b FunctionCandidate) int {
+			return cmp.Compare(a.Address, b.Address)
+		})
 	}
+
+	return result, nil
 }
 
-func detectCallSitesAMD64(code []byte, baseAddr uint64) ([]CallSiteEdge, error) {
-	var result []CallSiteEdge
+// callSiteSweeperAMD64 detects x86-64 call sites one instruction at a time.
+type callSiteSweeperAMD64 struct {
+	code        []byte
+	baseAddr    uint64
+	offset      int
+	conditional bool
+	// kernel enables Linux kernel mode, in which calls to __fentry__ and
+	// jumps to return thunks are skipped, and branches through retpoline
+	// thunks are register-indirect.
+	kernel *kernelThunks
+}
 
-	offset := 0
-	addr := baseAddr
+func (s *callSiteSweeperAMD64) pos() int { return s.offset }
 
-	for offset < len(code) {
-		// Skip ENDBR64 (f3 0f 1e fa) and ENDBR32 (f3 0f 1e fb) which
-		// golang.org/x/arch/x86/x86asm does not recognise. These CET
-		// instructions appear at function entries on binaries compiled
-		// with -fcf-protection and are transparent to call site detection.
-		if offset+4 <= len(code) &&
-			code[offset] == 0xf3 && code[offset+1] == 0x0f &&
-			code[offset+2] == 0x1e && (code[offset+3] == 0xfa || code[offset+3] == 0xfb) {
-			offset += 4
-			addr += 4
-			continue
-		}
+func (s *callSiteSweeperAMD64) shift(code []byte) {
+	s.baseAddr += uint64(s.offset)
+	s.code, s.offset = code, 0
+}
 
-		inst, err := x86asm.Decode(code[offset:], 64)
-		if err != nil {
-			offset++
-			addr++
-			continue
-		}
+func (s *callSiteSweeperAMD64) step(out []CallSiteEdge) ([]CallSiteEdge, bool) {
+	code, offset := s.code, s.offset
+	addr := s.baseAddr + uint64(offset)
+
+	// Skip ENDBR64 (f3 0f 1e fa) and ENDBR32 (f3 0f 1e fb) which
+	// golang.org/x/arch/x86/x86asm does not recognise. These CET
+	// instructions appear at function entries on binaries compiled
+	// with -fcf-protection and are transparent to call site detection.
+	if offset+4 <= len(code) &&
+		code[offset] == 0xf3 && code[offset+1] == 0x0f &&
+		code[offset+2] == 0x1e && (code[offset+3] == 0xfa || code[offset+3] == 0xfb) {
+		s.offset += 4
+		return out, true
+	}
 
-		switch inst.Op {
-		case x86asm.CALL:
-			if edge := extractTargetAMD64(inst, addr, CallSiteCall, ConfidenceHigh); edge != nil {
-				result = append(result, *edge)
-			}
-		case x86asm.JMP:
-			// x86asm uses distinct Op values for conditional jumps (JNE, JE, JL, etc.),
-			// so Op == JMP is always unconditional.
-			if edge := extractTargetAMD64(inst, addr, CallSiteJump, ConfidenceMedium); edge != nil {
-				result = append(result, *edge)
+	inst, err := x86asm.Decode(code[offset:], 64)
+	if err != nil {
+		s.offset++
+		return out, true
+	}
+	s.offset += inst.Len
+
+	if s.kernel != nil {
+		switch s.kernel.kind(inst, addr) {
+		case thunkFentry, thunkReturn:
+			return out, true
+		case thunkIndirect:
+			cfType := CallSiteCall
+			if inst.Op == x86asm.JMP {
+				cfType = CallSiteJump
 			}
+			return append(out, CallSiteEdge{
+				SourceAddr:  addr,
+				Type:        cfType,
+				AddressMode: AddressingModeRegisterIndirect,
+				Confidence:  ConfidenceNone,
+			}), true
 		}
+	}
 
-		offset += inst.Len
-		addr += uint64(inst.Len)
+	switch inst.Op {
+	case x86asm.CALL:
+		if edge := extractTargetAMD64(inst, addr, CallSiteCall, ConfidenceHigh); edge != nil {
+			out = append(out, *edge)
+		}
+	case x86asm.JMP:
+		// x86asm uses distinct Op values for conditional jumps (JNE, JE, JL, etc.),
+		// so Op == JMP is always unconditional.
+		if edge := extractTargetAMD64(inst, addr, CallSiteJump, ConfidenceMedium); edge != nil {
+			out = append(out, *edge)
+		}
+	default:
+		// Conditional jumps are usually intra-function branches and are
+		// only reported on request (low confidence).
+		if s.conditional && isConditionalJumpAMD64(inst.Op) {
+			if edge := extractTargetAMD64(inst, addr, CallSiteJump, ConfidenceLow); edge != nil {
+				out = append(out, *edge)
+			}
+		}
 	}
 
-	return result, nil
+	return out, true
+}
+
+// isConditionalJumpAMD64 reports whether op is an x86-64 conditional jump.
+func isConditionalJumpAMD64(op x86asm.Op) bool {
+	switch op {
+	case x86asm.JA, x86asm.JAE, x86asm.JB, x86asm.JBE, x86asm.JCXZ,
+		x86asm.JE, x86asm.JECXZ, x86asm.JG, x86asm.JGE, x86asm.JL,
+		x86asm.JLE, x86asm.JNE, x86asm.JNO, x86asm.JNP, x86asm.JNS,
+		x86asm.JO, x86asm.JP, x86asm.JRCXZ, x86asm.JS:
+		return true
+	}
+	return false
 }
 
 // extractTargetAMD64 extracts the call site target from an x86-64 CALL or JMP
@@ -350,55 +683,95 @@ func extractTargetAMD64(inst x86asm.Inst, sourceAddr uint64, cfType CallSiteType
 	}
 }
 
-func detectCallSitesARM64(code []byte, baseAddr uint64) ([]CallSiteEdge, error) {
-	var result []CallSiteEdge
+// callSiteSweeperARM64 detects ARM64 call sites one instruction at a time.
+type callSiteSweeperARM64 struct {
+	code        []byte
+	baseAddr    uint64
+	offset      int
+	conditional bool
+	// dec decodes instructions; it is nil in serial sweeps.
+	dec *arm64Decoder
+}
+
+func (s *callSiteSweeperARM64) setDecoder(d *arm64Decoder) { s.dec = d }
+
+func (s *callSiteSweeperARM64) pos() int { return s.offset }
+
+func (s *callSiteSweeperARM64) shift(code []byte) {
+	s.baseAddr += uint64(s.offset)
+	s.code, s.offset = code, 0
+}
 
+func (s *callSiteSweeperARM64) step(out []CallSiteEdge) ([]CallSiteEdge, bool) {
 	const insnLen = 4
 
-	for offset := 0; offset+insnLen <= len(code); offset += insnLen {
-		inst, err := arm64asm.Decode(code[offset : offset+insnLen])
-		if err != nil {
-			continue
-		}
-		addr := baseAddr + uint64(offset)
+	offset := s.offset
+	if offset+insnLen > len(s.code) {
+		// Trailing bytes shorter than an instruction.
+		s.offset = len(s.code)
+		return out, true
+	}
+	s.offset += insnLen
 
-		switch inst.Op {
-		case arm64asm.BL:
-			if edge := extractTargetARM64(inst, addr, CallSiteCall, ConfidenceHigh); edge != nil {
-				result = append(result, *edge)
-			}
-		case arm64asm.B:
-			// B.cond (conditional branches) carry a Cond argument;
-			// they are usually intra-function branches (low confidence).
-			// Unconditional B may be a tail call (medium confidence).
-			conf := ConfidenceMedium
-			for _, arg := range inst.Args {
-				if _, ok := arg.(arm64asm.Cond); ok {
-					conf = ConfidenceLow
-					break
-				}
-			}
-			if edge := extractTargetARM64(inst, addr, CallSiteJump, conf); edge != nil {
-				result = append(result, *edge)
+	inst, err := s.dec.decode(s.code[offset : offset+insnLen])
+	if err != nil {
+		return out, true
+	}
+	addr := s.baseAddr + uint64(offset)
+
+	switch inst.Op {
+	case arm64asm.BL:
+		if edge := extractTargetARM64(inst, addr, CallSiteCall, ConfidenceHigh); edge != nil {
+			out = append(out, *edge)
+		}
+	case arm64asm.B:
+		// B.cond (conditional branches) carry a Cond argument;
+		// they are usually intra-function branches (low confidence)
+		// and are only reported on request.
+		// Unconditional B may be a tail call (medium confidence).
+		conf := ConfidenceMedium
+		for _, arg := range inst.Args {
+			if _, ok := arg.(arm64asm.Cond); ok {
+				conf = ConfidenceLow
+				break
 			}
 		}
+		if conf == ConfidenceLow && !s.conditional {
+			break
+		}
+		if edge := extractTargetARM64(inst, addr, CallSiteJump, conf); edge != nil {
+			out = append(out, *edge)
+		}
+	case arm64asm.CBZ, arm64asm.CBNZ, arm64asm.TBZ, arm64asm.TBNZ:
+		// Compare/test and branch are conditional as well.
+		if !s.conditional {
+			break
+		}
+		if edge := extractTargetARM64(inst, addr, CallSiteJump, ConfidenceLow); edge != nil {
+			out = append(out, *edge)
+		}
 	}
 
-	return result, nil
+	return out, true
 }
 
 // extractTargetARM64 extracts the PC-relative branch target from an ARM64
-// BL or B instruction. Returns nil if the first argument is not a PCRel offset.
+// branch instruction. The PCRel offset is the first argument of BL and B, and
+// follows the condition, register or bit number in conditional branches.
+// Returns nil if the instruction has no PCRel argument.
 func extractTargetARM64(inst arm64asm.Inst, sourceAddr uint64, cfType CallSiteType, confidence Confidence) *CallSiteEdge {
-	pcrel, ok := inst.Args[0].(arm64asm.PCRel)
-	if !ok {
-		return nil
-	}
-	return &CallSiteEdge{
-		SourceAddr:  sourceAddr,
-		TargetAddr:  sourceAddr + uint64(int64(pcrel)),
-		Type:        cfType,
-		AddressMode: AddressingModePCRelative,
-		Confidence:  confidence,
+	for _, arg := range inst.Args {
+		pcrel, ok := arg.(arm64asm.PCRel)
+		if !ok {
+			continue
+		}
+		return &CallSiteEdge{
+			SourceAddr:  sourceAddr,
+			TargetAddr:  sourceAddr + uint64(int64(pcrel)),
+			Type:        cfType,
+			AddressMode: AddressingModePCRelative,
+			Confidence:  confidence,
+		}
 	}
+	return nil
 }
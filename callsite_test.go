@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"testing"
 
 	"github.com/maxgio92/resurgo"
@@ -590,47 +591,49 @@ func TestDetectFunctions_UnsupportedArch(t *testing.T) {
 }
 
 func TestDetectFunctions_JumpTarget(t *testing.T) {
-	// Verify that unconditional JMPs create jump-target candidates
-	// (fix #2: medium-confidence edges now pass the filter).
+	// Verify that unconditional JMPs taken as tail calls create
+	// jump-target candidates.
 	//
 	// AMD64 code:
-	// 0x00: jmp 0x10 (E9 0B 00 00 00) - jump to 0x10
-	// 0x05: nop padding...
-	// 0x10: ret (C3) - jump target without prologue
+	// 0x00: ret (C3) - jump target without prologue
+	// 0x01: int3 padding...
+	// 0x10: push rbp; mov rbp, rsp (55 48 89 E5)
+	// 0x14: pop rbp (5D) - stack restored
+	// 0x15: jmp 0x00 (E9 E6 FF FF FF) - tail call
 
 	code := make([]byte, 0x20)
-	// jmp to 0x10: rel32 = 0x10 - (0x00 + 5) = 0x0B
-	code[0x00] = 0xE9
-	code[0x01] = 0x0B
-	code[0x02] = 0x00
-	code[0x03] = 0x00
-	code[0x04] = 0x00
-	code[0x10] = 0xC3 // ret
+	for i := range code {
+		code[i] = 0xCC
+	}
+	code[0x00] = 0xC3 // ret
+	copy(code[0x10:], []byte{0x55, 0x48, 0x89, 0xE5, 0x5D})
+	// jmp to 0x00: rel32 = 0x00 - (0x15 + 5) = -0x1A
+	copy(code[0x15:], []byte{0xE9, 0xE6, 0xFF, 0xFF, 0xFF})
 
 	candidates, err := resurgo.DetectFunctions(code, 0, resurgo.ArchAMD64)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	// Look for a jump-target candidate at 0x10
+	// Look for a jump-target candidate at 0x00
 	var found *resurgo.FunctionCandidate
 	for i := range candidates {
-		if candidates[i].Address == 0x10 {
+		if candidates[i].Address == 0x00 {
 			found = &candidates[i]
 			break
 		}
 	}
 
 	if found == nil {
-		t.Fatal("expected candidate at address 0x10, got none")
+		t.Fatal("expected candidate at address 0x00, got none")
 	}
 
 	if found.DetectionType != resurgo.DetectionJumpTarget {
 		t.Errorf("expected detection type 'jump-target', got %s", found.DetectionType)
 	}
 
-	if len(found.JumpedFrom) == 0 {
-		t.Error("expected at least one JumpedFrom entry, got none")
+	if !slices.Equal(found.JumpedFrom, []uint64{0x15}) {
+		t.Errorf("expected JumpedFrom [0x15], got %v", found.JumpedFrom)
 	}
 }
 
@@ -0,0 +1,123 @@
+// Command resurgo runs resurgo analyses from the command line.
+//
+// Usage:
+//
+//	resurgo diff [-match address|hash] [-json] OLD NEW
+//
+// The diff subcommand compares the functions recovered from two ELF
+// binaries, or two JSON arrays of candidates as written by an earlier run,
+// and prints the candidates added, removed and changed. With -match hash,
+// saved candidates must carry the content hashes set by
+// resurgo.HashFunctionsFromELF.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/maxgio92/resurgo"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "resurgo: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+const usage = "usage: resurgo diff [-match address|hash] [-json] OLD NEW"
+
+// run runs the subcommand of args, writing its output to stdout and its
+// usage to stderr.
+func run(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, usage)
+		return fmt.Errorf("missing subcommand")
+	}
+	switch args[0] {
+	case "diff":
+		return runDiff(args[1:], stdout, stderr)
+	default:
+		fmt.Fprintln(stderr, usage)
+		return fmt.Errorf("unknown subcommand: %s", args[0])
+	}
+}
+
+func runDiff(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, usage)
+		fs.PrintDefaults()
+	}
+	match := fs.String("match", string(resurgo.DiffByAddress), "align candidates by `address` (same binary) or by content hash (different builds)")
+	asJSON := fs.Bool("json", false, "write the diff as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("diff takes two files")
+	}
+	opts := resurgo.DiffOptions{Match: resurgo.DiffMatch(*match)}
+	if opts.Match != resurgo.DiffByAddress && opts.Match != resurgo.DiffByHash {
+		return fmt.Errorf("unknown match: %s", *match)
+	}
+
+	before, err := loadCandidates(fs.Arg(0), opts.Match == resurgo.DiffByHash)
+	if err != nil {
+		return err
+	}
+	after, err := loadCandidates(fs.Arg(1), opts.Match == resurgo.DiffByHash)
+	if err != nil {
+		return err
+	}
+	if opts.Match == resurgo.DiffByHash {
+		// Saved candidates carry no hash unless hashed before saving:
+		// nothing would be aligned.
+		for i, candidates := range [][]resurgo.FunctionCandidate{before, after} {
+			if !slices.ContainsFunc(candidates, func(c resurgo.FunctionCandidate) bool { return c.Hash != "" }) {
+				return fmt.Errorf("no candidate of %s has a content hash", fs.Arg(i))
+			}
+		}
+	}
+
+	d := resurgo.Diff(before, after, opts)
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	}
+	return d.WriteText(stdout)
+}
+
+// loadCandidates returns the candidates of the file at path: the functions
+// detected in it, hashed when hash is set, when it is an ELF binary, or the
+// JSON array of candidates it holds otherwise.
+func loadCandidates(path string, hash bool) ([]resurgo.FunctionCandidate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if !bytes.HasPrefix(data, []byte("\x7fELF")) {
+		var candidates []resurgo.FunctionCandidate
+		if err := json.Unmarshal(data, &candidates); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		return candidates, nil
+	}
+
+	candidates, err := resurgo.DetectFunctionsFromELF(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect functions of %s: %w", path, err)
+	}
+	if hash {
+		return resurgo.HashFunctionsFromELF(bytes.NewReader(data), candidates)
+	}
+	return candidates, nil
+}
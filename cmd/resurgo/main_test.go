@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+// writeCandidates saves candidates as a JSON array in dir and returns its
+// path.
+func writeCandidates(t *testing.T, dir, name string, candidates []resurgo.FunctionCandidate) string {
+	t.Helper()
+	data, err := json.Marshal(candidates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun_Errors(t *testing.T) {
+	dir := t.TempDir()
+	saved := writeCandidates(t, dir, "saved.json", []resurgo.FunctionCandidate{{Address: 0x1000}})
+	garbage := filepath.Join(dir, "garbage")
+	if err := os.WriteFile(garbage, []byte("not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{"no subcommand", nil, "missing subcommand"},
+		{"unknown subcommand", []string{"merge"}, "unknown subcommand: merge"},
+		{"one file", []string{"diff", saved}, "diff takes two files"},
+		{"unknown flag", []string{"diff", "-x", saved, saved}, "flag provided but not defined"},
+		{"bad match", []string{"diff", "-match", "name", saved, saved}, "unknown match: name"},
+		{"missing file", []string{"diff", saved, filepath.Join(dir, "missing")}, "failed to read"},
+		{"bad JSON", []string{"diff", saved, garbage}, "failed to parse"},
+		{"no hashes", []string{"diff", "-match", "hash", saved, saved}, "has a content hash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			err := run(tt.args, &stdout, &stderr)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestRun_Diff(t *testing.T) {
+	dir := t.TempDir()
+	before := writeCandidates(t, dir, "before.json", []resurgo.FunctionCandidate{
+		{Address: 0x1000, Hash: "aa", DetectionType: resurgo.DetectionBoth, Confidence: resurgo.ConfidenceHigh},
+		{Address: 0x1100, Hash: "bb", DetectionType: resurgo.DetectionCallTarget, Confidence: resurgo.ConfidenceMedium},
+	})
+	after := writeCandidates(t, dir, "after.json", []resurgo.FunctionCandidate{
+		{Address: 0x2000, Hash: "aa", DetectionType: resurgo.DetectionBoth, Confidence: resurgo.ConfidenceHigh},
+		{Address: 0x2100, Hash: "cc", DetectionType: resurgo.DetectionPrologueOnly, Confidence: resurgo.ConfidenceMedium},
+	})
+
+	var stdout, stderr bytes.Buffer
+	if err := run([]string{"diff", before, after}, &stdout, &stderr); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got, want := lastLine(stdout.String()), "2 added, 2 removed, 0 changed, 0 unchanged"; got != want {
+		t.Errorf("by address: expected %q, got %q", want, got)
+	}
+
+	stdout.Reset()
+	if err := run([]string{"diff", "-match", "hash", "-json", before, after}, &stdout, &stderr); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	var d resurgo.DiffResult
+	if err := json.Unmarshal(stdout.Bytes(), &d); err != nil {
+		t.Fatalf("expected a JSON diff: %v\n%s", err, stdout.String())
+	}
+	if d.Unchanged != 1 || len(d.Added) != 1 || d.Added[0].Address != 0x2100 || len(d.Removed) != 1 || d.Removed[0].Address != 0x1100 {
+		t.Errorf("by hash: unexpected diff %+v", d)
+	}
+}
+
+// lastLine returns the last line of s.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	return lines[len(lines)-1]
+}
@@ -138,10 +138,11 @@ func buildSyntheticAMD64() (code []byte, baseAddr uint64) {
 	encodeCallRel32(code, offFuncB+9, base, base+uint64(offFuncF))
 	code[offFuncB+14] = 0xC3 // ret
 
-	// funcC: classic prologue, call funcJ, jmp funcK (tail call)
+	// funcC: classic prologue, call funcJ, pop rbp, jmp funcK (tail call)
 	classicPrologue(offFuncC)
 	encodeCallRel32(code, offFuncC+4, base, base+uint64(offFuncJ))
-	encodeJmpRel32(code, offFuncC+9, base, base+uint64(offFuncK))
+	code[offFuncC+9] = 0x5D // pop rbp
+	encodeJmpRel32(code, offFuncC+10, base, base+uint64(offFuncK))
 
 	// funcD: classic prologue
 	classicPrologue(offFuncD)
@@ -151,9 +152,10 @@ func buildSyntheticAMD64() (code []byte, baseAddr uint64) {
 	classicPrologue(offFuncE)
 	code[offFuncE+4] = 0xC3 // ret
 
-	// funcF: classic prologue, jmp funcG (tail call)
+	// funcF: classic prologue, pop rbp, jmp funcG (tail call)
 	classicPrologue(offFuncF)
-	encodeJmpRel32(code, offFuncF+4, base, base+uint64(offFuncG))
+	code[offFuncF+4] = 0x5D // pop rbp
+	encodeJmpRel32(code, offFuncF+5, base, base+uint64(offFuncG))
 
 	// funcG: classic prologue
 	classicPrologue(offFuncG)
@@ -200,6 +202,7 @@ func buildSyntheticARM64() (code []byte, baseAddr uint64) {
 	const (
 		stpX29X30 = uint32(0xa9bf7bfd) // stp x29, x30, [sp, #-16]!
 		movX29SP  = uint32(0x910003fd) // mov x29, sp
+		ldpX29X30 = uint32(0xa8c17bfd) // ldp x29, x30, [sp], #16
 		subSPImm  = uint32(0xd10083ff) // sub sp, sp, #0x20
 		arm64RET  = uint32(0xd65f03c0) // ret
 		blOp      = uint32(0x94000000) // BL base opcode
@@ -255,10 +258,11 @@ func buildSyntheticARM64() (code []byte, baseAddr uint64) {
 	putInsn(offFuncB+12, bl(offFuncB+12, offFuncF))
 	putInsn(offFuncB+16, arm64RET)
 
-	// funcC: STP frame pair, BL funcJ, B funcK (tail jump)
+	// funcC: STP frame pair, BL funcJ, LDP, B funcK (tail jump)
 	stpPrologue(offFuncC)
 	putInsn(offFuncC+8, bl(offFuncC+8, offFuncJ))
-	putInsn(offFuncC+12, b(offFuncC+12, offFuncK))
+	putInsn(offFuncC+12, ldpX29X30)
+	putInsn(offFuncC+16, b(offFuncC+16, offFuncK))
 
 	// funcD: STP frame pair
 	stpPrologue(offFuncD)
@@ -268,9 +272,10 @@ func buildSyntheticARM64() (code []byte, baseAddr uint64) {
 	stpPrologue(offFuncE)
 	putInsn(offFuncE+8, arm64RET)
 
-	// funcF: STP frame pair, B funcG (tail jump)
+	// funcF: STP frame pair, LDP, B funcG (tail jump)
 	stpPrologue(offFuncF)
-	putInsn(offFuncF+8, b(offFuncF+8, offFuncG))
+	putInsn(offFuncF+8, ldpX29X30)
+	putInsn(offFuncF+12, b(offFuncF+12, offFuncG))
 
 	// funcG: STP-only (stp x29, x30 followed by NOP, not mov x29, sp)
 	putInsn(offFuncG, stpX29X30)
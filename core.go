@@ -0,0 +1,283 @@
+package resurgo
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+)
+
+// ntFile is the type of the core note listing the files mapped by the
+// process (NT_FILE).
+const ntFile = 0x46494c45
+
+// fileMapping is an entry of the NT_FILE core note.
+type fileMapping struct {
+	start, end, offset uint64
+	path               string
+}
+
+// DetectFunctionsFromCore parses an ELF core dump from r, reconstructs the
+// executable mappings of the crashed process from its PT_LOAD segments and
+// NT_FILE note, and detects function candidates in each of them, using the
+// segment address as base address. Results are labelled with the file mapped
+// at each segment, or have an empty path for anonymous memory.
+//
+// Only the bytes saved in the core are analyzed: file-backed text is often
+// left out of cores by the kernel (see coredump_filter in core(5)), and such
+// segments yield no candidates.
+func DetectFunctionsFromCore(r io.ReaderAt) ([]MappingFunctions, error) {
+	return DetectFunctionsFromCoreContext(context.Background(), r, Options{})
+}
+
+// DetectFunctionsFromCoreWithOptions is like DetectFunctionsFromCore but
+// applies the settings in opts to each mapping. Options.Sections does not
+// apply.
+func DetectFunctionsFromCoreWithOptions(r io.ReaderAt, opts Options) ([]MappingFunctions, error) {
+	return DetectFunctionsFromCoreContext(context.Background(), r, opts)
+}
+
+// DetectFunctionsFromCoreContext is like DetectFunctionsFromCoreWithOptions
+// but stops with the context error as soon as ctx is done, and reports
+// progress to opts.Progress, labelled with the mapping path.
+func DetectFunctionsFromCoreContext(ctx context.Context, r io.ReaderAt, opts Options) ([]MappingFunctions, error) {
+	img, err := newCoreImage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []MappingFunctions
+	for i, m := range img.mappings {
+		prog := img.progs[i]
+		if prog.Flags&elf.PF_X == 0 {
+			continue
+		}
+
+		if prog.Filesz == 0 {
+			opts.logger().Debug("skipping mapping not saved in core",
+				"path", m.Path, "start", m.Start)
+			continue
+		}
+
+		src, err := mappingSource(prog.ReaderAt, int(prog.Filesz), opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read segment 0x%x: %w", prog.Vaddr, err)
+		}
+
+		mf, err := detectMappingFunctions(ctx, src, m, img.arch, opts)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, mf)
+	}
+
+	return result, nil
+}
+
+// coreImage is the Image of an ELF core dump, made of its PT_LOAD segments.
+type coreImage struct {
+	arch     Arch
+	progs    []*elf.Prog
+	mappings []Mapping
+}
+
+// NewCoreImage parses an ELF core dump from r and returns its Image, with a
+// segment for each PT_LOAD segment, named after the file mapped at its
+// address, if any. Only the bytes saved in the core can be read.
+func NewCoreImage(r io.ReaderAt) (Image, error) {
+	return newCoreImage(r)
+}
+
+func newCoreImage(r io.ReaderAt) (*coreImage, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ELF file: %w", err)
+	}
+
+	if f.Type != elf.ET_CORE {
+		return nil, fmt.Errorf("not an ELF core file: %s", f.Type)
+	}
+
+	arch, err := elfArch(f.Machine)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := readCoreFiles(f)
+	if err != nil {
+		return nil, err
+	}
+
+	img := &coreImage{arch: arch}
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+
+		m := Mapping{
+			Start: prog.Vaddr,
+			End:   prog.Vaddr + prog.Memsz,
+			Perms: corePerms(prog.Flags),
+		}
+		for _, fm := range files {
+			if m.Start >= fm.start && m.Start < fm.end {
+				m.Path = fm.path
+				m.Offset = fm.offset + m.Start - fm.start
+				break
+			}
+		}
+		img.progs = append(img.progs, prog)
+		img.mappings = append(img.mappings, m)
+	}
+
+	return img, nil
+}
+
+func (img *coreImage) Arch() Arch { return img.arch }
+
+func (img *coreImage) Segments() []ImageSegment {
+	segments := make([]ImageSegment, len(img.mappings))
+	for i, m := range img.mappings {
+		segments[i] = ImageSegment{Name: m.Path, Addr: m.Start, Size: m.End - m.Start, Perms: m.Perms}
+	}
+	return segments
+}
+
+func (img *coreImage) SegmentReader(i int) (io.ReaderAt, error) {
+	return img.progs[i].ReaderAt, nil
+}
+
+// corePerms formats segment flags like the permissions of /proc/<pid>/maps.
+func corePerms(flags elf.ProgFlag) string {
+	perms := []byte("---")
+	if flags&elf.PF_R != 0 {
+		perms[0] = 'r'
+	}
+	if flags&elf.PF_W != 0 {
+		perms[1] = 'w'
+	}
+	if flags&elf.PF_X != 0 {
+		perms[2] = 'x'
+	}
+	return string(perms)
+}
+
+// readCoreFiles returns the file mappings listed in the NT_FILE notes of a
+// core file.
+func readCoreFiles(f *elf.File) ([]fileMapping, error) {
+	var files []fileMapping
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+
+		// The segment is read as far as the file goes, whatever size its
+		// header claims.
+		data, err := io.ReadAll(prog.Open())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read core notes: %w", err)
+		}
+
+		for note, err := range elfNotes(data, f.ByteOrder) {
+			if err != nil {
+				return nil, fmt.Errorf("failed to read core notes: %w", err)
+			}
+			if note.name == "CORE" && note.typ == ntFile {
+				entries, err := parseNTFile(note.desc, f.Class, f.ByteOrder)
+				if err != nil {
+					return nil, err
+				}
+				files = append(files, entries...)
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// parseNTFile parses the descriptor of an NT_FILE note: a count of entries
+// and the page size, then the start, end and page offset of each entry,
+// then the NUL-terminated paths of all entries.
+func parseNTFile(desc []byte, class elf.Class, order binary.ByteOrder) ([]fileMapping, error) {
+	word := 8
+	readWord := func(b []byte) uint64 { return order.Uint64(b) }
+	if class == elf.ELFCLASS32 {
+		word = 4
+		readWord = func(b []byte) uint64 { return uint64(order.Uint32(b)) }
+	}
+
+	if len(desc) < 2*word {
+		return nil, fmt.Errorf("malformed NT_FILE note")
+	}
+	count := readWord(desc)
+	pageSize := readWord(desc[word:])
+	desc = desc[2*word:]
+	if count > uint64(len(desc)/(3*word)) {
+		return nil, fmt.Errorf("malformed NT_FILE note")
+	}
+
+	files := make([]fileMapping, count)
+	for i := range files {
+		files[i].start = readWord(desc)
+		files[i].end = readWord(desc[word:])
+		files[i].offset = readWord(desc[2*word:]) * pageSize
+		desc = desc[3*word:]
+	}
+	for i := range files {
+		path, rest, ok := bytes.Cut(desc, []byte{0})
+		if !ok {
+			return nil, fmt.Errorf("malformed NT_FILE note")
+		}
+		files[i].path = string(path)
+		desc = rest
+	}
+
+	return files, nil
+}
+
+// elfNote is a note of an ELF note section or segment.
+type elfNote struct {
+	// name is the owner of the note, without its terminating NUL.
+	name string
+	typ  uint32
+	desc []byte
+}
+
+// errMalformedNote is yielded by elfNotes for a note overrunning its data.
+var errMalformedNote = errors.New("malformed note")
+
+// elfNotes returns an iterator over the notes of data, the contents of a
+// note section or segment, read with order. Each note is a header of name
+// size, descriptor size and type, followed by the name and the descriptor,
+// each padded to 4 bytes. Iteration stops after yielding errMalformedNote
+// for a note overrunning data.
+func elfNotes(data []byte, order binary.ByteOrder) iter.Seq2[elfNote, error] {
+	align4 := func(n uint64) uint64 { return (n + 3) &^ 3 }
+	return func(yield func(elfNote, error) bool) {
+		for len(data) >= 12 {
+			namesz := uint64(order.Uint32(data[0:]))
+			descsz := uint64(order.Uint32(data[4:]))
+			typ := order.Uint32(data[8:])
+			descOff := 12 + align4(namesz)
+			next := descOff + align4(descsz)
+			if next > uint64(len(data)) {
+				yield(elfNote{}, errMalformedNote)
+				return
+			}
+			note := elfNote{
+				name: strings.TrimSuffix(string(data[12:12+namesz]), "\x00"),
+				typ:  typ,
+				desc: data[descOff : descOff+descsz],
+			}
+			if !yield(note, nil) {
+				return
+			}
+			data = data[next:]
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package resurgo_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+// coreSegment describes a PT_LOAD segment of a synthetic core file.
+type coreSegment struct {
+	vaddr uint64
+	memsz uint64
+	flags elf.ProgFlag
+	data  []byte
+	// path is listed in the NT_FILE note when non-empty.
+	path string
+}
+
+// buildCore returns an x86-64 ELF core file holding segs and an NT_FILE note
+// for the file-backed ones.
+func buildCore(segs []coreSegment) []byte {
+	le := binary.LittleEndian
+
+	// NT_FILE descriptor: count, page size, (start, end, page offset)...,
+	// then the paths.
+	var files []coreSegment
+	for _, s := range segs {
+		if s.path != "" {
+			files = append(files, s)
+		}
+	}
+	var desc []byte
+	desc = le.AppendUint64(desc, uint64(len(files)))
+	desc = le.AppendUint64(desc, 0x1000)
+	for _, s := range files {
+		desc = le.AppendUint64(desc, s.vaddr)
+		desc = le.AppendUint64(desc, s.vaddr+s.memsz)
+		desc = le.AppendUint64(desc, 0)
+	}
+	for _, s := range files {
+		desc = append(desc, s.path...)
+		desc = append(desc, 0)
+	}
+	for len(desc)%4 != 0 {
+		desc = append(desc, 0)
+	}
+
+	var note []byte
+	note = le.AppendUint32(note, 5) // "CORE\x00"
+	note = le.AppendUint32(note, uint32(len(desc)))
+	note = le.AppendUint32(note, 0x46494c45) // NT_FILE
+	note = append(note, "CORE\x00\x00\x00\x00"...)
+	note = append(note, desc...)
+
+	const ehsize, phentsize = 64, 56
+	off := uint64(ehsize + phentsize*(len(segs)+1))
+
+	var phdrs, body bytes.Buffer
+	writePhdr := func(typ elf.ProgType, flags elf.ProgFlag, offset, vaddr, filesz, memsz uint64) {
+		binary.Write(&phdrs, le, elf.Prog64{
+			Type: uint32(typ), Flags: uint32(flags), Off: offset,
+			Vaddr: vaddr, Filesz: filesz, Memsz: memsz, Align: 1,
+		})
+	}
+	writePhdr(elf.PT_NOTE, 0, off, 0, uint64(len(note)), 0)
+	body.Write(note)
+	off += uint64(len(note))
+	for _, s := range segs {
+		writePhdr(elf.PT_LOAD, s.flags, off, s.vaddr, uint64(len(s.data)), s.memsz)
+		body.Write(s.data)
+		off += uint64(len(s.data))
+	}
+
+	var buf bytes.Buffer
+	hdr := elf.Header64{
+		Type: uint16(elf.ET_CORE), Machine: uint16(elf.EM_X86_64), Version: 1,
+		Phoff: ehsize, Ehsize: ehsize, Phentsize: phentsize, Phnum: uint16(len(segs) + 1),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	binary.Write(&buf, le, hdr)
+	buf.Write(phdrs.Bytes())
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+func TestDetectFunctionsFromCore(t *testing.T) {
+	code, base := buildSyntheticAMD64()
+	want, err := resurgo.DetectFunctions(code, base, resurgo.ArchAMD64)
+	if err != nil {
+		t.Fatalf("DetectFunctions: %v", err)
+	}
+
+	const jitBase = 0x7f0000000000
+	core := buildCore([]coreSegment{
+		{vaddr: base, memsz: uint64(len(code)), flags: elf.PF_R | elf.PF_X, data: code, path: "/usr/bin/daemon"},
+		// Data segments and text left out of the core are skipped.
+		{vaddr: 0x10000, memsz: 0x1000, flags: elf.PF_R | elf.PF_W, data: make([]byte, 0x10)},
+		{vaddr: 0x20000, memsz: 0x1000, flags: elf.PF_R | elf.PF_X, path: "/usr/lib/libc.so.6"},
+		{vaddr: jitBase, memsz: uint64(len(code)), flags: elf.PF_R | elf.PF_W | elf.PF_X, data: code},
+	})
+
+	for _, opts := range []resurgo.Options{{}, {WindowSize: 64}} {
+		results, err := resurgo.DetectFunctionsFromCoreWithOptions(bytes.NewReader(core), opts)
+		if err != nil {
+			t.Fatalf("DetectFunctionsFromCoreWithOptions(%+v): %v", opts, err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("expected 2 mappings, got %d", len(results))
+		}
+
+		daemon := results[0]
+		if daemon.Mapping.Path != "/usr/bin/daemon" || daemon.Mapping.Perms != "r-x" {
+			t.Errorf("unexpected mapping: %+v", daemon.Mapping)
+		}
+		if len(daemon.Functions) != len(want) {
+			t.Errorf("expected %d candidates in the file mapping, got %d", len(want), len(daemon.Functions))
+		}
+
+		jit := results[1]
+		if !jit.Mapping.Anonymous() || jit.Mapping.Start != jitBase {
+			t.Errorf("expected anonymous mapping at 0x%x, got %+v", uint64(jitBase), jit.Mapping)
+		}
+		if len(jit.Functions) != len(want) {
+			t.Errorf("expected %d candidates in the anonymous mapping, got %d", len(want), len(jit.Functions))
+		}
+		for _, c := range jit.Functions {
+			if c.Address < jitBase {
+				t.Errorf("candidate 0x%x not rebased to the mapping", c.Address)
+			}
+		}
+	}
+}
+
+func TestDetectFunctionsFromCore_NotCore(t *testing.T) {
+	f := buildCore(nil)
+	binary.LittleEndian.PutUint16(f[16:], uint16(elf.ET_EXEC))
+	if _, err := resurgo.DetectFunctionsFromCore(bytes.NewReader(f)); err == nil {
+		t.Error("expected error for non-core ELF file")
+	}
+}
+
+func TestDetectFunctionsFromCore_Malformed(t *testing.T) {
+	code, base := buildSyntheticAMD64()
+	core := buildCore([]coreSegment{
+		{vaddr: base, memsz: uint64(len(code)), flags: elf.PF_R | elf.PF_X, data: code, path: "/usr/bin/daemon"},
+	})
+
+	t.Run("foreign note", func(t *testing.T) {
+		// A note of another owner with the type of NT_FILE is not the file
+		// table: the mapping is anonymous.
+		foreign := bytes.Replace(core, []byte("CORE\x00"), []byte("XORE\x00"), 1)
+		results, err := resurgo.DetectFunctionsFromCore(bytes.NewReader(foreign))
+		if err != nil {
+			t.Fatalf("DetectFunctionsFromCore: %v", err)
+		}
+		if len(results) != 1 || !results[0].Mapping.Anonymous() {
+			t.Errorf("expected an anonymous mapping, got %+v", results)
+		}
+	})
+
+	t.Run("oversized notes", func(t *testing.T) {
+		// The PT_NOTE header, first after the ELF header, claims 1 TiB.
+		huge := bytes.Clone(core)
+		binary.LittleEndian.PutUint64(huge[64+32:], 1<<40)
+		_, err := resurgo.DetectFunctionsFromCore(bytes.NewReader(huge))
+		if err == nil || !strings.Contains(err.Error(), "malformed note") {
+			t.Errorf("expected a malformed note error, got %v", err)
+		}
+	})
+}
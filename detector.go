@@ -1,9 +1,12 @@
 package resurgo
 
 import (
-	"debug/elf"
+	"cmp"
+	"context"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"slices"
 
 	"golang.org/x/arch/arm64/arm64asm"
 	"golang.org/x/arch/x86/x86asm"
@@ -14,98 +17,249 @@ import (
 // arch selects the architecture-specific detection logic.
 // This function performs no I/O and works with any binary format.
 func DetectPrologues(code []byte, baseAddr uint64, arch Arch) ([]Prologue, error) {
+	return DetectProloguesWithOptions(code, baseAddr, arch, Options{})
+}
+
+// DetectProloguesWithOptions is like DetectPrologues but applies the address
+// range restrictions in opts to the detected prologues.
+func DetectProloguesWithOptions(code []byte, baseAddr uint64, arch Arch, opts Options) ([]Prologue, error) {
+	return DetectProloguesContext(context.Background(), code, baseAddr, arch, opts)
+}
+
+// DetectProloguesContext is like DetectProloguesWithOptions but stops with
+// the context error as soon as ctx is done, and reports progress to
+// opts.Progress.
+func DetectProloguesContext(ctx context.Context, code []byte, baseAddr uint64, arch Arch, opts Options) ([]Prologue, error) {
+	prologues, err := detectPrologues(ctx, memSource(code), baseAddr, arch, opts)
+	if err != nil {
+		return nil, err
+	}
+	return opts.filterPrologues(prologues), nil
+}
+
+func detectPrologues(ctx context.Context, src source, baseAddr uint64, arch Arch, opts Options) ([]Prologue, error) {
+	newSweeper, err := prologueSweepers(src.code, baseAddr, arch, opts)
+	if err != nil {
+		return nil, err
+	}
+	prologues, err := sweepAll(src, newSweeper, opts, newTracker(ctx, opts, PhasePrologues, src.size))
+	if err != nil {
+		return nil, err
+	}
+	opts.logger().Debug("detected prologues",
+		"arch", arch, "base", baseAddr, "size", src.size, "count", len(prologues))
+
+	return prologues, nil
+}
+
+// scanPrologues decodes code and passes each detected prologue to yield, in
+// the order in which they are found. Decoding stops as soon as yield returns
+// false.
+func scanPrologues(code []byte, baseAddr uint64, arch Arch, opts Options, yield func(Prologue) bool) error {
+	newSweeper, err := prologueSweepers(code, baseAddr, arch, opts)
+	if err != nil {
+		return err
+	}
+	sweep(newSweeper(0), len(code), yield)
+	return nil
+}
+
+// prologueSweepers returns a constructor of prologue sweepers over code,
+// starting at a given offset, for the given architecture.
+func prologueSweepers(code []byte, baseAddr uint64, arch Arch, opts Options) (func(start int) sweeper[Prologue], error) {
 	switch arch {
 	case ArchAMD64:
-		return detectProloguesAMD64(code, baseAddr)
+		return func(start int) sweeper[Prologue] {
+			return &prologueSweeperAMD64{code: code, baseAddr: baseAddr, offset: start, kernel: opts.kernel, noreturn: opts.noreturn}
+		}, nil
 	case ArchARM64:
-		return detectProloguesARM64(code, baseAddr)
+		return func(start int) sweeper[Prologue] {
+			return &prologueSweeperARM64{code: code, baseAddr: baseAddr, offset: start, noreturn: opts.noreturn}
+		}, nil
 	default:
 		return nil, fmt.Errorf("unsupported architecture: %s", arch)
 	}
 }
 
-func detectProloguesAMD64(code []byte, baseAddr uint64) ([]Prologue, error) {
-	var result []Prologue
+// prologueSweeperAMD64 detects x86-64 prologues one instruction at a time.
+type prologueSweeperAMD64 struct {
+	code     []byte
+	baseAddr uint64
+	offset   int
+	prevInsn *x86asm.Inst
+	// kernel enables Linux kernel mode, in which ftrace call sites and
+	// return thunks are transparent.
+	kernel *kernelThunks
+	// entry is the address of the first of the transparent instructions
+	// preceding the next one, if hasEntry is set. prevEntry is the entry of
+	// prevInsn: prologues are reported at the entry rather than at their
+	// first instruction.
+	entry     uint64
+	hasEntry  bool
+	prevEntry uint64
+	// padded is set when prevInsn ends a run of padding between functions:
+	// int3, or NOPs following an instruction that does not fall through.
+	padded bool
+	// noreturn holds the targets of calls that never return. prevNoReturn
+	// is set when prevInsn is such a call, which ends a function like RET.
+	noreturn     noReturnSet
+	prevNoReturn bool
+}
 
-	offset := 0
-	addr := baseAddr
-	var prevInsn *x86asm.Inst
-
-	for offset < len(code) {
-		// Skip ENDBR64 (f3 0f 1e fa) and ENDBR32 (f3 0f 1e fb) which
-		// golang.org/x/arch/x86/x86asm does not recognise. These CET
-		// instructions appear at function entries on binaries compiled
-		// with -fcf-protection and are transparent to prologue detection.
-		if offset+4 <= len(code) &&
-			code[offset] == 0xf3 && code[offset+1] == 0x0f &&
-			code[offset+2] == 0x1e && (code[offset+3] == 0xfa || code[offset+3] == 0xfb) {
-			offset += 4
-			addr += 4
-			continue // prevInsn intentionally unchanged
-		}
+func (s *prologueSweeperAMD64) pos() int { return s.offset }
 
-		inst, err := x86asm.Decode(code[offset:], 64)
-		if err != nil {
-			offset++
-			addr++
-			prevInsn = nil
-			continue
-		}
+func (s *prologueSweeperAMD64) shift(code []byte) {
+	s.baseAddr += uint64(s.offset)
+	s.code, s.offset = code, 0
+}
 
-		// Pattern 1: Classic frame pointer setup - push rbp; mov rbp, rsp
-		if prevInsn != nil &&
-			prevInsn.Op == x86asm.PUSH && prevInsn.Args[0] == x86asm.RBP &&
-			inst.Op == x86asm.MOV && inst.Args[0] == x86asm.RBP && inst.Args[1] == x86asm.RSP {
-			result = append(result, Prologue{
-				Address:      addr - uint64(prevInsn.Len),
-				Type:         PrologueClassic,
-				Instructions: "push rbp; mov rbp, rsp",
-			})
-		}
+func (s *prologueSweeperAMD64) step(out []Prologue) ([]Prologue, bool) {
+	code, offset := s.code, s.offset
+	addr := s.baseAddr + uint64(offset)
 
-		// Pattern 2: No-frame-pointer function - sub rsp, imm
-		if inst.Op == x86asm.SUB && inst.Args[0] == x86asm.RSP {
-			if imm, ok := inst.Args[1].(x86asm.Imm); ok && imm > 0 {
-				if prevInsn == nil || prevInsn.Op == x86asm.RET || prevInsn.Op == x86asm.PUSH {
-					result = append(result, Prologue{
-						Address:      addr,
-						Type:         PrologueNoFramePointer,
-						Instructions: fmt.Sprintf("sub rsp, 0x%x", int64(imm)),
-					})
-				}
+	// Skip ENDBR64 (f3 0f 1e fa) and ENDBR32 (f3 0f 1e fb) which
+	// golang.org/x/arch/x86/x86asm does not recognise. These CET
+	// instructions appear at function entries on binaries compiled
+	// with -fcf-protection and are transparent to prologue detection.
+	if offset+4 <= len(code) &&
+		code[offset] == 0xf3 && code[offset+1] == 0x0f &&
+		code[offset+2] == 0x1e && (code[offset+3] == 0xfa || code[offset+3] == 0xfb) {
+		s.offset += 4
+		s.markEntry(addr)
+		return out, false // prevInsn intentionally unchanged
+	}
+
+	inst, err := x86asm.Decode(code[offset:], 64)
+	if err != nil {
+		s.offset++
+		s.prevInsn = nil
+		s.hasEntry, s.padded, s.prevNoReturn = false, false, false
+		return out, true
+	}
+
+	if s.kernel != nil {
+		switch {
+		case isFentrySiteAMD64(inst, addr, s.kernel):
+			// ftrace patch site at function entry: call __fentry__ or the
+			// 5-byte NOP it is replaced with. A NOP is only taken as the
+			// entry on an aligned address or after ENDBR, as compilers also
+			// emit 5-byte NOPs for alignment padding.
+			s.offset += inst.Len
+			if inst.Op == x86asm.CALL || s.hasEntry || addr%16 == 0 {
+				s.markEntry(addr)
 			}
+			return out, false
+		case s.kernel.kind(inst, addr) == thunkReturn:
+			// jmp __x86_return_thunk stands for ret.
+			s.prevInsn = &x86asm.Inst{Op: x86asm.RET, Len: inst.Len}
+			s.offset += inst.Len
+			s.hasEntry, s.padded, s.prevNoReturn = false, false, false
+			return out, true
 		}
+	}
 
-		// Pattern 3: Push callee-saved register at function boundary
-		if inst.Op == x86asm.PUSH {
-			if reg, ok := inst.Args[0].(x86asm.Reg); ok && isCalleeSavedAMD64(reg) {
-				if prevInsn == nil || prevInsn.Op == x86asm.RET {
-					result = append(result, Prologue{
-						Address:      addr,
-						Type:         ProloguePushOnly,
-						Instructions: fmt.Sprintf("push %s", reg),
-					})
-				}
+	prevInsn := s.prevInsn
+	// The previous function ended on prevInsn.
+	ended := prevInsn == nil || prevInsn.Op == x86asm.RET || s.prevNoReturn
+	entry, settled := addr, !s.hasEntry
+	if s.hasEntry {
+		entry = s.entry
+	}
+
+	// Pattern 1: Classic frame pointer setup - push rbp; mov rbp, rsp
+	if prevInsn != nil &&
+		prevInsn.Op == x86asm.PUSH && prevInsn.Args[0] == x86asm.RBP &&
+		inst.Op == x86asm.MOV && inst.Args[0] == x86asm.RBP && inst.Args[1] == x86asm.RSP {
+		out = append(out, Prologue{
+			Address:      s.prevEntry,
+			Type:         PrologueClassic,
+			Instructions: "push rbp; mov rbp, rsp",
+		})
+	}
+
+	// Pattern 2: No-frame-pointer function - sub rsp, imm
+	if inst.Op == x86asm.SUB && inst.Args[0] == x86asm.RSP {
+		if imm, ok := inst.Args[1].(x86asm.Imm); ok && imm > 0 {
+			if ended || prevInsn.Op == x86asm.PUSH {
+				out = append(out, Prologue{
+					Address:      entry,
+					Type:         PrologueNoFramePointer,
+					Instructions: fmt.Sprintf("sub rsp, 0x%x", int64(imm)),
+				})
 			}
 		}
+	}
 
-		// Pattern 4: Stack allocation with lea - lea rsp, [rsp-imm]
-		if inst.Op == x86asm.LEA && inst.Args[0] == x86asm.RSP {
-			if prevInsn == nil || prevInsn.Op == x86asm.RET {
-				result = append(result, Prologue{
-					Address:      addr,
-					Type:         PrologueLEABased,
-					Instructions: "lea rsp, [rsp-offset]",
+	// Pattern 3: Push callee-saved register at function boundary. Padding
+	// usually sits between the RET of a function, or its call to a
+	// non-returning function, and the next one.
+	if inst.Op == x86asm.PUSH {
+		if reg, ok := inst.Args[0].(x86asm.Reg); ok && isCalleeSavedAMD64(reg) {
+			if ended || s.padded {
+				out = append(out, Prologue{
+					Address:      entry,
+					Type:         ProloguePushOnly,
+					Instructions: fmt.Sprintf("push %s", reg),
 				})
 			}
 		}
+	}
 
-		prevInsn = &inst
-		offset += inst.Len
-		addr += uint64(inst.Len)
+	// Pattern 4: Stack allocation with lea - lea rsp, [rsp-imm]
+	if inst.Op == x86asm.LEA && inst.Args[0] == x86asm.RSP {
+		if ended || s.padded {
+			out = append(out, Prologue{
+				Address:      entry,
+				Type:         PrologueLEABased,
+				Instructions: "lea rsp, [rsp-offset]",
+			})
+		}
 	}
 
-	return result, nil
+	// A NOP continues the padding run of prevInsn, so the state after it
+	// depends on the instructions before.
+	switch {
+	case isInt3AMD64(inst):
+		s.padded = true
+	case inst.Op == x86asm.NOP:
+		settled = false
+		if prevInsn == nil || prevInsn.Op != x86asm.NOP {
+			s.padded = prevInsn != nil && (isTerminalAMD64(prevInsn.Op) || s.prevNoReturn)
+		}
+	default:
+		s.padded = false
+	}
+
+	s.prevInsn = &inst
+	s.prevNoReturn = s.noreturn.callAMD64(inst, addr)
+	s.prevEntry, s.hasEntry = entry, false
+	s.offset += inst.Len
+	return out, settled
+}
+
+// markEntry records addr as the entry of the next prologue, if it is the
+// first of a run of transparent instructions. Prologues are reported at
+// their entry only in kernel mode.
+func (s *prologueSweeperAMD64) markEntry(addr uint64) {
+	if s.kernel != nil && !s.hasEntry {
+		s.entry, s.hasEntry = addr, true
+	}
+}
+
+// isInt3AMD64 reports whether inst is int3, which compilers pad between
+// functions with.
+func isInt3AMD64(inst x86asm.Inst) bool {
+	return inst.Op == x86asm.INT && inst.Args[0] == x86asm.Imm(3)
+}
+
+// isTerminalAMD64 reports whether an x86-64 instruction with opcode op does
+// not fall through to the next instruction.
+func isTerminalAMD64(op x86asm.Op) bool {
+	switch op {
+	case x86asm.RET, x86asm.JMP, x86asm.UD2, x86asm.HLT, x86asm.INT:
+		return true
+	}
+	return false
 }
 
 func isCalleeSavedAMD64(reg x86asm.Reg) bool {
@@ -140,100 +294,144 @@ func isMovX29SP(inst arm64asm.Inst) bool {
 	return ok0 && ok1 && r0 == arm64asm.RegSP(arm64asm.X29) && r1 == arm64asm.RegSP(arm64asm.SP)
 }
 
-func detectProloguesARM64(code []byte, baseAddr uint64) ([]Prologue, error) {
-	var result []Prologue
+// prologueSweeperARM64 detects ARM64 prologues one instruction at a time.
+type prologueSweeperARM64 struct {
+	code     []byte
+	baseAddr uint64
+	offset   int
+	prevInsn *arm64asm.Inst
+	// noreturn holds the targets of calls that never return. prevNoReturn
+	// is set when prevInsn is such a call, which ends a function like RET.
+	noreturn     noReturnSet
+	prevNoReturn bool
+	// dec decodes instructions; it is nil in serial sweeps.
+	dec *arm64Decoder
+}
+
+func (s *prologueSweeperARM64) setDecoder(d *arm64Decoder) { s.dec = d }
+
+func (s *prologueSweeperARM64) pos() int { return s.offset }
+
+func (s *prologueSweeperARM64) shift(code []byte) {
+	s.baseAddr += uint64(s.offset)
+	s.code, s.offset = code, 0
+}
 
+func (s *prologueSweeperARM64) step(out []Prologue) ([]Prologue, bool) {
 	const insnLen = 4
-	var prevInsn *arm64asm.Inst
 
-	for offset := 0; offset+insnLen <= len(code); offset += insnLen {
-		inst, err := arm64asm.Decode(code[offset : offset+insnLen])
-		if err != nil {
-			prevInsn = nil
-			continue
-		}
-		addr := baseAddr + uint64(offset)
-
-		if prevInsn != nil && isSTPx29x30PreIndex(*prevInsn) {
-			if isMovX29SP(inst) {
-				// Pattern 1: STP frame pair - stp x29, x30, [sp, #-N]! ; mov x29, sp
-				result = append(result, Prologue{
-					Address:      addr - insnLen,
-					Type:         PrologueSTPFramePair,
-					Instructions: "stp x29, x30, [sp, #-N]!; mov x29, sp",
-				})
-			} else {
-				// Pattern 3: STP-only - stp x29, x30, [sp, #-N]! without mov x29, sp
-				result = append(result, Prologue{
-					Address:      addr - insnLen,
-					Type:         PrologueSTPOnly,
-					Instructions: "stp x29, x30, [sp, #-N]!",
-				})
-			}
+	offset := s.offset
+	if offset+insnLen > len(s.code) {
+		// Trailing bytes shorter than an instruction.
+		s.offset = len(s.code)
+		return out, true
+	}
+	s.offset += insnLen
+
+	inst, err := s.dec.decode(s.code[offset : offset+insnLen])
+	if err != nil {
+		s.prevInsn, s.prevNoReturn = nil, false
+		return out, true
+	}
+	addr := s.baseAddr + uint64(offset)
+	prevInsn := s.prevInsn
+	// The previous function ended on prevInsn.
+	ended := prevInsn == nil || prevInsn.Op == arm64asm.RET || s.prevNoReturn
+
+	if prevInsn != nil && isSTPx29x30PreIndex(*prevInsn) {
+		if isMovX29SP(inst) {
+			// Pattern 1: STP frame pair - stp x29, x30, [sp, #-N]! ; mov x29, sp
+			out = append(out, Prologue{
+				Address:      addr - insnLen,
+				Type:         PrologueSTPFramePair,
+				Instructions: "stp x29, x30, [sp, #-N]!; mov x29, sp",
+			})
+		} else {
+			// Pattern 3: STP-only - stp x29, x30, [sp, #-N]! without mov x29, sp
+			out = append(out, Prologue{
+				Address:      addr - insnLen,
+				Type:         PrologueSTPOnly,
+				Instructions: "stp x29, x30, [sp, #-N]!",
+			})
 		}
+	}
 
-		// Pattern 2: STR LR pre-index - str x30, [sp, #-N]! (Go-style prologue)
-		if inst.Op == arm64asm.STR {
-			if r0, ok := inst.Args[0].(arm64asm.Reg); ok && r0 == arm64asm.X30 {
-				if mem, ok := inst.Args[1].(arm64asm.MemImmediate); ok && mem.Mode == arm64asm.AddrPreIndex {
-					if prevInsn == nil || prevInsn.Op == arm64asm.RET {
-						result = append(result, Prologue{
-							Address:      addr,
-							Type:         PrologueSTRLRPreIndex,
-							Instructions: fmt.Sprintf("str x30, %s", inst.Args[1]),
-						})
-					}
+	// Pattern 2: STR LR pre-index - str x30, [sp, #-N]! (Go-style prologue)
+	if inst.Op == arm64asm.STR {
+		if r0, ok := inst.Args[0].(arm64asm.Reg); ok && r0 == arm64asm.X30 {
+			if mem, ok := inst.Args[1].(arm64asm.MemImmediate); ok && mem.Mode == arm64asm.AddrPreIndex {
+				if ended {
+					out = append(out, Prologue{
+						Address:      addr,
+						Type:         PrologueSTRLRPreIndex,
+						Instructions: fmt.Sprintf("str x30, %s", inst.Args[1]),
+					})
 				}
 			}
 		}
+	}
 
-		// Pattern 3: Sub SP - sub sp, sp, #N (stack allocation without frame pointer)
-		if inst.Op == arm64asm.SUB {
-			if dst, ok := inst.Args[0].(arm64asm.RegSP); ok && dst == arm64asm.RegSP(arm64asm.SP) {
-				if src, ok := inst.Args[1].(arm64asm.RegSP); ok && src == arm64asm.RegSP(arm64asm.SP) {
-					if prevInsn == nil || prevInsn.Op == arm64asm.RET {
-						result = append(result, Prologue{
-							Address:      addr,
-							Type:         PrologueSubSP,
-							Instructions: fmt.Sprintf("sub sp, sp, #%s", inst.Args[2]),
-						})
-					}
+	// Pattern 3: Sub SP - sub sp, sp, #N (stack allocation without frame pointer)
+	if inst.Op == arm64asm.SUB {
+		if dst, ok := inst.Args[0].(arm64asm.RegSP); ok && dst == arm64asm.RegSP(arm64asm.SP) {
+			if src, ok := inst.Args[1].(arm64asm.RegSP); ok && src == arm64asm.RegSP(arm64asm.SP) {
+				if ended {
+					out = append(out, Prologue{
+						Address:      addr,
+						Type:         PrologueSubSP,
+						Instructions: fmt.Sprintf("sub sp, sp, #%s", inst.Args[2]),
+					})
 				}
 			}
 		}
-
-		prevInsn = &inst
 	}
 
-	return result, nil
+	s.prevInsn = &inst
+	s.prevNoReturn = s.noreturn.callARM64(binary.LittleEndian.Uint32(s.code[offset:]), addr)
+	return out, true
 }
 
 // DetectProloguesFromELF parses an ELF binary from the given reader, extracts
 // the .text section, and returns detected function prologues.
 // The architecture is inferred from the ELF header.
 func DetectProloguesFromELF(r io.ReaderAt) ([]Prologue, error) {
-	f, err := elf.NewFile(r)
+	return DetectProloguesFromELFWithOptions(r, Options{})
+}
+
+// DetectProloguesFromELFWithOptions is like DetectProloguesFromELF but
+// analyzes the sections listed in opts and applies its address range
+// restrictions. Results are sorted by address.
+func DetectProloguesFromELFWithOptions(r io.ReaderAt, opts Options) ([]Prologue, error) {
+	return DetectProloguesFromELFContext(context.Background(), r, opts)
+}
+
+// DetectProloguesFromELFContext is like DetectProloguesFromELFWithOptions but
+// stops with the context error as soon as ctx is done, and reports progress
+// to opts.Progress.
+func DetectProloguesFromELFContext(ctx context.Context, r io.ReaderAt, opts Options) ([]Prologue, error) {
+	arch, sections, err := readELFSections(r, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse ELF file: %w", err)
+		return nil, err
 	}
-	defer f.Close()
 
-	textSec := f.Section(".text")
-	if textSec == nil {
-		return nil, fmt.Errorf("no .text section found")
-	}
+	var result []Prologue
+	for _, sec := range sections {
+		opts.logger().Debug("analyzing ELF section",
+			"section", sec.name, "addr", sec.addr, "size", sec.src.size)
 
-	code, err := textSec.Data()
-	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("failed to read .text section: %w", err)
+		opts.section = sec.name
+		prologues, err := detectPrologues(ctx, sec.src, sec.addr, arch, opts)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, opts.filterPrologues(prologues)...)
 	}
 
-	switch f.Machine {
-	case elf.EM_X86_64:
-		return detectProloguesAMD64(code, textSec.Addr)
-	case elf.EM_AARCH64:
-		return detectProloguesARM64(code, textSec.Addr)
-	default:
-		return nil, fmt.Errorf("unsupported ELF machine: %s", f.Machine)
+	if len(sections) > 1 {
+		slices.SortStableFunc(result, func(a, b Prologue) int {
+			return cmp.Compare(a.Address, b.Address)
+		})
 	}
+
+	return result, nil
 }
@@ -0,0 +1,359 @@
+package resurgo
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// DiffMatch selects how Diff aligns the candidates of two results.
+type DiffMatch string
+
+const (
+	// DiffByAddress aligns candidates at the same address, to compare two
+	// runs on the same binary.
+	DiffByAddress DiffMatch = "address"
+	// DiffByHash aligns candidates with the same Hash, as set by
+	// HashFunctionsFromELF, to compare different builds.
+	DiffByHash DiffMatch = "hash"
+)
+
+// DiffOptions configures Diff. The zero value aligns candidates by address.
+type DiffOptions struct {
+	Match DiffMatch
+}
+
+// DiffResult is the difference between two sets of candidates.
+type DiffResult struct {
+	// Added and Removed are the candidates found only in the new and the
+	// old set, sorted by address.
+	Added   []FunctionCandidate `json:"added"`
+	Removed []FunctionCandidate `json:"removed"`
+	// Changed are the aligned candidates that differ, sorted by old
+	// address.
+	Changed []FunctionChange `json:"changed"`
+	// Unchanged is the number of aligned candidates that do not differ.
+	Unchanged int `json:"unchanged"`
+}
+
+// FunctionChange is a pair of aligned candidates that differ.
+type FunctionChange struct {
+	Old FunctionCandidate `json:"old"`
+	New FunctionCandidate `json:"new"`
+	// Fields are the JSON names of the fields that differ, among
+	// detection_type, prologue_type, confidence and called_from.
+	Fields []string `json:"fields"`
+}
+
+// Diff aligns the candidates of before and after as selected by opts, and
+// returns the candidates added, removed and changed. Aligned candidates
+// change when their detection type, prologue type, confidence or callers
+// differ. Callers are compared by address when aligning by address, and by
+// number otherwise, since addresses move between builds.
+//
+// When aligning by hash, candidates without a hash are added or removed,
+// and candidates sharing a hash are aligned in address order.
+func Diff(before, after []FunctionCandidate, opts DiffOptions) DiffResult {
+	before, after = sortedFunctions(before), sortedFunctions(after)
+	key := func(c FunctionCandidate) string {
+		if opts.Match == DiffByHash {
+			return c.Hash
+		}
+		return fmt.Sprint(c.Address)
+	}
+
+	// pending holds the positions in after of each key, in address order.
+	pending := make(map[string][]int)
+	for i, c := range after {
+		if k := key(c); k != "" {
+			pending[k] = append(pending[k], i)
+		}
+	}
+
+	var d DiffResult
+	aligned := make([]bool, len(after))
+	for _, o := range before {
+		k := key(o)
+		if k == "" || len(pending[k]) == 0 {
+			d.Removed = append(d.Removed, o)
+			continue
+		}
+		i := pending[k][0]
+		pending[k] = pending[k][1:]
+		aligned[i] = true
+		if fields := changedFields(o, after[i], opts.Match != DiffByHash); len(fields) > 0 {
+			d.Changed = append(d.Changed, FunctionChange{Old: o, New: after[i], Fields: fields})
+		} else {
+			d.Unchanged++
+		}
+	}
+	for i, c := range after {
+		if !aligned[i] {
+			d.Added = append(d.Added, c)
+		}
+	}
+	return d
+}
+
+// changedFields returns the JSON names of the fields differing between a
+// and b, comparing callers by address when byAddress is set and by number
+// otherwise.
+func changedFields(a, b FunctionCandidate, byAddress bool) []string {
+	var fields []string
+	if a.DetectionType != b.DetectionType {
+		fields = append(fields, "detection_type")
+	}
+	if a.PrologueType != b.PrologueType {
+		fields = append(fields, "prologue_type")
+	}
+	if a.Confidence != b.Confidence {
+		fields = append(fields, "confidence")
+	}
+	callers := len(a.CalledFrom) != len(b.CalledFrom)
+	if byAddress && !callers {
+		x, y := slices.Sorted(slices.Values(a.CalledFrom)), slices.Sorted(slices.Values(b.CalledFrom))
+		callers = !slices.Equal(x, y)
+	}
+	if callers {
+		fields = append(fields, "called_from")
+	}
+	return fields
+}
+
+// WriteText writes d to w in a human-readable form: a line per removed
+// (-), added (+) and changed (~) candidate, then a summary line.
+func (d DiffResult) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	describe := func(c FunctionCandidate) string {
+		s := fmt.Sprintf("%s %s", c.Confidence, c.DetectionType)
+		if c.PrologueType != "" {
+			s += " " + string(c.PrologueType)
+		}
+		return fmt.Sprintf("%s (%s, %d callers)", symbolName(c), s, len(c.CalledFrom))
+	}
+	for _, c := range d.Removed {
+		fmt.Fprintf(bw, "- 0x%x %s\n", c.Address, describe(c))
+	}
+	for _, c := range d.Added {
+		fmt.Fprintf(bw, "+ 0x%x %s\n", c.Address, describe(c))
+	}
+	for _, ch := range d.Changed {
+		addr := fmt.Sprintf("0x%x", ch.Old.Address)
+		if ch.New.Address != ch.Old.Address {
+			addr += fmt.Sprintf(" -> 0x%x", ch.New.Address)
+		}
+		changes := make([]string, 0, len(ch.Fields))
+		for _, f := range ch.Fields {
+			var from, to any
+			switch f {
+			case "detection_type":
+				from, to = ch.Old.DetectionType, ch.New.DetectionType
+			case "prologue_type":
+				from, to = ch.Old.PrologueType, ch.New.PrologueType
+			case "confidence":
+				from, to = ch.Old.Confidence, ch.New.Confidence
+			case "called_from":
+				f, from, to = "callers", hexList(ch.Old.CalledFrom), hexList(ch.New.CalledFrom)
+			}
+			changes = append(changes, fmt.Sprintf("%s %v -> %v", f, from, to))
+		}
+		fmt.Fprintf(bw, "~ %s %s: %s\n", addr, symbolName(ch.New), strings.Join(changes, ", "))
+	}
+	fmt.Fprintf(bw, "%d added, %d removed, %d changed, %d unchanged\n",
+		len(d.Added), len(d.Removed), len(d.Changed), d.Unchanged)
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write diff: %w", err)
+	}
+	return nil
+}
+
+// hexList formats addrs, sorted, as a bracketed list of hex addresses.
+func hexList(addrs []uint64) string {
+	strs := make([]string, 0, len(addrs))
+	for _, addr := range slices.Sorted(slices.Values(addrs)) {
+		strs = append(strs, fmt.Sprintf("0x%x", addr))
+	}
+	return "[" + strings.Join(strs, " ") + "]"
+}
+
+// HashFunctionsFromELF returns a copy of candidates, detected in the ELF
+// binary read from r, with Hash set to the hex SHA-256 of the bytes of
+// each function, for Diff to align them across builds. Address operands
+// are masked as by maskAddresses, so that the hash survives the move of
+// the function and of the code it refers to. Functions are sized as by
+// WriteELFSymbols; candidates outside the sections of the binary get no
+// hash.
+func HashFunctionsFromELF(r io.ReaderAt, candidates []FunctionCandidate) ([]FunctionCandidate, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ELF file: %w", err)
+	}
+	defer f.Close()
+	arch, err := elfArch(f.Machine)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[uint64]string, len(candidates))
+	err = elfFunctionCode(f, candidates, func(addr uint64, code []byte) {
+		sum := contentHash(code, arch)
+		hashes[addr] = hex.EncodeToString(sum[:])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	candidates = slices.Clone(candidates)
+	for i := range candidates {
+		candidates[i].Hash = hashes[candidates[i].Address]
+	}
+	return candidates, nil
+}
+
+// contentHash returns the SHA-256 of code with its address operands masked
+// for arch.
+func contentHash(code []byte, arch Arch) [sha256.Size]byte {
+	return sha256.Sum256(maskAddresses(code, arch))
+}
+
+// maskAddresses returns a copy of code with its address operands cleared,
+// so that it is the same for identical code loaded at different addresses.
+// Code of other architectures is returned as it is.
+//
+// On x86_64, PC-relative displacements are masked, as are immediates and
+// displacements of at least minAddressOperand, taken as absolute
+// addresses. On ARM64, the offsets of branches, ADR, ADRP and literal loads
+// are masked, as are the low 12 bits added to or loaded from an ADRP
+// result. Undecodable bytes are kept.
+func maskAddresses(code []byte, arch Arch) []byte {
+	switch arch {
+	case ArchAMD64:
+		return maskAMD64(code)
+	case ArchARM64:
+		return maskARM64(code)
+	}
+	return code
+}
+
+// minAddressOperand is the smallest x86_64 immediate or displacement taken
+// as an absolute address: position-dependent code is linked far above it.
+const minAddressOperand = 0x10000
+
+func maskAMD64(code []byte) []byte {
+	masked := bytes.Clone(code)
+	for offset := 0; offset < len(code); {
+		inst, err := x86asm.Decode(code[offset:], 64)
+		if err != nil {
+			offset++
+			continue
+		}
+		insn := masked[offset : offset+inst.Len]
+		if inst.PCRel > 0 {
+			clear(insn[inst.PCRelOff : inst.PCRelOff+inst.PCRel])
+		}
+		for _, arg := range inst.Args {
+			var v int64
+			switch arg := arg.(type) {
+			case x86asm.Imm:
+				v = int64(arg)
+			case x86asm.Mem:
+				if arg.Base == x86asm.RIP {
+					continue
+				}
+				v = arg.Disp
+			default:
+				continue
+			}
+			if v >= minAddressOperand {
+				maskOperand(insn, uint64(v))
+			}
+		}
+		offset += inst.Len
+	}
+	return masked
+}
+
+// maskOperand clears the little-endian encoding of v in insn, as 8 or 4
+// bytes.
+func maskOperand(insn []byte, v uint64) {
+	var enc [8]byte
+	binary.LittleEndian.PutUint64(enc[:], v)
+	if i := bytes.Index(insn, enc[:]); i >= 0 {
+		clear(insn[i : i+8])
+		return
+	}
+	if v <= 0xffffffff {
+		if i := bytes.Index(insn, enc[:4]); i >= 0 {
+			clear(insn[i : i+4])
+		}
+	}
+}
+
+func maskARM64(code []byte) []byte {
+	const insnLen = 4
+	masked := bytes.Clone(code)
+	// adrp holds the registers written by an ADRP, by register number.
+	var adrp [32]bool
+	for offset := 0; offset+insnLen <= len(code); offset += insnLen {
+		w := binary.LittleEndian.Uint32(code[offset:])
+		rn := w >> 5 & 0x1f
+		switch {
+		case w&0x7c000000 == 0x14000000: // B, BL
+			w &^= 0x03ffffff
+		case w&0xff000010 == 0x54000000, // B.cond
+			w&0x7e000000 == 0x34000000, // CBZ, CBNZ
+			w&0x3b000000 == 0x18000000: // LDR (literal)
+			w &^= 0x00ffffe0
+		case w&0x7e000000 == 0x36000000: // TBZ, TBNZ
+			w &^= 0x0007ffe0
+		case w&0x1f000000 == 0x10000000: // ADR, ADRP
+			if w&0x80000000 != 0 {
+				adrp[w&0x1f] = true
+			}
+			w &^= 0x60ffffe0
+		case w&0x7f800000 == 0x11000000 && adrp[rn], // ADD (immediate)
+			w&0x3b000000 == 0x39000000 && adrp[rn]: // LDR, STR (unsigned offset)
+			w &^= 0x003ffc00
+		}
+		binary.LittleEndian.PutUint32(masked[offset:], w)
+	}
+	return masked
+}
+
+// elfFunctionCode calls fn with the address and the code of each function
+// of candidates in f, in address order, sized as by WriteELFSymbols.
+// Candidates outside the sections of f are skipped.
+func elfFunctionCode(f *elf.File, candidates []FunctionCandidate, fn func(addr uint64, code []byte)) error {
+	sorted := sortedFunctions(candidates)
+	data := make(map[int][]byte)
+	for i, c := range sorted {
+		idx := elfSectionOf(f, c.Address)
+		if idx < 0 {
+			continue
+		}
+		sec := f.Sections[idx]
+		if _, ok := data[idx]; !ok {
+			d, err := sec.Data()
+			if err != nil {
+				return fmt.Errorf("failed to read %s section: %w", sec.Name, err)
+			}
+			data[idx] = d
+		}
+		start := c.Address - sec.Addr
+		if start > uint64(len(data[idx])) {
+			continue
+		}
+		end := min(start+functionSize(sorted, i, sec.Addr+sec.Size), uint64(len(data[idx])))
+		fn(c.Address, data[idx][start:end])
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package resurgo_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+func TestDiff_ByAddress(t *testing.T) {
+	before := []resurgo.FunctionCandidate{
+		{Address: 0x1000, DetectionType: resurgo.DetectionBoth, Confidence: resurgo.ConfidenceHigh, CalledFrom: []uint64{0x1210, 0x1110}},
+		{Address: 0x1100, DetectionType: resurgo.DetectionPrologueOnly, Confidence: resurgo.ConfidenceMedium},
+		{Address: 0x1200, DetectionType: resurgo.DetectionCallTarget, Confidence: resurgo.ConfidenceMedium, CalledFrom: []uint64{0x1010}},
+	}
+	after := []resurgo.FunctionCandidate{
+		{Address: 0x1300, DetectionType: resurgo.DetectionPadding, Confidence: resurgo.ConfidenceLow},
+		// Same callers, in another order.
+		{Address: 0x1000, DetectionType: resurgo.DetectionBoth, Confidence: resurgo.ConfidenceHigh, CalledFrom: []uint64{0x1110, 0x1210}},
+		{Address: 0x1200, DetectionType: resurgo.DetectionCallTarget, Confidence: resurgo.ConfidenceMedium, CalledFrom: []uint64{0x1020}},
+		{Address: 0x1100, DetectionType: resurgo.DetectionBoth, Confidence: resurgo.ConfidenceHigh},
+	}
+
+	d := resurgo.Diff(before, after, resurgo.DiffOptions{})
+	if len(d.Added) != 1 || d.Added[0].Address != 0x1300 {
+		t.Errorf("expected 0x1300 to be added, got %+v", d.Added)
+	}
+	if len(d.Removed) != 0 {
+		t.Errorf("expected nothing removed, got %+v", d.Removed)
+	}
+	if d.Unchanged != 1 {
+		t.Errorf("expected 1 unchanged candidate, got %d", d.Unchanged)
+	}
+	if len(d.Changed) != 2 {
+		t.Fatalf("expected 2 changed candidates, got %+v", d.Changed)
+	}
+	if ch := d.Changed[0]; ch.Old.Address != 0x1100 || !slices.Equal(ch.Fields, []string{"detection_type", "confidence"}) {
+		t.Errorf("unexpected change: %+v", ch)
+	}
+	if ch := d.Changed[1]; ch.Old.Address != 0x1200 || !slices.Equal(ch.Fields, []string{"called_from"}) {
+		t.Errorf("unexpected change: %+v", ch)
+	}
+
+	var buf bytes.Buffer
+	if err := d.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	want := "+ 0x1300 sub_1300 (low padding, 0 callers)\n" +
+		"~ 0x1100 sub_1100: detection_type prologue-only -> both, confidence medium -> high\n" +
+		"~ 0x1200 sub_1200: callers [0x1010] -> [0x1020]\n" +
+		"1 added, 0 removed, 2 changed, 1 unchanged\n"
+	if got := buf.String(); got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestDiff_ByHash(t *testing.T) {
+	before := []resurgo.FunctionCandidate{
+		{Address: 0x1000, Hash: "aa", Confidence: resurgo.ConfidenceHigh, CalledFrom: []uint64{0x1110}},
+		{Address: 0x1100, Hash: "bb", Confidence: resurgo.ConfidenceHigh},
+		{Address: 0x1200, Hash: "bb", Confidence: resurgo.ConfidenceHigh},
+		{Address: 0x1300, Confidence: resurgo.ConfidenceHigh},
+	}
+	after := []resurgo.FunctionCandidate{
+		// Moved, with callers moved too.
+		{Address: 0x2000, Hash: "aa", Confidence: resurgo.ConfidenceHigh, CalledFrom: []uint64{0x2110}},
+		{Address: 0x2100, Hash: "bb", Confidence: resurgo.ConfidenceLow},
+		{Address: 0x2200, Hash: "cc", Confidence: resurgo.ConfidenceHigh},
+		{Address: 0x1300, Confidence: resurgo.ConfidenceHigh},
+	}
+
+	d := resurgo.Diff(before, after, resurgo.DiffOptions{Match: resurgo.DiffByHash})
+	if d.Unchanged != 1 {
+		t.Errorf("expected 1 unchanged candidate, got %d", d.Unchanged)
+	}
+	if len(d.Changed) != 1 || d.Changed[0].Old.Address != 0x1100 || d.Changed[0].New.Address != 0x2100 {
+		t.Errorf("expected 0x1100 to change into 0x2100, got %+v", d.Changed)
+	}
+	var removed, added []uint64
+	for _, c := range d.Removed {
+		removed = append(removed, c.Address)
+	}
+	for _, c := range d.Added {
+		added = append(added, c.Address)
+	}
+	// Candidates without a hash are never aligned.
+	if !slices.Equal(removed, []uint64{0x1200, 0x1300}) || !slices.Equal(added, []uint64{0x1300, 0x2200}) {
+		t.Errorf("unexpected added %x and removed %x", added, removed)
+	}
+}
+
+func TestHashFunctionsFromELF(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found, skipping")
+	}
+
+	// Two builds of the same code, the second with an extra function
+	// inserted after observe, moving the functions after it and their
+	// calls to observe.
+	src, err := os.ReadFile("testdata/demo-app.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	inserted := filepath.Join(dir, "demo-app-extra.c")
+	modified := strings.Replace(string(src), "sink = v; }\n", "sink = v; }\n\nint extra(int x) { return x * 3 + 1; }\n", 1)
+	if err := os.WriteFile(inserted, []byte(modified), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var builds [][]resurgo.FunctionCandidate
+	var extraAddr uint64
+	for i, src := range []string{"testdata/demo-app.c", inserted} {
+		binPath := filepath.Join(dir, "demo-app"+string(rune('0'+i)))
+		if out, err := exec.Command("gcc", "-O0", "-fno-pie", "-no-pie", "-o", binPath, src).CombinedOutput(); err != nil {
+			t.Fatalf("failed to compile: %v\n%s", err, out)
+		}
+		data, err := os.ReadFile(binPath)
+		if err != nil {
+			t.Fatalf("failed to read compiled binary: %v", err)
+		}
+		f, err := elf.NewFile(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to parse ELF file: %v", err)
+		}
+		syms, err := f.Symbols()
+		if err != nil {
+			t.Fatalf("failed to read symbols: %v", err)
+		}
+		for _, s := range syms {
+			if s.Name == "extra" {
+				extraAddr = s.Value
+			}
+		}
+
+		candidates, err := resurgo.DetectFunctionsFromELF(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("DetectFunctionsFromELF: %v", err)
+		}
+		hashed, err := resurgo.HashFunctionsFromELF(bytes.NewReader(data), candidates)
+		if err != nil {
+			t.Fatalf("HashFunctionsFromELF: %v", err)
+		}
+		for j, c := range hashed {
+			if c.Address != candidates[j].Address {
+				t.Fatalf("expected candidates in the same order")
+			}
+			if len(c.Hash) != 64 {
+				t.Errorf("0x%x: expected a SHA-256 hash, got %q", c.Address, c.Hash)
+			}
+		}
+		builds = append(builds, hashed)
+	}
+	if extraAddr == 0 {
+		t.Fatal("expected the extra function in the second build")
+	}
+
+	// Address operands are masked, so every function but the extra one
+	// lines up, even those calling moved functions.
+	d := resurgo.Diff(builds[0], builds[1], resurgo.DiffOptions{Match: resurgo.DiffByHash})
+	if len(d.Removed) != 0 {
+		t.Errorf("expected nothing removed, got %+v", d.Removed)
+	}
+	if len(d.Added) != 1 || d.Added[0].Address != extraAddr {
+		t.Errorf("expected only the extra function at 0x%x to be added, got %+v", extraAddr, d.Added)
+	}
+}
@@ -29,11 +29,147 @@
 // receive the highest confidence rating. This is particularly effective for
 // recovering functions in stripped binaries or heavily optimized code.
 //
+// # Live Processes and Core Dumps
+//
+// On Linux, [DetectFunctionsFromProcess] analyzes the executable mappings of
+// a running process, reading them through /proc/<pid>/mem. Results are
+// grouped per [Mapping], so functions in anonymous memory, such as
+// JIT-compiled code, are reported alongside those of the mapped files.
+// [DetectFunctionsFromCore] does the same for the mappings saved in an ELF
+// core dump, labelled from its NT_FILE note.
+//
+// # Relocatable Objects and Archives
+//
+// [DetectFunctionsFromObject] analyzes every executable section of an ELF
+// relocatable object with addresses relative to the section, applying its
+// branch relocations so that call sites name the referenced symbol.
+// [DetectFunctionsFromArchive] does the same for each object of an ar
+// archive.
+//
+// # Linux Kernel
+//
+// [DetectFunctionsFromKernel] analyzes the executable sections of a vmlinux
+// image together, and [DetectFunctionsFromKernelModule] the sections of a
+// loadable module with its relocations applied. On x86-64 both treat the
+// ftrace entry sequence, return thunks and retpoline thunks as transparent.
+//
+// # MiniDebugInfo
+//
+// When an ELF binary carries MiniDebugInfo (an xz-compressed .gnu_debugdata
+// section with a minimal symbol table, as shipped by Fedora and RHEL), the
+// FromELF function detectors merge its function symbols into the candidates
+// as [EvidenceSymbol]. Compressed sections are decompressed transparently.
+//
+// # Firmware
+//
+// [LoadBinary], [LoadIntelHex] and [LoadSRecord] load a firmware image into
+// [Segment] values along a memory map of [Region] values, and
+// [DetectFunctionsFromSegments] detects the functions of each segment at its
+// address.
+//
+// # Images
+//
+// An [Image] is a set of segments with addresses, permissions and contents,
+// built by [NewELFImage], [NewPEImage], [NewMachOImage], [NewCoreImage] or
+// [NewSegmentImage]. [DetectFunctionsFromImage] analyzes its executable
+// segments together, so calls between segments are resolved.
+//
+// # Padding
+//
+// [DetectPadding] reports the padding compilers insert between functions
+// (int3 or NOPs on x86-64, udf or NOPs on ARM64) as [Gap] values.
+// DetectFunctions uses it as [EvidencePadding]: an aligned address right
+// after padding raises the confidence of the candidate there, or adds a
+// low-confidence [DetectionPadding] candidate.
+//
+// # Non-returning functions
+//
+// DetectFunctions infers the functions that never return to their caller,
+// such as exit, abort or runtime.throw and the functions only calling them,
+// as [EvidenceNoReturn]. Their candidates have NoReturn set, and the code
+// following a call to them is taken as a function boundary, like the code
+// following a RET. The FromELF variants also recognize the known
+// non-returning functions of the binary and their PLT stubs.
+//
+// # Tail calls
+//
+// DetectFunctions classifies jumps as tail calls or jumps within a function
+// once function extents are known, tracking the stack pointer up to each
+// jump, and only promotes tail-call targets to candidates. [ClassifyJumps]
+// sets the resulting [JumpKind] on the edges of [DetectCallSites].
+//
+// # Call graph
+//
+// [NewCallGraph] builds the [CallGraph] of detected functions, attributing
+// each call site to the function containing it, with callers and callees
+// queries, reachability from entry points, recursion through strongly
+// connected components, and topological order. [CallGraph.WriteDOT],
+// [CallGraph.WriteGraphML] and [CallGraph.WriteJSON] export it for
+// visualisation, optionally restricted by [GraphOptions] to a minimum
+// confidence or to the neighbourhood of an address.
+//
+// # Symbolization
+//
+// [NewSymbolizer] indexes detected functions, and optionally symbols, into a
+// [Symbolizer] that maps addresses to the function containing them, one at
+// a time or in batches, and can be saved and reloaded with
+// [Symbolizer.WriteTo] and [ReadSymbolizer].
+//
+// # Writing symbols
+//
+// [WriteELFSymbols] copies an ELF binary with a synthesized symbol table
+// naming each detected function, sub_ followed by its address unless its
+// name is known, for tools such as perf, gdb and addr2line. For live
+// processes, [WritePerfMap] writes the functions found by
+// [DetectFunctionsFromProcess] as the perf map perf reads from
+// [PerfMapPath], and [WriteJITDump] as jitdump records for perf inject.
+// [WriteBreakpadSymbols] writes a Breakpad symbol file, with the stack
+// unwinding rules of .eh_frame, for crash reporting.
+// The pprof subpackage adds the recovered functions to pprof profiles of
+// stripped binaries.
+// [WriteIDAPython], [WriteGhidraScript] and [WriteR2Script] write scripts
+// seeding IDA, Ghidra and radare2 projects with the detected functions.
+//
+// # Diffing
+//
+// [Diff] compares two sets of candidates, aligned by address for two runs
+// on the same binary, or by the content hash set by [HashFunctionsFromELF]
+// for two builds, and reports the candidates added, removed and changed.
+// The resurgo command in cmd/resurgo runs it on binaries or saved results.
+//
+// # Fingerprints
+//
+// [FingerprintFunctionsFromELF] sets the [Fingerprint] of each detected
+// function. The fingerprint has three hashes: one of its code with address
+// operands masked, one of its mnemonic sequence, and a MinHash signature of
+// its mnemonic n-grams. [Similarity] and [FindSimilar] use these hashes to
+// match functions across builds.
+//
+// # Streaming
+//
+// [Prologues], [CallSites] and [Functions] return iterators yielding results
+// as decoding proceeds, for binaries too large to hold every result in
+// memory. Breaking out of the loop stops decoding.
+//
+// # Options
+//
+// Each entry point has a WithOptions variant, such as
+// [DetectFunctionsWithOptions], accepting an [Options] value that selects
+// evidence sources, a minimum confidence, conditional jump reporting,
+// address ranges, ELF sections and a logger. The zero value of [Options]
+// reproduces the default behaviour.
+//
+// The Context variants, such as [DetectFunctionsFromELFContext], stop as
+// soon as the context is done and report the [Progress] of each [Phase] to
+// Options.Progress.
+//
 // # Confidence Scoring
 //
 // The confidence level indicates the reliability of a detection:
 //   - High: Direct CALL instructions or prologue + called/jumped to
 //   - Medium: Unconditional JMP or prologue-only
-//   - Low: Conditional jumps (usually intra-function branches)
+//   - Low: Conditional jumps (usually intra-function branches) or padding-only
 //   - None: Register-indirect (cannot be statically resolved)
+//
+// An aligned address right after padding raises a candidate by one level.
 package resurgo
@@ -0,0 +1,480 @@
+package resurgo
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// errUnsupportedCFI reports call frame information that cannot be
+// expressed as a register and offset rule, such as DWARF expressions.
+var errUnsupportedCFI = errors.New("unsupported call frame information")
+
+// cfiRuleKind is how a rule recovers the value of a register.
+type cfiRuleKind int
+
+const (
+	// cfiSavedAt: the register is saved at CFA+offset.
+	cfiSavedAt cfiRuleKind = iota
+	// cfiValue: the register holds CFA+offset.
+	cfiValue
+	// cfiRegister: the register is saved in register reg.
+	cfiRegister
+	// cfiSameValue: the register is unchanged.
+	cfiSameValue
+)
+
+// cfiRule recovers the value of a register in the caller.
+type cfiRule struct {
+	kind   cfiRuleKind
+	offset int64
+	reg    uint64
+}
+
+// cfiState is the row of the call frame table at an address: the CFA as
+// register plus offset, and the rules of the registers with one.
+// Registers without a rule are undefined.
+type cfiState struct {
+	cfaReg    uint64
+	cfaOffset int64
+	regs      map[uint64]cfiRule
+}
+
+func (s cfiState) clone() cfiState {
+	s.regs = maps.Clone(s.regs)
+	if s.regs == nil {
+		s.regs = make(map[uint64]cfiRule)
+	}
+	return s
+}
+
+func (s cfiState) equal(o cfiState) bool {
+	return s.cfaReg == o.cfaReg && s.cfaOffset == o.cfaOffset && maps.Equal(s.regs, o.regs)
+}
+
+// cfiRow is the state of the call frame table from loc on.
+type cfiRow struct {
+	loc   uint64
+	state cfiState
+}
+
+// frameEntry is the call frame information of the code at [begin,
+// begin+size), described by an FDE: its rows, sorted by address, the
+// first at begin.
+type frameEntry struct {
+	begin, size uint64
+	// ra is the column of the return address.
+	ra   uint64
+	rows []cfiRow
+}
+
+// cie is a Common Information Entry of .eh_frame.
+type cie struct {
+	codeAlign    uint64
+	dataAlign    int64
+	ra           uint64
+	fdeEncoding  byte
+	augmentation bool
+	initial      []byte
+}
+
+// Pointer encodings of .eh_frame (DW_EH_PE_*).
+const (
+	ehPEAbsptr  = 0x00
+	ehPEUleb128 = 0x01
+	ehPEUdata2  = 0x02
+	ehPEUdata4  = 0x03
+	ehPEUdata8  = 0x04
+	ehPESleb128 = 0x09
+	ehPESdata2  = 0x0a
+	ehPESdata4  = 0x0b
+	ehPESdata8  = 0x0c
+	ehPEPCRel   = 0x10
+	ehPEOmit    = 0xff
+)
+
+// ehReader decodes the fields of .eh_frame, loaded at addr.
+type ehReader struct {
+	data  []byte
+	pos   int
+	addr  uint64
+	order binary.ByteOrder
+	err   error
+}
+
+var errTruncatedCFI = errors.New("truncated call frame information")
+
+func (r *ehReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.data)-r.pos {
+		r.err = errTruncatedCFI
+		return make([]byte, max(n, 0))
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *ehReader) u8() uint8   { return r.bytes(1)[0] }
+func (r *ehReader) u16() uint16 { return r.order.Uint16(r.bytes(2)) }
+func (r *ehReader) u32() uint32 { return r.order.Uint32(r.bytes(4)) }
+func (r *ehReader) u64() uint64 { return r.order.Uint64(r.bytes(8)) }
+
+func (r *ehReader) uleb() uint64 {
+	var v uint64
+	for shift := uint(0); ; shift += 7 {
+		b := r.u8()
+		if shift < 64 {
+			v |= uint64(b&0x7f) << shift
+		}
+		if b&0x80 == 0 || r.err != nil {
+			return v
+		}
+	}
+}
+
+func (r *ehReader) sleb() int64 {
+	var v int64
+	var shift uint
+	for {
+		b := r.u8()
+		if shift < 64 {
+			v |= int64(b&0x7f) << shift
+		}
+		shift += 7
+		if b&0x80 == 0 || r.err != nil {
+			if shift < 64 && b&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v
+		}
+	}
+}
+
+func (r *ehReader) cstring() string {
+	start := r.pos
+	for r.err == nil && r.u8() != 0 {
+	}
+	if r.err != nil {
+		return ""
+	}
+	return string(r.data[start : r.pos-1])
+}
+
+// pointer decodes a pointer of the given encoding. Only absolute and
+// PC-relative pointers are supported.
+func (r *ehReader) pointer(enc byte) (uint64, error) {
+	if enc == ehPEOmit {
+		return 0, nil
+	}
+	field := r.addr + uint64(r.pos)
+	var v uint64
+	switch enc & 0x0f {
+	case ehPEAbsptr, ehPEUdata8, ehPESdata8:
+		v = r.u64()
+	case ehPEUleb128:
+		v = r.uleb()
+	case ehPEUdata2:
+		v = uint64(r.u16())
+	case ehPESdata2:
+		v = uint64(int16(r.u16()))
+	case ehPEUdata4:
+		v = uint64(r.u32())
+	case ehPESdata4:
+		v = uint64(int32(r.u32()))
+	case ehPESleb128:
+		v = uint64(r.sleb())
+	default:
+		return 0, fmt.Errorf("%w: pointer encoding 0x%x", errUnsupportedCFI, enc)
+	}
+	switch enc & 0x70 {
+	case 0:
+	case ehPEPCRel:
+		v += field
+	default:
+		return 0, fmt.Errorf("%w: pointer encoding 0x%x", errUnsupportedCFI, enc)
+	}
+	return v, nil
+}
+
+// parseEHFrame returns the call frame information of the .eh_frame
+// section data loaded at addr, sorted by the address of the FDEs. FDEs
+// whose information cannot be expressed as register and offset rules are
+// skipped.
+func parseEHFrame(data []byte, addr uint64, order binary.ByteOrder) ([]frameEntry, error) {
+	cies := make(map[int]*cie)
+	var entries []frameEntry
+	r := &ehReader{data: data, addr: addr, order: order}
+	for r.pos < len(data) {
+		start := r.pos
+		length := uint64(r.u32())
+		if length == 0 {
+			break // terminator
+		}
+		if length == 0xffffffff {
+			length = r.u64()
+		}
+		if r.err != nil || length > uint64(len(data)-r.pos) {
+			return entries, fmt.Errorf("failed to parse .eh_frame at 0x%x: %w", start, errTruncatedCFI)
+		}
+		end := r.pos + int(length)
+		idPos := r.pos
+		id := r.u32()
+
+		entry := &ehReader{data: data[:end], pos: r.pos, addr: addr, order: order}
+		r.pos = end
+		if id == 0 {
+			c, err := parseCIE(entry)
+			if err != nil {
+				continue // FDEs referring to it are skipped
+			}
+			cies[start] = c
+			continue
+		}
+		c, ok := cies[idPos-int(id)]
+		if !ok {
+			continue
+		}
+		fe, err := parseFDE(entry, c)
+		if errors.Is(err, errTruncatedCFI) {
+			return entries, fmt.Errorf("failed to parse .eh_frame FDE at 0x%x: %w", start, err)
+		}
+		if err != nil {
+			continue
+		}
+		entries = append(entries, fe)
+	}
+	slices.SortFunc(entries, func(a, b frameEntry) int {
+		return cmp.Compare(a.begin, b.begin)
+	})
+	return entries, nil
+}
+
+func parseCIE(r *ehReader) (*cie, error) {
+	c := &cie{fdeEncoding: ehPEAbsptr}
+	version := r.u8()
+	aug := r.cstring()
+	if version == 4 {
+		r.u8() // address size
+		r.u8() // segment selector size
+	}
+	c.codeAlign = r.uleb()
+	c.dataAlign = r.sleb()
+	if version == 1 {
+		c.ra = uint64(r.u8())
+	} else {
+		c.ra = r.uleb()
+	}
+	if aug != "" {
+		if aug[0] != 'z' {
+			return nil, fmt.Errorf("%w: augmentation %q", errUnsupportedCFI, aug)
+		}
+		c.augmentation = true
+		n := r.uleb()
+		if r.err != nil || n > uint64(len(r.data)-r.pos) {
+			return nil, errTruncatedCFI
+		}
+		augEnd := r.pos + int(n)
+	loop:
+		for _, ch := range aug[1:] {
+			switch ch {
+			case 'R':
+				c.fdeEncoding = r.u8()
+			case 'P':
+				if _, err := r.pointer(r.u8() &^ 0x80); err != nil {
+					break loop
+				}
+			case 'L':
+				r.u8()
+			case 'S', 'B':
+			default:
+				break loop
+			}
+		}
+		r.pos = augEnd
+	}
+	c.initial = r.data[min(r.pos, len(r.data)):]
+	return c, r.err
+}
+
+func parseFDE(r *ehReader, c *cie) (frameEntry, error) {
+	begin, err := r.pointer(c.fdeEncoding)
+	if err != nil {
+		return frameEntry{}, err
+	}
+	size, err := r.pointer(c.fdeEncoding & 0x0f)
+	if err != nil {
+		return frameEntry{}, err
+	}
+	if c.augmentation {
+		n := r.uleb()
+		r.bytes(int(n))
+	}
+	if r.err != nil {
+		return frameEntry{}, r.err
+	}
+
+	e := frameEntry{begin: begin, size: size, ra: c.ra}
+	m := &cfiMachine{cie: c, encoding: c.fdeEncoding}
+	if err := m.run(&ehReader{data: c.initial, order: r.order, addr: r.addr}, false); err != nil {
+		return frameEntry{}, err
+	}
+	m.initial = m.state.clone()
+	m.loc = begin
+	if err := m.run(&ehReader{data: r.data[r.pos:], order: r.order, addr: r.addr + uint64(r.pos)}, true); err != nil {
+		return frameEntry{}, err
+	}
+	m.emit()
+
+	// Keep the last row at each address, and the rows changing the state,
+	// within the range of the FDE.
+	for _, row := range m.rows {
+		if row.loc < begin || row.loc-begin >= size {
+			continue
+		}
+		if n := len(e.rows); n > 0 && e.rows[n-1].loc == row.loc {
+			e.rows = e.rows[:n-1]
+		}
+		if n := len(e.rows); n > 0 && e.rows[n-1].state.equal(row.state) {
+			continue
+		}
+		e.rows = append(e.rows, row)
+	}
+	if len(e.rows) == 0 || e.rows[0].loc != begin {
+		return frameEntry{}, fmt.Errorf("%w: no row at 0x%x", errUnsupportedCFI, begin)
+	}
+	return e, nil
+}
+
+// cfiMachine executes call frame instructions.
+type cfiMachine struct {
+	cie      *cie
+	encoding byte
+	loc      uint64
+	state    cfiState
+	initial  cfiState
+	saved    []cfiState
+	rows     []cfiRow
+}
+
+// emit records the current state as the row at the current location.
+func (m *cfiMachine) emit() {
+	m.rows = append(m.rows, cfiRow{loc: m.loc, state: m.state.clone()})
+}
+
+func (m *cfiMachine) advance(delta uint64) {
+	m.emit()
+	m.loc += delta * m.cie.codeAlign
+}
+
+func (m *cfiMachine) restore(reg uint64) {
+	if rule, ok := m.initial.regs[reg]; ok {
+		m.state.regs[reg] = rule
+	} else {
+		delete(m.state.regs, reg)
+	}
+}
+
+// run executes the instructions read from r. Location instructions are
+// only allowed in FDEs.
+func (m *cfiMachine) run(r *ehReader, fde bool) error {
+	if m.state.regs == nil {
+		m.state = m.state.clone()
+	}
+	dataAlign := m.cie.dataAlign
+	for r.pos < len(r.data) && r.err == nil {
+		op := r.u8()
+		switch op >> 6 {
+		case 1: // DW_CFA_advance_loc
+			m.advance(uint64(op & 0x3f))
+		case 2: // DW_CFA_offset
+			m.state.regs[uint64(op&0x3f)] = cfiRule{kind: cfiSavedAt, offset: int64(r.uleb()) * dataAlign}
+		case 3: // DW_CFA_restore
+			m.restore(uint64(op & 0x3f))
+		default:
+			if err := m.step(r, op); err != nil {
+				return err
+			}
+		}
+		if !fde && len(m.rows) > 0 {
+			return fmt.Errorf("%w: location instruction in CIE", errUnsupportedCFI)
+		}
+	}
+	return r.err
+}
+
+// step executes the instruction op, other than DW_CFA_advance_loc,
+// DW_CFA_offset and DW_CFA_restore, reading its operands from r.
+func (m *cfiMachine) step(r *ehReader, op byte) error {
+	dataAlign := m.cie.dataAlign
+	switch op {
+	case 0x00: // DW_CFA_nop
+	case 0x01: // DW_CFA_set_loc
+		loc, err := r.pointer(m.encoding)
+		if err != nil {
+			return err
+		}
+		m.emit()
+		m.loc = loc
+	case 0x02: // DW_CFA_advance_loc1
+		m.advance(uint64(r.u8()))
+	case 0x03: // DW_CFA_advance_loc2
+		m.advance(uint64(r.u16()))
+	case 0x04: // DW_CFA_advance_loc4
+		m.advance(uint64(r.u32()))
+	case 0x05: // DW_CFA_offset_extended
+		reg := r.uleb()
+		m.state.regs[reg] = cfiRule{kind: cfiSavedAt, offset: int64(r.uleb()) * dataAlign}
+	case 0x06: // DW_CFA_restore_extended
+		m.restore(r.uleb())
+	case 0x07: // DW_CFA_undefined
+		delete(m.state.regs, r.uleb())
+	case 0x08: // DW_CFA_same_value
+		m.state.regs[r.uleb()] = cfiRule{kind: cfiSameValue}
+	case 0x09: // DW_CFA_register
+		reg := r.uleb()
+		m.state.regs[reg] = cfiRule{kind: cfiRegister, reg: r.uleb()}
+	case 0x0a: // DW_CFA_remember_state
+		m.saved = append(m.saved, m.state.clone())
+	case 0x0b: // DW_CFA_restore_state
+		if len(m.saved) == 0 {
+			return fmt.Errorf("%w: restore_state without remember_state", errUnsupportedCFI)
+		}
+		m.state = m.saved[len(m.saved)-1]
+		m.saved = m.saved[:len(m.saved)-1]
+	case 0x0c: // DW_CFA_def_cfa
+		m.state.cfaReg = r.uleb()
+		m.state.cfaOffset = int64(r.uleb())
+	case 0x0d: // DW_CFA_def_cfa_register
+		m.state.cfaReg = r.uleb()
+	case 0x0e: // DW_CFA_def_cfa_offset
+		m.state.cfaOffset = int64(r.uleb())
+	case 0x11: // DW_CFA_offset_extended_sf
+		reg := r.uleb()
+		m.state.regs[reg] = cfiRule{kind: cfiSavedAt, offset: r.sleb() * dataAlign}
+	case 0x12: // DW_CFA_def_cfa_sf
+		m.state.cfaReg = r.uleb()
+		m.state.cfaOffset = r.sleb() * dataAlign
+	case 0x13: // DW_CFA_def_cfa_offset_sf
+		m.state.cfaOffset = r.sleb() * dataAlign
+	case 0x14: // DW_CFA_val_offset
+		reg := r.uleb()
+		m.state.regs[reg] = cfiRule{kind: cfiValue, offset: int64(r.uleb()) * dataAlign}
+	case 0x15: // DW_CFA_val_offset_sf
+		reg := r.uleb()
+		m.state.regs[reg] = cfiRule{kind: cfiValue, offset: r.sleb() * dataAlign}
+	case 0x2d: // DW_CFA_GNU_window_save, DW_CFA_AARCH64_negate_ra_state
+	case 0x2e: // DW_CFA_GNU_args_size
+		r.uleb()
+	case 0x2f: // DW_CFA_GNU_negative_offset_extended
+		reg := r.uleb()
+		m.state.regs[reg] = cfiRule{kind: cfiSavedAt, offset: -int64(r.uleb()) * dataAlign}
+	default:
+		// DW_CFA_def_cfa_expression, DW_CFA_expression,
+		// DW_CFA_val_expression and vendor extensions.
+		return fmt.Errorf("%w: instruction 0x%x", errUnsupportedCFI, op)
+	}
+	return nil
+}
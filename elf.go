@@ -0,0 +1,151 @@
+package resurgo
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+)
+
+// codeSection holds the contents and load address of a section or segment
+// selected for analysis.
+type codeSection struct {
+	name string
+	addr uint64
+	src  source
+}
+
+// contains reports whether addr lies within the section.
+func (s codeSection) contains(addr uint64) bool {
+	return addr >= s.addr && addr < s.addr+uint64(s.src.size)
+}
+
+// elfArch maps an ELF machine to the corresponding architecture.
+func elfArch(m elf.Machine) (Arch, error) {
+	switch m {
+	case elf.EM_X86_64:
+		return ArchAMD64, nil
+	case elf.EM_AARCH64:
+		return ArchARM64, nil
+	default:
+		return "", fmt.Errorf("unsupported ELF machine: %s", m)
+	}
+}
+
+// readELFSections parses an ELF binary from r and returns its architecture
+// together with the contents of the sections listed in opts, in the given
+// order. When opts sets a window size, uncompressed sections are not loaded
+// and are read from r in windows instead.
+func readELFSections(r io.ReaderAt, opts Options) (Arch, []codeSection, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse ELF file: %w", err)
+	}
+	defer f.Close()
+
+	names := opts.sections()
+	sections := make([]codeSection, 0, len(names))
+	for _, name := range names {
+		sec := f.Section(name)
+		if sec == nil {
+			return "", nil, fmt.Errorf("no %s section found", name)
+		}
+
+		src, err := sectionSource(sec, opts)
+		if err != nil {
+			return "", nil, err
+		}
+		sections = append(sections, codeSection{name: name, addr: sec.Addr, src: src})
+	}
+
+	arch, err := elfArch(f.Machine)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return arch, sections, nil
+}
+
+// sectionSource returns a source for the contents of sec. When opts sets a
+// window size, uncompressed sections are not loaded and are read in windows
+// instead.
+func sectionSource(sec *elf.Section, opts Options) (source, error) {
+	if window := opts.windowSize(); window > 0 &&
+		sec.Type != elf.SHT_NOBITS && sec.Flags&elf.SHF_COMPRESSED == 0 {
+		return source{r: sec.ReaderAt, size: int(sec.Size), window: window}, nil
+	}
+
+	data, err := sec.Data()
+	if err != nil && err != io.EOF {
+		return source{}, fmt.Errorf("failed to read %s section: %w", sec.Name, err)
+	}
+	return memSource(data), nil
+}
+
+// elfImage is the Image of an ELF executable or shared object, made of its
+// allocated sections.
+type elfImage struct {
+	f        *elf.File
+	arch     Arch
+	sections []*elf.Section
+	segments []ImageSegment
+}
+
+// NewELFImage parses an ELF executable or shared object from r and returns
+// its Image, with a segment for each section loaded in memory (SHF_ALLOC).
+// Sections marked SHF_EXECINSTR are executable. Compressed sections are
+// decompressed transparently.
+func NewELFImage(r io.ReaderAt) (Image, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ELF file: %w", err)
+	}
+
+	if f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN {
+		return nil, fmt.Errorf("not an ELF executable or shared object: %s", f.Type)
+	}
+
+	arch, err := elfArch(f.Machine)
+	if err != nil {
+		return nil, err
+	}
+
+	img := &elfImage{f: f, arch: arch}
+	for _, sec := range f.Sections {
+		if sec.Flags&elf.SHF_ALLOC == 0 {
+			continue
+		}
+		perms := []byte("r--")
+		if sec.Flags&elf.SHF_WRITE != 0 {
+			perms[1] = 'w'
+		}
+		if sec.Flags&elf.SHF_EXECINSTR != 0 {
+			perms[2] = 'x'
+		}
+		img.sections = append(img.sections, sec)
+		img.segments = append(img.segments, ImageSegment{
+			Name:  sec.Name,
+			Addr:  sec.Addr,
+			Size:  sec.Size,
+			Perms: string(perms),
+		})
+	}
+
+	return img, nil
+}
+
+func (img *elfImage) Arch() Arch { return img.arch }
+
+func (img *elfImage) Segments() []ImageSegment { return img.segments }
+
+func (img *elfImage) SegmentReader(i int) (io.ReaderAt, error) {
+	sec := img.sections[i]
+	if sec.Flags&elf.SHF_COMPRESSED == 0 {
+		return sec.ReaderAt, nil
+	}
+	data, err := sec.Data()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read %s section: %w", sec.Name, err)
+	}
+	return bytes.NewReader(data), nil
+}
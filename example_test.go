@@ -116,3 +116,16 @@ func ExampleDetectFunctions() {
 	// 0x1000: prologue-only (confidence: medium)
 	// 0x1020: both (confidence: high)
 }
+
+func ExamplePrologues() {
+	// x86-64 machine code: nop; push rbp; mov rbp, rsp
+	code := []byte{0x90, 0x55, 0x48, 0x89, 0xe5}
+	for p, err := range resurgo.Prologues(code, 0x1000, resurgo.ArchAMD64) {
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("[%s] 0x%x: %s\n", p.Type, p.Address, p.Instructions)
+	}
+	// Output:
+	// [classic] 0x1001: push rbp; mov rbp, rsp
+}
@@ -0,0 +1,208 @@
+package resurgo
+
+import (
+	"cmp"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"slices"
+
+	"golang.org/x/arch/arm64/arm64asm"
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// Fingerprint holds hashes of the code of a function that survive its
+// move to another address, and another build, for matching functions
+// across releases.
+type Fingerprint struct {
+	// Bytes is the leading 64 bits, big-endian, of the content hash set
+	// as Hash by HashFunctionsFromELF: the SHA-256 of the bytes of the
+	// function with address operands masked. It is the same for functions
+	// of identical code loaded at different addresses.
+	Bytes uint64 `json:"bytes"`
+	// Mnemonics is the hash of the sequence of instruction mnemonics,
+	// ignoring operands: it survives register allocation changes.
+	Mnemonics uint64 `json:"mnemonics"`
+	// MinHash is the MinHash signature of the set of instruction mnemonic
+	// n-grams, whose agreement estimates the Jaccard similarity of the
+	// sets of two functions.
+	MinHash []uint32 `json:"minhash"`
+}
+
+// Fingerprint parameters: the length of the mnemonic n-grams and of the
+// MinHash signatures.
+const (
+	fingerprintNGram = 3
+	minHashSize      = 64
+)
+
+// FingerprintFunction returns the fingerprint of the function whose code
+// is code, for arch. This function performs no I/O.
+//
+// Address operands are masked as for HashFunctionsFromELF: on x86_64,
+// PC-relative displacements, and immediates and displacements of at least
+// 0x10000, taken as absolute addresses; on ARM64, the offsets of branches,
+// ADR, ADRP and literal loads, and the low 12 bits added to or loaded from
+// an ADRP result. Undecodable bytes are hashed as they are.
+func FingerprintFunction(code []byte, arch Arch) Fingerprint {
+	sum := contentHash(code, arch)
+	fp := Fingerprint{Bytes: binary.BigEndian.Uint64(sum[:])}
+	mnemonics := codeMnemonics(code, arch)
+	h := fnv.New64a()
+	for _, m := range mnemonics {
+		h.Write([]byte(m))
+		h.Write([]byte{0})
+	}
+	fp.Mnemonics = h.Sum64()
+	fp.MinHash = minHash(mnemonics)
+	return fp
+}
+
+// codeMnemonics returns the mnemonics of the instructions of code, for
+// arch, with "?" for undecodable bytes. Code of other architectures has no
+// mnemonics.
+func codeMnemonics(code []byte, arch Arch) []string {
+	var mnemonics []string
+	switch arch {
+	case ArchAMD64:
+		for offset := 0; offset < len(code); {
+			inst, err := x86asm.Decode(code[offset:], 64)
+			if err != nil {
+				mnemonics = append(mnemonics, "?")
+				offset++
+				continue
+			}
+			mnemonics = append(mnemonics, inst.Op.String())
+			offset += inst.Len
+		}
+	case ArchARM64:
+		const insnLen = 4
+		for offset := 0; offset+insnLen <= len(code); offset += insnLen {
+			inst, err := arm64asm.Decode(code[offset : offset+insnLen])
+			if err != nil {
+				mnemonics = append(mnemonics, "?")
+				continue
+			}
+			mnemonics = append(mnemonics, inst.Op.String())
+		}
+	}
+	return mnemonics
+}
+
+// minHash returns the MinHash signature of the set of n-grams of tokens;
+// a sequence shorter than an n-gram is a single n-gram.
+func minHash(tokens []string) []uint32 {
+	sig := make([]uint32, minHashSize)
+	for i := range sig {
+		sig[i] = ^uint32(0)
+	}
+	n := min(fingerprintNGram, len(tokens))
+	h := fnv.New64a()
+	for i := 0; i+n <= len(tokens) && n > 0; i++ {
+		h.Reset()
+		for _, t := range tokens[i : i+n] {
+			h.Write([]byte(t))
+			h.Write([]byte{0})
+		}
+		x := h.Sum64()
+		for j := range sig {
+			sig[j] = min(sig[j], uint32(mix64(x+uint64(j)*0x9e3779b97f4a7c15)>>32))
+		}
+	}
+	return sig
+}
+
+// mix64 is the finalizer of SplitMix64, deriving independent hashes of x
+// for the slots of a MinHash signature.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// Similarity returns the similarity of the functions of a and b, from 0 to
+// 1: 1 when their bytes hashes match, and the estimated Jaccard
+// similarity of their mnemonic n-grams otherwise. It is 0 when either
+// fingerprint is the zero value.
+func Similarity(a, b Fingerprint) float64 {
+	if len(a.MinHash) == 0 || len(b.MinHash) == 0 {
+		return 0
+	}
+	if a.Bytes == b.Bytes {
+		return 1
+	}
+	if len(a.MinHash) != len(b.MinHash) {
+		return 0
+	}
+	same := 0
+	for i := range a.MinHash {
+		if a.MinHash[i] == b.MinHash[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(a.MinHash))
+}
+
+// SimilarFunction is a candidate returned by FindSimilar, with its
+// similarity to the fingerprint searched.
+type SimilarFunction struct {
+	Candidate  FunctionCandidate `json:"candidate"`
+	Similarity float64           `json:"similarity"`
+}
+
+// FindSimilar returns the candidates whose fingerprint is at least
+// minSimilarity similar to fp, most similar first and then by address.
+// Candidates without a fingerprint are skipped.
+func FindSimilar(fp Fingerprint, candidates []FunctionCandidate, minSimilarity float64) []SimilarFunction {
+	var similar []SimilarFunction
+	for _, c := range candidates {
+		if c.Fingerprint == nil {
+			continue
+		}
+		if s := Similarity(fp, *c.Fingerprint); s >= minSimilarity {
+			similar = append(similar, SimilarFunction{Candidate: c, Similarity: s})
+		}
+	}
+	slices.SortStableFunc(similar, func(a, b SimilarFunction) int {
+		if c := cmp.Compare(b.Similarity, a.Similarity); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Candidate.Address, b.Candidate.Address)
+	})
+	return similar
+}
+
+// FingerprintFunctionsFromELF returns a copy of candidates, detected in
+// the ELF binary read from r, with Fingerprint set to the fingerprint of
+// each function, sized as by WriteELFSymbols. Candidates outside the
+// sections of the binary get no fingerprint.
+func FingerprintFunctionsFromELF(r io.ReaderAt, candidates []FunctionCandidate) ([]FunctionCandidate, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ELF file: %w", err)
+	}
+	defer f.Close()
+	arch, err := elfArch(f.Machine)
+	if err != nil {
+		return nil, err
+	}
+
+	fps := make(map[uint64]*Fingerprint, len(candidates))
+	err = elfFunctionCode(f, candidates, func(addr uint64, code []byte) {
+		fp := FingerprintFunction(code, arch)
+		fps[addr] = &fp
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	candidates = slices.Clone(candidates)
+	for i := range candidates {
+		candidates[i].Fingerprint = fps[candidates[i].Address]
+	}
+	return candidates, nil
+}
@@ -0,0 +1,201 @@
+package resurgo_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+func TestFingerprintFunction_AMD64(t *testing.T) {
+	// push rbp; mov rbp, rsp; mov <reg>, [rip+disp]; call rel; mov edi, imm;
+	// pop rbp; ret
+	code := func(reg byte, disp, rel, imm uint32) []byte {
+		b := []byte{0x55, 0x48, 0x89, 0xe5, 0x8b, reg}
+		b = binary.LittleEndian.AppendUint32(b, disp)
+		b = append(b, 0xe8)
+		b = binary.LittleEndian.AppendUint32(b, rel)
+		b = append(b, 0xbf)
+		b = binary.LittleEndian.AppendUint32(b, imm)
+		return append(b, 0x5d, 0xc3)
+	}
+	base := resurgo.FingerprintFunction(code(0x05, 0x10, 0x100, 0x404028), resurgo.ArchAMD64)
+
+	tests := []struct {
+		name          string
+		code          []byte
+		sameBytes     bool
+		sameMnemonics bool
+	}{
+		{"moved", code(0x05, 0x3020, 0x200, 0x405048), true, true},
+		{"other register", code(0x0d, 0x10, 0x100, 0x404028), false, true},
+		{"other constant", code(0x05, 0x10, 0x100, 5), false, true},
+		{"truncated", code(0x05, 0x10, 0x100, 0x404028)[:20], false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp := resurgo.FingerprintFunction(tt.code, resurgo.ArchAMD64)
+			if (fp.Bytes == base.Bytes) != tt.sameBytes {
+				t.Errorf("expected same bytes hash %v", tt.sameBytes)
+			}
+			if (fp.Mnemonics == base.Mnemonics) != tt.sameMnemonics {
+				t.Errorf("expected same mnemonics hash %v", tt.sameMnemonics)
+			}
+			if s := resurgo.Similarity(fp, base); tt.sameBytes && s != 1 {
+				t.Errorf("expected similarity 1, got %v", s)
+			}
+		})
+	}
+}
+
+func TestFingerprintFunction_ARM64(t *testing.T) {
+	// stp x29, x30, [sp, #-16]!; mov x29, sp; adrp x0, page; add x0, x0, lo12;
+	// bl rel; ldp x29, x30, [sp], #16; ret
+	code := func(page, lo12, rel uint32) []byte {
+		var b []byte
+		for _, w := range []uint32{
+			0xa9bf7bfd,
+			0x910003fd,
+			0x90000000 | (page&3)<<29 | (page>>2)<<5,
+			0x91000000 | lo12<<10,
+			0x94000000 | rel,
+			0xa8c17bfd,
+			0xd65f03c0,
+		} {
+			b = binary.LittleEndian.AppendUint32(b, w)
+		}
+		return b
+	}
+	a := resurgo.FingerprintFunction(code(1, 0x10, 0x40), resurgo.ArchARM64)
+	b := resurgo.FingerprintFunction(code(7, 0x238, 0x1234), resurgo.ArchARM64)
+	if a.Bytes != b.Bytes || a.Mnemonics != b.Mnemonics {
+		t.Errorf("expected the same fingerprint for moved code, got %+v and %+v", a, b)
+	}
+	if s := resurgo.Similarity(a, b); s != 1 {
+		t.Errorf("expected similarity 1, got %v", s)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	if s := resurgo.Similarity(resurgo.Fingerprint{}, resurgo.Fingerprint{}); s != 0 {
+		t.Errorf("expected similarity 0 for zero fingerprints, got %v", s)
+	}
+
+	// Long functions differing in a single instruction share most n-grams.
+	var a, b []byte
+	for i := range 64 {
+		insn := []byte{0x48, 0x01, 0xc0} // add rax, rax
+		if i%2 == 1 {
+			insn = []byte{0x48, 0x29, 0xd8} // sub rax, rbx
+		}
+		a = append(a, insn...)
+		if i == 32 {
+			insn = []byte{0x48, 0x0f, 0xaf, 0xc3} // imul rax, rbx
+		}
+		b = append(b, insn...)
+	}
+	fa := resurgo.FingerprintFunction(a, resurgo.ArchAMD64)
+	fb := resurgo.FingerprintFunction(b, resurgo.ArchAMD64)
+	if s := resurgo.Similarity(fa, fb); s < 0.3 || s >= 1 {
+		t.Errorf("expected a partial similarity, got %v", s)
+	}
+}
+
+func TestFingerprintFunctionsFromELF(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found, skipping")
+	}
+
+	// Two builds of the same code, the second with an extra function
+	// linked in before it, moving every function.
+	dir := t.TempDir()
+	extra := filepath.Join(dir, "extra.c")
+	if err := os.WriteFile(extra, []byte("int extra(int x) { return x * 3 + 1; }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	type build struct {
+		candidates []resurgo.FunctionCandidate
+		addrs      map[string]uint64
+	}
+	var builds []build
+	for i, srcs := range [][]string{{"testdata/demo-app.c"}, {extra, "testdata/demo-app.c"}} {
+		binPath := filepath.Join(dir, "demo-app"+string(rune('0'+i)))
+		args := append([]string{"-O0", "-fno-pie", "-no-pie", "-o", binPath}, srcs...)
+		if out, err := exec.Command("gcc", args...).CombinedOutput(); err != nil {
+			t.Fatalf("failed to compile: %v\n%s", err, out)
+		}
+		data, err := os.ReadFile(binPath)
+		if err != nil {
+			t.Fatalf("failed to read compiled binary: %v", err)
+		}
+		f, err := elf.NewFile(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to parse ELF file: %v", err)
+		}
+		syms, err := f.Symbols()
+		if err != nil {
+			t.Fatalf("failed to read symbols: %v", err)
+		}
+		addrs := make(map[string]uint64)
+		for _, s := range syms {
+			addrs[s.Name] = s.Value
+		}
+
+		candidates, err := resurgo.DetectFunctionsFromELF(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("DetectFunctionsFromELF: %v", err)
+		}
+		fingerprinted, err := resurgo.FingerprintFunctionsFromELF(bytes.NewReader(data), candidates)
+		if err != nil {
+			t.Fatalf("FingerprintFunctionsFromELF: %v", err)
+		}
+		// The bytes hash is the content hash Diff aligns builds on.
+		hashed, err := resurgo.HashFunctionsFromELF(bytes.NewReader(data), candidates)
+		if err != nil {
+			t.Fatalf("HashFunctionsFromELF: %v", err)
+		}
+		for j, c := range fingerprinted {
+			if c.Fingerprint != nil && fmt.Sprintf("%016x", c.Fingerprint.Bytes) != hashed[j].Hash[:16] {
+				t.Errorf("0x%x: expected bytes hash %s, got %016x", c.Address, hashed[j].Hash[:16], c.Fingerprint.Bytes)
+			}
+		}
+		builds = append(builds, build{fingerprinted, addrs})
+	}
+	if builds[0].addrs["add"] == builds[1].addrs["add"] {
+		t.Fatal("expected the functions to move")
+	}
+
+	byAddr := func(b build, addr uint64) *resurgo.Fingerprint {
+		i := slices.IndexFunc(b.candidates, func(c resurgo.FunctionCandidate) bool { return c.Address == addr })
+		if i < 0 || b.candidates[i].Fingerprint == nil {
+			t.Fatalf("expected a fingerprinted candidate at 0x%x", addr)
+		}
+		return b.candidates[i].Fingerprint
+	}
+	for _, name := range []string{"add", "multiply", "subtract", "divide", "main"} {
+		fp := byAddr(builds[0], builds[0].addrs[name])
+		similar := resurgo.FindSimilar(*fp, builds[1].candidates, 1)
+		if len(similar) != 1 || similar[0].Candidate.Address != builds[1].addrs[name] {
+			t.Errorf("%s: expected a single match at 0x%x, got %+v", name, builds[1].addrs[name], similar)
+		}
+	}
+
+	// The other arithmetic helpers differ from add in a single instruction:
+	// they follow add itself, with a partial similarity.
+	similar := resurgo.FindSimilar(*byAddr(builds[0], builds[0].addrs["add"]), builds[1].candidates, 0.5)
+	if len(similar) < 2 || similar[0].Similarity != 1 || similar[1].Similarity >= 1 {
+		t.Fatalf("expected add then partial matches, got %+v", similar)
+	}
+	for _, s := range similar[1:] {
+		if s.Similarity > similar[1].Similarity {
+			t.Errorf("expected matches sorted by similarity, got %+v", similar)
+		}
+	}
+}
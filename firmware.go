@@ -0,0 +1,318 @@
+package resurgo
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Region describes an area of the target memory map, such as a flash bank
+// holding code.
+type Region struct {
+	Name string `json:"name,omitempty"`
+	Addr uint64 `json:"addr"`
+	// Size is the size of the region in bytes. Zero extends a plain binary
+	// region up to the end of the image.
+	Size uint64 `json:"size"`
+	// Offset is the file offset of the region in a plain binary image. It
+	// is ignored for Intel HEX and S-record images, whose records carry
+	// their addresses.
+	Offset uint64 `json:"offset,omitempty"`
+}
+
+// Segment is a contiguous block of memory loaded from an image.
+type Segment struct {
+	// Name is the name of the region the segment was loaded into, if any.
+	Name string `json:"name,omitempty"`
+	Addr uint64 `json:"addr"`
+	Data []byte `json:"-"`
+}
+
+// SegmentFunctions holds the function candidates detected in a segment.
+type SegmentFunctions struct {
+	Name      string              `json:"name,omitempty"`
+	Addr      uint64              `json:"addr"`
+	Size      uint64              `json:"size"`
+	Functions []FunctionCandidate `json:"functions"`
+}
+
+// LoadBinary loads the regions of a plain binary image, such as a flash
+// dump, read from r. Each region is read from its file offset and loaded at
+// its address.
+func LoadBinary(r io.ReaderAt, regions []Region) ([]Segment, error) {
+	if len(regions) == 0 {
+		return nil, fmt.Errorf("no memory regions given")
+	}
+
+	segments := make([]Segment, 0, len(regions))
+	for _, reg := range regions {
+		var data []byte
+		if reg.Size == 0 {
+			var err error
+			data, err = io.ReadAll(io.NewSectionReader(r, int64(reg.Offset), 1<<63-1-int64(reg.Offset)))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read region %s: %w", reg.Name, err)
+			}
+		} else {
+			data = make([]byte, reg.Size)
+			if _, err := r.ReadAt(data, int64(reg.Offset)); err != nil {
+				return nil, fmt.Errorf("failed to read region %s: %w", reg.Name, err)
+			}
+		}
+		segments = append(segments, Segment{Name: reg.Name, Addr: reg.Addr, Data: data})
+	}
+
+	return segments, nil
+}
+
+// LoadIntelHex loads an Intel HEX image read from r. Data records are
+// placed at their address, extended with the segment (type 02) or linear
+// (type 04) base address in effect. With regions, the loaded bytes are
+// split along the memory map and bytes outside every region are dropped;
+// otherwise one segment is returned per contiguous run of bytes.
+func LoadIntelHex(r io.Reader, regions []Region) ([]Segment, error) {
+	var chunks []Segment
+	var base uint64
+
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		if text[0] != ':' {
+			return nil, fmt.Errorf("line %d: missing record mark", line)
+		}
+		rec, err := hex.DecodeString(text[1:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(rec) < 5 || len(rec) != 5+int(rec[0]) {
+			return nil, fmt.Errorf("line %d: malformed record", line)
+		}
+		if checksum(rec) != 0 {
+			return nil, fmt.Errorf("line %d: checksum mismatch", line)
+		}
+
+		addr := uint64(rec[1])<<8 | uint64(rec[2])
+		data := rec[4 : len(rec)-1]
+		switch rec[3] {
+		case 0x00: // data
+			chunks = append(chunks, Segment{Addr: base + addr, Data: data})
+		case 0x01: // end of file
+			return splitSegments(chunks, regions), nil
+		case 0x02: // extended segment address
+			if len(data) != 2 {
+				return nil, fmt.Errorf("line %d: malformed extended segment address", line)
+			}
+			base = (uint64(data[0])<<8 | uint64(data[1])) << 4
+		case 0x04: // extended linear address
+			if len(data) != 2 {
+				return nil, fmt.Errorf("line %d: malformed extended linear address", line)
+			}
+			base = (uint64(data[0])<<8 | uint64(data[1])) << 16
+		case 0x03, 0x05: // start address
+		default:
+			return nil, fmt.Errorf("line %d: unknown record type 0x%02x", line, rec[3])
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read Intel HEX image: %w", err)
+	}
+
+	return nil, fmt.Errorf("missing end of file record")
+}
+
+// LoadSRecord loads a Motorola S-record image read from r. Data records
+// (S1, S2 and S3) are placed at their address. Regions split the loaded
+// bytes as in LoadIntelHex.
+func LoadSRecord(r io.Reader, regions []Region) ([]Segment, error) {
+	var chunks []Segment
+
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		if len(text) < 2 || text[0] != 'S' {
+			return nil, fmt.Errorf("line %d: missing record mark", line)
+		}
+		rec, err := hex.DecodeString(text[2:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(rec) < 2 || len(rec) != 1+int(rec[0]) {
+			return nil, fmt.Errorf("line %d: malformed record", line)
+		}
+		// The checksum is the ones' complement of the sum of the other
+		// bytes, so all the bytes sum to 0xff.
+		if checksum(rec) != 0xff {
+			return nil, fmt.Errorf("line %d: checksum mismatch", line)
+		}
+
+		var addrLen int
+		switch text[1] {
+		case '1':
+			addrLen = 2
+		case '2':
+			addrLen = 3
+		case '3':
+			addrLen = 4
+		case '0', '5', '6', '7', '8', '9':
+			// Header, record count and start address.
+			continue
+		default:
+			return nil, fmt.Errorf("line %d: unknown record type S%c", line, text[1])
+		}
+		if len(rec) < 2+addrLen {
+			return nil, fmt.Errorf("line %d: malformed record", line)
+		}
+
+		var addr uint64
+		for _, b := range rec[1 : 1+addrLen] {
+			addr = addr<<8 | uint64(b)
+		}
+		chunks = append(chunks, Segment{Addr: addr, Data: rec[1+addrLen : len(rec)-1]})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read S-record image: %w", err)
+	}
+
+	return splitSegments(chunks, regions), nil
+}
+
+// checksum returns the low byte of the sum of b.
+func checksum(b []byte) byte {
+	var sum byte
+	for _, v := range b {
+		sum += v
+	}
+	return sum
+}
+
+// splitSegments coalesces chunks into runs of contiguous bytes, and splits
+// the runs along regions, if any. Overlapping chunks are resolved in favour
+// of the later one in chunks.
+func splitSegments(chunks []Segment, regions []Region) []Segment {
+	// Lay out the runs from the chunks sorted by address, then fill them in
+	// the original order, so that later chunks overwrite earlier ones.
+	sorted := slices.Clone(chunks)
+	slices.SortStableFunc(sorted, func(a, b Segment) int { return cmp.Compare(a.Addr, b.Addr) })
+	var runs []Segment
+	for _, c := range sorted {
+		end := c.Addr + uint64(len(c.Data))
+		if n := len(runs); n > 0 && c.Addr <= runs[n-1].Addr+uint64(len(runs[n-1].Data)) {
+			run := &runs[n-1]
+			if size := end - run.Addr; size > uint64(len(run.Data)) {
+				run.Data = append(run.Data, make([]byte, size-uint64(len(run.Data)))...)
+			}
+			continue
+		}
+		runs = append(runs, Segment{Addr: c.Addr, Data: make([]byte, len(c.Data))})
+	}
+	for _, c := range chunks {
+		i, found := slices.BinarySearchFunc(runs, c.Addr, func(run Segment, addr uint64) int {
+			return cmp.Compare(run.Addr, addr)
+		})
+		if !found {
+			i--
+		}
+		copy(runs[i].Data[c.Addr-runs[i].Addr:], c.Data)
+	}
+
+	if len(regions) == 0 {
+		return runs
+	}
+
+	var segments []Segment
+	for _, reg := range regions {
+		for _, run := range runs {
+			start := max(reg.Addr, run.Addr)
+			end := min(reg.Addr+reg.Size, run.Addr+uint64(len(run.Data)))
+			if start >= end {
+				continue
+			}
+			segments = append(segments, Segment{
+				Name: reg.Name,
+				Addr: start,
+				Data: run.Data[start-run.Addr : end-run.Addr],
+			})
+		}
+	}
+	return segments
+}
+
+// segmentImage is the Image of loaded segments.
+type segmentImage struct {
+	arch     Arch
+	segments []Segment
+}
+
+// NewSegmentImage returns the Image of segments holding code for arch, such
+// as the segments returned by LoadBinary, LoadIntelHex or LoadSRecord. All
+// the segments are executable.
+func NewSegmentImage(segments []Segment, arch Arch) Image {
+	return &segmentImage{arch: arch, segments: segments}
+}
+
+func (img *segmentImage) Arch() Arch { return img.arch }
+
+func (img *segmentImage) Segments() []ImageSegment {
+	segments := make([]ImageSegment, len(img.segments))
+	for i, seg := range img.segments {
+		segments[i] = ImageSegment{Name: seg.Name, Addr: seg.Addr, Size: uint64(len(seg.Data)), Perms: "r-x"}
+	}
+	return segments
+}
+
+func (img *segmentImage) SegmentReader(i int) (io.ReaderAt, error) {
+	return bytes.NewReader(img.segments[i].Data), nil
+}
+
+// DetectFunctionsFromSegments runs DetectFunctions on each segment, using
+// the segment address as base address, and returns the candidates of each
+// segment. Segments typically come from LoadBinary, LoadIntelHex or
+// LoadSRecord. Each segment is analyzed in isolation; use
+// DetectFunctionsFromImage with NewSegmentImage to resolve calls between
+// segments.
+func DetectFunctionsFromSegments(segments []Segment, arch Arch) ([]SegmentFunctions, error) {
+	return DetectFunctionsFromSegmentsContext(context.Background(), segments, arch, Options{})
+}
+
+// DetectFunctionsFromSegmentsWithOptions is like DetectFunctionsFromSegments
+// but applies the settings in opts to each segment.
+func DetectFunctionsFromSegmentsWithOptions(segments []Segment, arch Arch, opts Options) ([]SegmentFunctions, error) {
+	return DetectFunctionsFromSegmentsContext(context.Background(), segments, arch, opts)
+}
+
+// DetectFunctionsFromSegmentsContext is like
+// DetectFunctionsFromSegmentsWithOptions but stops with the context error as
+// soon as ctx is done, and reports progress to opts.Progress, labelled with
+// the segment name.
+func DetectFunctionsFromSegmentsContext(ctx context.Context, segments []Segment, arch Arch, opts Options) ([]SegmentFunctions, error) {
+	result := make([]SegmentFunctions, 0, len(segments))
+	for _, seg := range segments {
+		opts.logger().Debug("analyzing segment",
+			"name", seg.Name, "addr", seg.Addr, "size", len(seg.Data))
+
+		opts.section = seg.Name
+		candidates, err := detectFunctions(ctx, memSource(seg.Data), seg.Addr, arch, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to analyze segment 0x%x: %w", seg.Addr, err)
+		}
+		result = append(result, SegmentFunctions{
+			Name:      seg.Name,
+			Addr:      seg.Addr,
+			Size:      uint64(len(seg.Data)),
+			Functions: opts.filterCandidates(candidates),
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,181 @@
+package resurgo_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+func TestLoadBinary(t *testing.T) {
+	image := []byte{0, 1, 2, 3, 4, 5, 6, 7}
+
+	segments, err := resurgo.LoadBinary(bytes.NewReader(image), []resurgo.Region{
+		{Name: "boot", Addr: 0x08000000, Size: 4},
+		{Name: "app", Addr: 0x08010000, Offset: 4},
+	})
+	if err != nil {
+		t.Fatalf("LoadBinary failed: %v", err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("expected 2 segments, got %d", len(segments))
+	}
+	if s := segments[0]; s.Name != "boot" || s.Addr != 0x08000000 || !bytes.Equal(s.Data, image[:4]) {
+		t.Errorf("unexpected boot segment: %+v", s)
+	}
+	if s := segments[1]; s.Name != "app" || s.Addr != 0x08010000 || !bytes.Equal(s.Data, image[4:]) {
+		t.Errorf("unexpected app segment: %+v", s)
+	}
+
+	if _, err := resurgo.LoadBinary(bytes.NewReader(image), []resurgo.Region{{Addr: 0, Size: 16}}); err == nil {
+		t.Error("expected error for region past the end of the image")
+	}
+	if _, err := resurgo.LoadBinary(bytes.NewReader(image), nil); err == nil {
+		t.Error("expected error for empty memory map")
+	}
+}
+
+func TestLoadIntelHex(t *testing.T) {
+	// Two contiguous data records at 0x1000 under extended linear address
+	// 0x0800, and a third after a gap.
+	const image = `:020000040800F2
+:04100000554889E5E1
+:02100400C39097
+:02200000C3908B
+:00000001FF
+`
+
+	segments, err := resurgo.LoadIntelHex(strings.NewReader(image), nil)
+	if err != nil {
+		t.Fatalf("LoadIntelHex failed: %v", err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("expected 2 segments, got %d", len(segments))
+	}
+	if s := segments[0]; s.Addr != 0x08001000 || !bytes.Equal(s.Data, []byte{0x55, 0x48, 0x89, 0xe5, 0xc3, 0x90}) {
+		t.Errorf("unexpected first segment: addr=0x%x data=% x", s.Addr, s.Data)
+	}
+	if s := segments[1]; s.Addr != 0x08002000 || !bytes.Equal(s.Data, []byte{0xc3, 0x90}) {
+		t.Errorf("unexpected second segment: addr=0x%x data=% x", s.Addr, s.Data)
+	}
+
+	// A memory map keeps only the bytes inside its regions.
+	segments, err = resurgo.LoadIntelHex(strings.NewReader(image), []resurgo.Region{
+		{Name: "flash", Addr: 0x08001002, Size: 0x10},
+	})
+	if err != nil {
+		t.Fatalf("LoadIntelHex failed: %v", err)
+	}
+	if len(segments) != 1 {
+		t.Fatalf("expected 1 segment, got %d", len(segments))
+	}
+	if s := segments[0]; s.Name != "flash" || s.Addr != 0x08001002 || !bytes.Equal(s.Data, []byte{0x89, 0xe5, 0xc3, 0x90}) {
+		t.Errorf("unexpected segment: %+v", s)
+	}
+}
+
+func TestLoadIntelHex_Overlap(t *testing.T) {
+	// A record at 0x100, overlapped by a later record at 0xfe, itself
+	// overlapped by a later one-byte record at 0x102.
+	const image = `:0401000011111111B7
+:0400FE002222222276
+:0101020033C9
+:00000001FF
+`
+
+	segments, err := resurgo.LoadIntelHex(strings.NewReader(image), nil)
+	if err != nil {
+		t.Fatalf("LoadIntelHex failed: %v", err)
+	}
+	want := []byte{0x22, 0x22, 0x22, 0x22, 0x33, 0x11}
+	if len(segments) != 1 || segments[0].Addr != 0xfe || !bytes.Equal(segments[0].Data, want) {
+		t.Fatalf("expected the later records to win, got %+v", segments)
+	}
+}
+
+func TestLoadIntelHex_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		image string
+	}{
+		{"missing-mark", "04100000554889E53B\n:00000001FF\n"},
+		{"bad-checksum", ":04100000554889E5E2\n:00000001FF\n"},
+		{"bad-length", ":05100000554889E5E0\n:00000001FF\n"},
+		{"missing-eof", ":04100000554889E5E1\n"},
+		{"unknown-type", ":00000006FA\n:00000001FF\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := resurgo.LoadIntelHex(strings.NewReader(tt.image), nil); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestLoadSRecord(t *testing.T) {
+	// A header, an S1 and an S3 data record, and a start address.
+	const image = `S00600004844521B
+S1071000554889E5DD
+S30808001000C390C3C9
+S9031000EC
+`
+
+	segments, err := resurgo.LoadSRecord(strings.NewReader(image), nil)
+	if err != nil {
+		t.Fatalf("LoadSRecord failed: %v", err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("expected 2 segments, got %d", len(segments))
+	}
+	if s := segments[0]; s.Addr != 0x1000 || !bytes.Equal(s.Data, []byte{0x55, 0x48, 0x89, 0xe5}) {
+		t.Errorf("unexpected first segment: addr=0x%x data=% x", s.Addr, s.Data)
+	}
+	if s := segments[1]; s.Addr != 0x08001000 || !bytes.Equal(s.Data, []byte{0xc3, 0x90, 0xc3}) {
+		t.Errorf("unexpected second segment: addr=0x%x data=% x", s.Addr, s.Data)
+	}
+
+	if _, err := resurgo.LoadSRecord(strings.NewReader("S1071000554889E5DE\n"), nil); err == nil {
+		t.Error("expected error for checksum mismatch")
+	}
+}
+
+func TestDetectFunctionsFromSegments(t *testing.T) {
+	// Region "a": call 0x1010; nop...; push rbp; mov rbp, rsp; ret
+	// Region "b": push rbp; mov rbp, rsp; ret
+	a := append([]byte{0xe8, 0x0b, 0x00, 0x00, 0x00}, bytes.Repeat([]byte{0x90}, 11)...)
+	a = append(a, 0x55, 0x48, 0x89, 0xe5, 0xc3)
+	b := []byte{0x55, 0x48, 0x89, 0xe5, 0xc3}
+
+	result, err := resurgo.DetectFunctionsFromSegments([]resurgo.Segment{
+		{Name: "a", Addr: 0x1000, Data: a},
+		{Name: "b", Addr: 0x2000, Data: b},
+	}, resurgo.ArchAMD64)
+	if err != nil {
+		t.Fatalf("DetectFunctionsFromSegments failed: %v", err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("expected 2 segment results, got %d", len(result))
+	}
+
+	if r := result[0]; r.Name != "a" || r.Addr != 0x1000 || r.Size != uint64(len(a)) {
+		t.Errorf("unexpected segment a: %+v", r)
+	}
+	found := false
+	for _, c := range result[0].Functions {
+		if c.Address == 0x1010 {
+			found = true
+			if c.Confidence != resurgo.ConfidenceHigh {
+				t.Errorf("expected high confidence at 0x1010, got %s", c.Confidence)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected candidate at 0x1010, got %+v", result[0].Functions)
+	}
+
+	if len(result[1].Functions) == 0 || result[1].Functions[0].Address != 0x2000 {
+		t.Errorf("expected candidate at 0x2000, got %+v", result[1].Functions)
+	}
+}
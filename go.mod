@@ -2,4 +2,7 @@ module github.com/maxgio92/resurgo
 
 go 1.25.7
 
-require golang.org/x/arch v0.24.0
+require (
+	github.com/ulikunitz/xz v0.5.15
+	golang.org/x/arch v0.24.0
+)
@@ -0,0 +1,332 @@
+package resurgo
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// GraphOptions configures the export of a CallGraph by WriteDOT,
+// WriteGraphML and WriteJSON. The zero value exports the whole graph.
+type GraphOptions struct {
+	// MinConfidence drops the functions whose confidence is lower than the
+	// given level, and the edges from and to them. The empty value keeps
+	// everything.
+	MinConfidence Confidence
+
+	// Around restricts the export to the functions containing the given
+	// addresses and the functions within Depth edges of them, following
+	// edges in either direction. A nil or empty slice places no
+	// restriction.
+	Around []uint64
+
+	// Depth is the number of edges followed from the functions of Around.
+	// Values lower than 1 follow a single edge.
+	Depth int
+}
+
+// graphView is the part of a CallGraph selected by GraphOptions: the
+// functions kept, by position, and the edges between them.
+type graphView struct {
+	nodes []FunctionCandidate
+	edges []CallGraphEdge
+}
+
+// view selects the functions and edges of g exported with opts.
+func (g *CallGraph) view(opts GraphOptions) graphView {
+	filter := Options{MinConfidence: opts.MinConfidence}
+	keep := make([]bool, len(g.functions))
+	for i, c := range g.candidates {
+		keep[i] = filter.meetsConfidence(c.Confidence)
+	}
+
+	if len(opts.Around) > 0 {
+		// Breadth-first search through the functions kept, both ways.
+		depth := max(opts.Depth, 1)
+		dist := make([]int, len(g.functions))
+		for i := range dist {
+			dist[i] = -1
+		}
+		var queue []int
+		for _, addr := range opts.Around {
+			if fn, ok := g.Function(addr); ok {
+				if i := g.index[fn]; keep[i] && dist[i] < 0 {
+					dist[i] = 0
+					queue = append(queue, i)
+				}
+			}
+		}
+		visit := func(fn uint64, d int) {
+			if j := g.index[fn]; keep[j] && dist[j] < 0 {
+				dist[j] = d
+				queue = append(queue, j)
+			}
+		}
+		for len(queue) > 0 {
+			i := queue[0]
+			queue = queue[1:]
+			if dist[i] == depth {
+				continue
+			}
+			for _, e := range g.out[i] {
+				visit(e.Callee, dist[i]+1)
+			}
+			for _, e := range g.in[i] {
+				visit(e.Caller, dist[i]+1)
+			}
+		}
+		for i := range keep {
+			keep[i] = dist[i] >= 0
+		}
+	}
+
+	var v graphView
+	for i, c := range g.candidates {
+		if keep[i] {
+			v.nodes = append(v.nodes, c)
+		}
+	}
+	for _, e := range g.Edges() {
+		if keep[g.index[e.Caller]] && keep[g.index[e.Callee]] {
+			v.edges = append(v.edges, e)
+		}
+	}
+	return v
+}
+
+// graphID returns the identifier of the function at addr in DOT, GraphML
+// and JSON output.
+func graphID(addr uint64) string {
+	return fmt.Sprintf("0x%x", addr)
+}
+
+// WriteDOT writes the graph to w in the Graphviz DOT language, selected by
+// opts. Functions are labelled with their name, or sub_ followed by their
+// address, and carry their confidence, detection type and prologue type.
+// Edges carry the address of their call site, its type and its addressing
+// mode; jumps are dashed.
+func (g *CallGraph) WriteDOT(w io.Writer, opts GraphOptions) error {
+	v := g.view(opts)
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph callgraph {")
+	fmt.Fprintln(bw, "\tnode [shape=box];")
+	for _, c := range v.nodes {
+		fmt.Fprintf(bw, "\t%s [%s];\n", dotQuote(graphID(c.Address)), dotAttrs(
+			"label", symbolName(c),
+			"confidence", string(c.Confidence),
+			"detection_type", string(c.DetectionType),
+			"prologue_type", string(c.PrologueType),
+		))
+	}
+	for _, e := range v.edges {
+		style := ""
+		if e.Type == CallSiteJump {
+			style = "dashed"
+		}
+		fmt.Fprintf(bw, "\t%s -> %s [%s];\n", dotQuote(graphID(e.Caller)), dotQuote(graphID(e.Callee)), dotAttrs(
+			"site", graphID(e.Site),
+			"type", string(e.Type),
+			"address_mode", string(e.AddressMode),
+			"style", style,
+		))
+	}
+	fmt.Fprintln(bw, "}")
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write DOT graph: %w", err)
+	}
+	return nil
+}
+
+// dotAttrs formats the name and value pairs of kv as a DOT attribute list,
+// skipping empty values.
+func dotAttrs(kv ...string) string {
+	var attrs []string
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			attrs = append(attrs, kv[i]+"="+dotQuote(kv[i+1]))
+		}
+	}
+	return strings.Join(attrs, ", ")
+}
+
+// dotQuote returns s as a DOT quoted string.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// graphMLKeys are the attributes of GraphML nodes and edges.
+var graphMLKeys = []graphMLKey{
+	{ID: "label", For: "node", Name: "label", Type: "string"},
+	{ID: "address", For: "node", Name: "address", Type: "string"},
+	{ID: "confidence", For: "node", Name: "confidence", Type: "string"},
+	{ID: "detection_type", For: "node", Name: "detection_type", Type: "string"},
+	{ID: "prologue_type", For: "node", Name: "prologue_type", Type: "string"},
+	{ID: "site", For: "edge", Name: "site", Type: "string"},
+	{ID: "type", For: "edge", Name: "type", Type: "string"},
+	{ID: "address_mode", For: "edge", Name: "address_mode", Type: "string"},
+}
+
+// graphMLDataOf returns the data of the key and value pairs of kv, skipping
+// empty values.
+func graphMLDataOf(kv ...string) []graphMLData {
+	var data []graphMLData
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			data = append(data, graphMLData{Key: kv[i], Value: kv[i+1]})
+		}
+	}
+	return data
+}
+
+// WriteGraphML writes the graph to w in GraphML, selected by opts, with the
+// same attributes as WriteDOT. Addresses are written as hexadecimal
+// strings.
+func (g *CallGraph) WriteGraphML(w io.Writer, opts GraphOptions) error {
+	v := g.view(opts)
+	doc := graphMLDocument{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys:  graphMLKeys,
+		Graph: graphMLGraph{ID: "callgraph", EdgeDefault: "directed"},
+	}
+	for _, c := range v.nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: graphID(c.Address),
+			Data: graphMLDataOf(
+				"label", symbolName(c),
+				"address", graphID(c.Address),
+				"confidence", string(c.Confidence),
+				"detection_type", string(c.DetectionType),
+				"prologue_type", string(c.PrologueType),
+			),
+		})
+	}
+	for _, e := range v.edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: graphID(e.Caller),
+			Target: graphID(e.Callee),
+			Data: graphMLDataOf(
+				"site", graphID(e.Site),
+				"type", string(e.Type),
+				"address_mode", string(e.AddressMode),
+			),
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("failed to write GraphML graph: %w", err)
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed to write GraphML graph: %w", err)
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return fmt.Errorf("failed to write GraphML graph: %w", err)
+	}
+	return nil
+}
+
+// GraphNode is a function in the node-link JSON export of a CallGraph.
+type GraphNode struct {
+	ID            string        `json:"id"`
+	Label         string        `json:"label"`
+	Confidence    Confidence    `json:"confidence,omitempty"`
+	DetectionType DetectionType `json:"detection_type,omitempty"`
+	PrologueType  PrologueType  `json:"prologue_type,omitempty"`
+}
+
+// GraphLink is an edge in the node-link JSON export of a CallGraph, from
+// the node of its caller to the node of its callee.
+type GraphLink struct {
+	Source      string         `json:"source"`
+	Target      string         `json:"target"`
+	Site        string         `json:"site"`
+	Type        CallSiteType   `json:"type"`
+	AddressMode AddressingMode `json:"address_mode,omitempty"`
+}
+
+// GraphJSON is the node-link JSON export of a CallGraph, as written by
+// WriteJSON. Nodes are identified by the address of their function, and
+// addresses are hex strings such as "0x401000": JSON numbers lose precision
+// above 2^53 in JavaScript viewers, which kernel addresses exceed.
+type GraphJSON struct {
+	Directed   bool        `json:"directed"`
+	Multigraph bool        `json:"multigraph"`
+	Nodes      []GraphNode `json:"nodes"`
+	Links      []GraphLink `json:"links"`
+}
+
+// WriteJSON writes the graph to w as node-link JSON (GraphJSON), selected
+// by opts, with the same attributes as WriteDOT. The format is the one
+// read by networkx's node_link_graph and by D3 force layouts.
+func (g *CallGraph) WriteJSON(w io.Writer, opts GraphOptions) error {
+	v := g.view(opts)
+	doc := GraphJSON{
+		Directed:   true,
+		Multigraph: true,
+		Nodes:      make([]GraphNode, 0, len(v.nodes)),
+		Links:      make([]GraphLink, 0, len(v.edges)),
+	}
+	for _, c := range v.nodes {
+		doc.Nodes = append(doc.Nodes, GraphNode{
+			ID:            graphID(c.Address),
+			Label:         symbolName(c),
+			Confidence:    c.Confidence,
+			DetectionType: c.DetectionType,
+			PrologueType:  c.PrologueType,
+		})
+	}
+	for _, e := range v.edges {
+		doc.Links = append(doc.Links, GraphLink{
+			Source:      graphID(e.Caller),
+			Target:      graphID(e.Callee),
+			Site:        graphID(e.Site),
+			Type:        e.Type,
+			AddressMode: e.AddressMode,
+		})
+	}
+	if err := json.NewEncoder(w).Encode(doc); err != nil {
+		return fmt.Errorf("failed to write JSON graph: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package resurgo_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+// testExportGraph returns the graph of testCallGraph, with confidences and
+// addressing modes: D has low confidence.
+func testExportGraph() *resurgo.CallGraph {
+	candidates := []resurgo.FunctionCandidate{
+		{Address: fnMain, Name: "main", Confidence: resurgo.ConfidenceHigh, DetectionType: resurgo.DetectionPrologueOnly, PrologueType: resurgo.PrologueClassic},
+		{Address: fnA, Confidence: resurgo.ConfidenceHigh, DetectionType: resurgo.DetectionBoth, PrologueType: resurgo.PrologueClassic},
+		{Address: fnB, Confidence: resurgo.ConfidenceHigh, DetectionType: resurgo.DetectionBoth},
+		{Address: fnC, Confidence: resurgo.ConfidenceMedium, DetectionType: resurgo.DetectionCallTarget},
+		{Address: fnD, Confidence: resurgo.ConfidenceLow, DetectionType: resurgo.DetectionJumpTarget},
+		{Address: fnE, Confidence: resurgo.ConfidenceMedium, DetectionType: resurgo.DetectionPadding},
+	}
+	call := func(site, target uint64) resurgo.CallSiteEdge {
+		return resurgo.CallSiteEdge{SourceAddr: site, TargetAddr: target, Type: resurgo.CallSiteCall, AddressMode: resurgo.AddressingModePCRelative}
+	}
+	return resurgo.NewCallGraphFromCallSites(candidates, []resurgo.CallSiteEdge{
+		call(0x1005, fnA),
+		call(0x100a, fnB),
+		call(0x1110, fnC),
+		{SourceAddr: 0x1120, TargetAddr: fnD, Type: resurgo.CallSiteJump, AddressMode: resurgo.AddressingModePCRelative, JumpKind: resurgo.JumpTailCall},
+		call(0x1210, fnB),
+		call(0x1305, fnA),
+		call(0x1505, fnD),
+		// Not a candidate, and an internal jump.
+		call(0x1010, 0x1234),
+		{SourceAddr: 0x1130, TargetAddr: 0x1108, Type: resurgo.CallSiteJump, JumpKind: resurgo.JumpInternal},
+	})
+}
+
+func TestNewCallGraphFromCallSites(t *testing.T) {
+	g := testExportGraph()
+
+	want := []resurgo.CallGraphEdge{
+		{Caller: fnA, Callee: fnC, Site: 0x1110, Type: resurgo.CallSiteCall, AddressMode: resurgo.AddressingModePCRelative},
+		{Caller: fnA, Callee: fnD, Site: 0x1120, Type: resurgo.CallSiteJump, AddressMode: resurgo.AddressingModePCRelative},
+	}
+	if got := g.Calls(fnA); !slices.Equal(got, want) {
+		t.Errorf("Calls(A): expected %+v, got %+v", want, got)
+	}
+	if got := len(g.Edges()); got != 7 {
+		t.Errorf("Edges: expected 7, got %d", got)
+	}
+	if c, ok := g.Candidate(fnMain); !ok || c.Name != "main" {
+		t.Errorf("Candidate(main): expected main, got %+v (%v)", c, ok)
+	}
+}
+
+func exportJSON(t *testing.T, g *resurgo.CallGraph, opts resurgo.GraphOptions) resurgo.GraphJSON {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := g.WriteJSON(&buf, opts); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var doc resurgo.GraphJSON
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("decoding JSON: %v", err)
+	}
+	return doc
+}
+
+func nodeIDs(doc resurgo.GraphJSON) []string {
+	var ids []string
+	for _, n := range doc.Nodes {
+		ids = append(ids, n.ID)
+	}
+	return ids
+}
+
+func TestCallGraph_WriteJSON(t *testing.T) {
+	g := testExportGraph()
+
+	doc := exportJSON(t, g, resurgo.GraphOptions{})
+	if len(doc.Nodes) != 6 || len(doc.Links) != 7 {
+		t.Fatalf("expected 6 nodes and 7 links, got %d and %d", len(doc.Nodes), len(doc.Links))
+	}
+	if n := doc.Nodes[1]; n.Label != "sub_1100" || n.Confidence != resurgo.ConfidenceHigh ||
+		n.DetectionType != resurgo.DetectionBoth || n.PrologueType != resurgo.PrologueClassic {
+		t.Errorf("node A: got %+v", n)
+	}
+	want := resurgo.GraphLink{Source: "0x1000", Target: "0x1100", Site: "0x1005", Type: resurgo.CallSiteCall, AddressMode: resurgo.AddressingModePCRelative}
+	if doc.Links[0] != want {
+		t.Errorf("first link: expected %+v, got %+v", want, doc.Links[0])
+	}
+
+	// D and its edges are dropped.
+	doc = exportJSON(t, g, resurgo.GraphOptions{MinConfidence: resurgo.ConfidenceMedium})
+	if got, want := nodeIDs(doc), []string{"0x1000", "0x1100", "0x1200", "0x1300", "0x1500"}; !slices.Equal(got, want) {
+		t.Errorf("MinConfidence: expected nodes %v, got %v", want, got)
+	}
+	if len(doc.Links) != 5 {
+		t.Errorf("MinConfidence: expected 5 links, got %d", len(doc.Links))
+	}
+
+	// Around C: A calls C, and C calls A; one edge further are main and D.
+	doc = exportJSON(t, g, resurgo.GraphOptions{Around: []uint64{fnC + 4}})
+	if got, want := nodeIDs(doc), []string{"0x1100", "0x1300"}; !slices.Equal(got, want) {
+		t.Errorf("Around: expected nodes %v, got %v", want, got)
+	}
+	doc = exportJSON(t, g, resurgo.GraphOptions{Around: []uint64{fnC}, Depth: 2})
+	if got, want := nodeIDs(doc), []string{"0x1000", "0x1100", "0x1300", "0x1400"}; !slices.Equal(got, want) {
+		t.Errorf("Around with depth 2: expected nodes %v, got %v", want, got)
+	}
+}
+
+func TestCallGraph_WriteJSON_KernelAddresses(t *testing.T) {
+	// Kernel addresses do not fit the 53 bits of a JavaScript number.
+	const start, stop = 0xffffffff81000001, 0xffffffff81000011
+	g := resurgo.NewCallGraphFromCallSites([]resurgo.FunctionCandidate{{Address: start}, {Address: stop}}, []resurgo.CallSiteEdge{
+		{SourceAddr: start + 4, TargetAddr: stop, Type: resurgo.CallSiteCall},
+	})
+	var buf bytes.Buffer
+	if err := g.WriteJSON(&buf, resurgo.GraphOptions{}); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	for _, s := range []string{`"id":"0xffffffff81000001"`, `"source":"0xffffffff81000001"`, `"target":"0xffffffff81000011"`, `"site":"0xffffffff81000005"`} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("expected %s in %s", s, buf.String())
+		}
+	}
+}
+
+func TestCallGraph_WriteDOT(t *testing.T) {
+	g := testExportGraph()
+
+	var buf bytes.Buffer
+	if err := g.WriteDOT(&buf, resurgo.GraphOptions{Around: []uint64{fnD}}); err != nil {
+		t.Fatalf("WriteDOT: %v", err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"digraph callgraph {",
+		`"0x1400" [label="sub_1400", confidence="low", detection_type="jump-target"];`,
+		`"0x1100" -> "0x1400" [site="0x1120", type="jump", address_mode="pc-relative", style="dashed"];`,
+		`"0x1500" -> "0x1400" [site="0x1505", type="call", address_mode="pc-relative"];`,
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %q in:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, `"0x1000"`) {
+		t.Errorf("expected main outside the subgraph:\n%s", out)
+	}
+}
+
+func TestCallGraph_WriteGraphML(t *testing.T) {
+	g := testExportGraph()
+
+	var buf bytes.Buffer
+	if err := g.WriteGraphML(&buf, resurgo.GraphOptions{}); err != nil {
+		t.Fatalf("WriteGraphML: %v", err)
+	}
+	type data struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	}
+	var doc struct {
+		Keys []struct {
+			ID string `xml:"id,attr"`
+		} `xml:"key"`
+		Graph struct {
+			EdgeDefault string `xml:"edgedefault,attr"`
+			Nodes       []struct {
+				ID   string `xml:"id,attr"`
+				Data []data `xml:"data"`
+			} `xml:"node"`
+			Edges []struct {
+				Source string `xml:"source,attr"`
+				Target string `xml:"target,attr"`
+				Data   []data `xml:"data"`
+			} `xml:"edge"`
+		} `xml:"graph"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("decoding GraphML: %v", err)
+	}
+	if doc.Graph.EdgeDefault != "directed" || len(doc.Graph.Nodes) != 6 || len(doc.Graph.Edges) != 7 {
+		t.Fatalf("expected a directed graph of 6 nodes and 7 edges, got %s, %d and %d",
+			doc.Graph.EdgeDefault, len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+	if n := doc.Graph.Nodes[0]; n.ID != "0x1000" || !slices.Contains(n.Data, data{Key: "label", Value: "main"}) {
+		t.Errorf("node main: got %+v", n)
+	}
+	if e := doc.Graph.Edges[0]; e.Source != "0x1000" || e.Target != "0x1100" ||
+		!slices.Contains(e.Data, data{Key: "address_mode", Value: "pc-relative"}) {
+		t.Errorf("edge main → A: got %+v", e)
+	}
+}
@@ -0,0 +1,157 @@
+package resurgo
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// ImageSegment describes a segment of an Image: a section of an executable
+// file, a segment of a core dump, or a region of a firmware image.
+type ImageSegment struct {
+	Name string `json:"name,omitempty"`
+	Addr uint64 `json:"addr"`
+	Size uint64 `json:"size"`
+	// Perms are the access permissions of the segment, formatted like the
+	// permissions of /proc/<pid>/maps (e.g. "r-x").
+	Perms string `json:"perms"`
+}
+
+// Executable reports whether the segment holds code.
+func (s ImageSegment) Executable() bool {
+	return strings.Contains(s.Perms, "x")
+}
+
+// Contains reports whether addr lies within the segment.
+func (s ImageSegment) Contains(addr uint64) bool {
+	return addr >= s.Addr && addr < s.Addr+s.Size
+}
+
+// Image is a program image made of segments loaded at distinct addresses.
+// NewELFImage, NewPEImage, NewMachOImage, NewCoreImage and NewSegmentImage
+// return the images of the supported formats; other formats can be analyzed
+// by implementing Image.
+type Image interface {
+	// Arch returns the architecture of the code in the image.
+	Arch() Arch
+	// Segments returns the segments of the image.
+	Segments() []ImageSegment
+	// SegmentReader returns a reader over the contents of the i-th segment
+	// returned by Segments. When the reader has a Size method, like
+	// io.SectionReader, only that many bytes are analyzed: the tail of a
+	// segment may be missing from the file, such as zero-filled memory or
+	// code left out of a core dump.
+	SegmentReader(i int) (io.ReaderAt, error)
+}
+
+// DetectFunctionsFromImage detects function candidates across the
+// executable segments of img. Unlike DetectFunctionsFromSegments, segments
+// are not analyzed in isolation: call sites targeting any executable segment
+// contribute to the candidates, whichever segment they are in, so calls
+// between segments are resolved. Results are sorted by address.
+func DetectFunctionsFromImage(img Image) ([]FunctionCandidate, error) {
+	return DetectFunctionsFromImageContext(context.Background(), img, Options{})
+}
+
+// DetectFunctionsFromImageWithOptions is like DetectFunctionsFromImage but
+// applies the settings in opts. Options.Sections does not apply.
+func DetectFunctionsFromImageWithOptions(img Image, opts Options) ([]FunctionCandidate, error) {
+	return DetectFunctionsFromImageContext(context.Background(), img, opts)
+}
+
+// DetectFunctionsFromImageContext is like
+// DetectFunctionsFromImageWithOptions but stops with the context error as
+// soon as ctx is done, and reports progress to opts.Progress, labelled with
+// the segment name.
+func DetectFunctionsFromImageContext(ctx context.Context, img Image, opts Options) ([]FunctionCandidate, error) {
+	var sections []codeSection
+	for i, seg := range img.Segments() {
+		if !seg.Executable() {
+			continue
+		}
+
+		r, err := img.SegmentReader(i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read segment 0x%x: %w", seg.Addr, err)
+		}
+		size := seg.Size
+		if sized, ok := r.(interface{ Size() int64 }); ok {
+			size = min(size, uint64(sized.Size()))
+		}
+		if size == 0 {
+			opts.logger().Debug("skipping empty segment", "name", seg.Name, "addr", seg.Addr)
+			continue
+		}
+		src, err := mappingSource(r, int(size), opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read segment 0x%x: %w", seg.Addr, err)
+		}
+		sections = append(sections, codeSection{name: seg.Name, addr: seg.Addr, src: src})
+	}
+	if len(sections) == 0 {
+		return nil, fmt.Errorf("no executable segment found")
+	}
+
+	if nr, ok := img.(noReturner); ok && opts.uses(EvidenceNoReturn) {
+		var err error
+		if opts.noreturn, err = nr.noReturn(opts); err != nil {
+			return nil, err
+		}
+	}
+
+	candidates, err := detectFunctionsAcross(ctx, sections, img.Arch(), opts)
+	if err != nil {
+		return nil, err
+	}
+	return opts.filterCandidates(candidates), nil
+}
+
+// detectFunctionsAcross detects the evidence in each of sections and merges
+// it into function candidates, keeping the call sites that target any of the
+// sections, without applying the result filters of opts.
+func detectFunctionsAcross(ctx context.Context, sections []codeSection, arch Arch, opts Options) ([]FunctionCandidate, error) {
+	var ev evidence
+	for _, sec := range sections {
+		opts.logger().Debug("analyzing section",
+			"section", sec.name, "addr", sec.addr, "size", sec.src.size)
+
+		opts.section = sec.name
+		secEv, err := detectEvidence(ctx, sec.src, sec.addr, arch, opts)
+		if err != nil {
+			return nil, err
+		}
+		ev.append(secEv)
+	}
+
+	// Calls to non-returning functions outside the sections, such as PLT
+	// stubs, are only dropped after the inference.
+	var noreturn noReturnSet
+	if opts.uses(EvidenceNoReturn) {
+		var err error
+		if noreturn, err = refineNoReturn(ctx, sections, &ev, arch, opts); err != nil {
+			return nil, err
+		}
+	}
+	if err := classifyEvidence(ctx, sections, &ev, arch, noreturn, opts); err != nil {
+		return nil, err
+	}
+
+	ev.edges = slices.DeleteFunc(ev.edges, func(e CallSiteEdge) bool {
+		return e.Confidence == ConfidenceNone ||
+			!slices.ContainsFunc(sections, func(s codeSection) bool { return s.contains(e.TargetAddr) })
+	})
+	slices.SortStableFunc(ev.edges, func(a, b CallSiteEdge) int {
+		return cmp.Compare(a.SourceAddr, b.SourceAddr)
+	})
+
+	opts.section = ""
+	candidates, err := mergeEvidence(ctx, ev, 0, arch, opts)
+	if err != nil {
+		return nil, err
+	}
+	markNoReturn(candidates, noreturn)
+	return candidates, nil
+}
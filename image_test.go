@@ -0,0 +1,185 @@
+package resurgo_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+func TestDetectFunctionsFromImage_CrossSegment(t *testing.T) {
+	// Segment "boot" at 0x1000: call 0x2000; ret
+	// Segment "lib" at 0x2000: push rbp; mov rbp, rsp; ret
+	boot := []byte{0xe8, 0xfb, 0x0f, 0x00, 0x00, 0xc3}
+	lib := []byte{0x55, 0x48, 0x89, 0xe5, 0xc3}
+	segments := []resurgo.Segment{
+		{Name: "boot", Addr: 0x1000, Data: boot},
+		{Name: "lib", Addr: 0x2000, Data: lib},
+	}
+
+	// In isolation, the call from boot is lost.
+	isolated, err := resurgo.DetectFunctionsFromSegments(segments, resurgo.ArchAMD64)
+	if err != nil {
+		t.Fatalf("DetectFunctionsFromSegments: %v", err)
+	}
+	for _, c := range isolated[1].Functions {
+		if c.Address == 0x2000 && len(c.CalledFrom) > 0 {
+			t.Errorf("unexpected caller of 0x2000 in isolation: %v", c.CalledFrom)
+		}
+	}
+
+	candidates, err := resurgo.DetectFunctionsFromImage(resurgo.NewSegmentImage(segments, resurgo.ArchAMD64))
+	if err != nil {
+		t.Fatalf("DetectFunctionsFromImage: %v", err)
+	}
+	i := slices.IndexFunc(candidates, func(c resurgo.FunctionCandidate) bool { return c.Address == 0x2000 })
+	if i < 0 {
+		t.Fatalf("expected candidate at 0x2000, got %+v", candidates)
+	}
+	c := candidates[i]
+	if c.DetectionType != resurgo.DetectionBoth || c.Confidence != resurgo.ConfidenceHigh {
+		t.Errorf("expected high-confidence prologue and call target, got %s/%s", c.DetectionType, c.Confidence)
+	}
+	if !slices.Equal(c.CalledFrom, []uint64{0x1000}) {
+		t.Errorf("expected caller 0x1000, got %v", c.CalledFrom)
+	}
+}
+
+func TestNewELFImage(t *testing.T) {
+	f, err := os.Open(buildDemoApp(t, "amd64"))
+	if err != nil {
+		t.Fatalf("failed to open compiled binary: %v", err)
+	}
+	defer f.Close()
+
+	img, err := resurgo.NewELFImage(f)
+	if err != nil {
+		t.Fatalf("NewELFImage: %v", err)
+	}
+	if img.Arch() != resurgo.ArchAMD64 {
+		t.Errorf("expected %s, got %s", resurgo.ArchAMD64, img.Arch())
+	}
+	segments := img.Segments()
+	i := slices.IndexFunc(segments, func(s resurgo.ImageSegment) bool { return s.Name == ".text" })
+	if i < 0 || !segments[i].Executable() {
+		t.Fatalf("expected executable .text segment, got %+v", segments)
+	}
+	if j := slices.IndexFunc(segments, func(s resurgo.ImageSegment) bool { return s.Name == ".rodata" }); j < 0 || segments[j].Executable() {
+		t.Errorf("expected non-executable .rodata segment, got %+v", segments)
+	}
+
+	// The Go binary has no other executable section, so the image yields
+	// the candidates of .text.
+	want, err := resurgo.DetectFunctionsFromELF(f)
+	if err != nil {
+		t.Fatalf("DetectFunctionsFromELF: %v", err)
+	}
+	got, err := resurgo.DetectFunctionsFromImage(img)
+	if err != nil {
+		t.Fatalf("DetectFunctionsFromImage: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected %d candidates, got %d", len(want), len(got))
+	}
+
+	if _, err := resurgo.NewELFImage(bytes.NewReader(buildCore(nil))); err == nil {
+		t.Error("expected error for ELF core file")
+	}
+}
+
+func TestNewCoreImage(t *testing.T) {
+	code, base := buildSyntheticAMD64()
+	core := buildCore([]coreSegment{
+		{vaddr: base, memsz: uint64(len(code)), flags: elf.PF_R | elf.PF_X, data: code, path: "/usr/bin/daemon"},
+		{vaddr: 0x10000, memsz: 0x1000, flags: elf.PF_R | elf.PF_W, data: make([]byte, 0x10)},
+		{vaddr: 0x20000, memsz: 0x1000, flags: elf.PF_R | elf.PF_X, path: "/usr/lib/libc.so.6"},
+	})
+
+	img, err := resurgo.NewCoreImage(bytes.NewReader(core))
+	if err != nil {
+		t.Fatalf("NewCoreImage: %v", err)
+	}
+	want := []resurgo.ImageSegment{
+		{Name: "/usr/bin/daemon", Addr: base, Size: uint64(len(code)), Perms: "r-x"},
+		{Addr: 0x10000, Size: 0x1000, Perms: "rw-"},
+		{Name: "/usr/lib/libc.so.6", Addr: 0x20000, Size: 0x1000, Perms: "r-x"},
+	}
+	if got := img.Segments(); !slices.Equal(got, want) {
+		t.Errorf("unexpected segments:\ngot  %+v\nwant %+v", got, want)
+	}
+
+	// Text left out of the core is skipped.
+	wantCandidates, err := resurgo.DetectFunctions(code, base, resurgo.ArchAMD64)
+	if err != nil {
+		t.Fatalf("DetectFunctions: %v", err)
+	}
+	candidates, err := resurgo.DetectFunctionsFromImage(img)
+	if err != nil {
+		t.Fatalf("DetectFunctionsFromImage: %v", err)
+	}
+	if len(candidates) != len(wantCandidates) {
+		t.Errorf("expected %d candidates, got %d", len(wantCandidates), len(candidates))
+	}
+}
+
+func TestNewPEImage_NewMachOImage(t *testing.T) {
+	tests := []struct {
+		goos   string
+		goarch string
+		open   func(f *os.File) (resurgo.Image, error)
+		text   string
+	}{
+		{"windows", "amd64", func(f *os.File) (resurgo.Image, error) { return resurgo.NewPEImage(f) }, ".text"},
+		{"windows", "arm64", func(f *os.File) (resurgo.Image, error) { return resurgo.NewPEImage(f) }, ".text"},
+		{"darwin", "amd64", func(f *os.File) (resurgo.Image, error) { return resurgo.NewMachOImage(f) }, "__TEXT,__text"},
+		{"darwin", "arm64", func(f *os.File) (resurgo.Image, error) { return resurgo.NewMachOImage(f) }, "__TEXT,__text"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.goos+"/"+tt.goarch, func(t *testing.T) {
+			binPath := filepath.Join(t.TempDir(), demoAppBinary)
+			cmd := exec.Command("go", "build", "-o", binPath, demoAppSource)
+			cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS="+tt.goos, "GOARCH="+tt.goarch)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("failed to compile demo-app: %v\n%s", err, out)
+			}
+
+			f, err := os.Open(binPath)
+			if err != nil {
+				t.Fatalf("failed to open compiled binary: %v", err)
+			}
+			defer f.Close()
+
+			img, err := tt.open(f)
+			if err != nil {
+				t.Fatalf("failed to parse image: %v", err)
+			}
+			if string(img.Arch()) != tt.goarch {
+				t.Errorf("expected %s, got %s", tt.goarch, img.Arch())
+			}
+			var executable []string
+			for _, s := range img.Segments() {
+				if s.Executable() {
+					executable = append(executable, s.Name)
+				}
+			}
+			if !slices.Contains(executable, tt.text) {
+				t.Errorf("expected executable %s segment, got %v", tt.text, executable)
+			}
+
+			candidates, err := resurgo.DetectFunctionsFromImage(img)
+			if err != nil {
+				t.Fatalf("DetectFunctionsFromImage: %v", err)
+			}
+			// The Go runtime alone has hundreds of functions.
+			if len(candidates) < 100 {
+				t.Errorf("expected at least 100 candidates, got %d", len(candidates))
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package resurgo
+
+import (
+	"context"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// kernelSections lists the executable sections of a Linux kernel image
+// analyzed by DetectFunctionsFromKernel when Options.Sections is empty.
+// Sections missing from the image are skipped.
+var kernelSections = []string{".text", ".init.text", ".noinstr.text", ".entry.text"}
+
+// thunkKind classifies the x86-64 kernel symbols that are transparent to
+// detection.
+type thunkKind uint8
+
+const (
+	thunkNone thunkKind = iota
+	// thunkFentry is the ftrace entry hook called from function entries.
+	thunkFentry
+	// thunkReturn is a return thunk, jumped to in place of ret.
+	thunkReturn
+	// thunkIndirect is a retpoline thunk, called or jumped to in place of
+	// an indirect call or jump through a register.
+	thunkIndirect
+)
+
+// kernelThunkKind returns the kind of the kernel symbol name.
+func kernelThunkKind(name string) thunkKind {
+	switch {
+	case name == "__fentry__":
+		return thunkFentry
+	case name == "__x86_return_thunk",
+		strings.HasSuffix(name, "_return_thunk"):
+		return thunkReturn
+	case strings.HasPrefix(name, "__x86_indirect_thunk_"),
+		strings.HasPrefix(name, "__x86_indirect_call_thunk_"),
+		strings.HasPrefix(name, "__x86_indirect_jump_thunk_"),
+		strings.HasPrefix(name, "__x86_indirect_its_thunk_"):
+		return thunkIndirect
+	}
+	return thunkNone
+}
+
+// kernelThunks locates the transparent kernel symbols referenced by direct
+// calls and jumps. A non-nil value enables kernel mode in the x86-64
+// sweepers.
+type kernelThunks struct {
+	// targets maps the addresses of the symbols, when known from the
+	// symbol table of a kernel image.
+	targets map[uint64]thunkKind
+	// sites maps the offsets of the relocated branch fields referencing
+	// the symbols, in a section of a kernel module.
+	sites map[uint64]thunkKind
+}
+
+// kind returns the kind of symbol targeted by the direct call or jump inst
+// at addr, or thunkNone.
+func (k *kernelThunks) kind(inst x86asm.Inst, addr uint64) thunkKind {
+	if inst.Op != x86asm.CALL && inst.Op != x86asm.JMP {
+		return thunkNone
+	}
+	rel, ok := inst.Args[0].(x86asm.Rel)
+	if !ok {
+		return thunkNone
+	}
+	if kind, ok := k.sites[addr+uint64(inst.Len)-4]; ok && inst.Len >= 5 {
+		return kind
+	}
+	return k.targets[addr+uint64(inst.Len)+uint64(int64(rel))]
+}
+
+// isFentrySiteAMD64 reports whether inst at addr is an ftrace patch site:
+// a call to __fentry__, or the 5-byte NOP it is replaced with at build time.
+func isFentrySiteAMD64(inst x86asm.Inst, addr uint64, k *kernelThunks) bool {
+	if inst.Op == x86asm.NOP && inst.Len == 5 {
+		return true
+	}
+	return inst.Op == x86asm.CALL && k.kind(inst, addr) == thunkFentry
+}
+
+// DetectFunctionsFromKernel parses a Linux kernel image (vmlinux) from r and
+// detects function candidates across its executable sections: .text,
+// .init.text, .noinstr.text and .entry.text, when present. Call sites
+// targeting any of these sections contribute to the candidates, whichever
+// section they are in.
+//
+// On x86-64, kernel code conventions are taken into account: the ftrace
+// patch site at function entry (call __fentry__, or the 5-byte NOP it is
+// replaced with) and ENDBR64 are skipped and the prologue is reported at the
+// function entry; jmp __x86_return_thunk is treated as ret; calls and jumps
+// through retpoline thunks (__x86_indirect_thunk_*) are reported as
+// register-indirect. The thunks are located through the symbol table, when
+// present; stripped images only get the NOP handling.
+func DetectFunctionsFromKernel(r io.ReaderAt) ([]FunctionCandidate, error) {
+	return DetectFunctionsFromKernelContext(context.Background(), r, Options{})
+}
+
+// DetectFunctionsFromKernelWithOptions is like DetectFunctionsFromKernel but
+// applies the settings in opts. When opts lists sections, exactly those are
+// analyzed.
+func DetectFunctionsFromKernelWithOptions(r io.ReaderAt, opts Options) ([]FunctionCandidate, error) {
+	return DetectFunctionsFromKernelContext(context.Background(), r, opts)
+}
+
+// DetectFunctionsFromKernelContext is like
+// DetectFunctionsFromKernelWithOptions but stops with the context error as
+// soon as ctx is done, and reports progress to opts.Progress.
+func DetectFunctionsFromKernelContext(ctx context.Context, r io.ReaderAt, opts Options) ([]FunctionCandidate, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ELF file: %w", err)
+	}
+	defer f.Close()
+
+	arch, err := elfArch(f.Machine)
+	if err != nil {
+		return nil, err
+	}
+
+	names := opts.Sections
+	if len(names) == 0 {
+		for _, name := range kernelSections {
+			if f.Section(name) != nil {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no kernel text section found")
+	}
+
+	syms, err := f.Symbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, fmt.Errorf("failed to read symbols: %w", err)
+	}
+	thunks := &kernelThunks{targets: make(map[uint64]thunkKind)}
+	for _, sym := range syms {
+		if kind := kernelThunkKind(sym.Name); kind != thunkNone && sym.Section != elf.SHN_UNDEF {
+			thunks.targets[sym.Value] = kind
+		}
+	}
+	opts.kernel = thunks
+	if opts.uses(EvidenceNoReturn) {
+		if opts.noreturn, err = elfNoReturn(f, arch, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	var sections []codeSection
+	for _, name := range names {
+		sec := f.Section(name)
+		if sec == nil {
+			return nil, fmt.Errorf("no %s section found", name)
+		}
+		src, err := sectionSource(sec, opts)
+		if err != nil {
+			return nil, err
+		}
+		sections = append(sections, codeSection{name: name, addr: sec.Addr, src: src})
+	}
+	opts.logger().Debug("analyzing kernel sections",
+		"sections", names, "thunks", len(thunks.targets))
+
+	candidates, err := detectFunctionsAcross(ctx, sections, arch, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return opts.filterCandidates(candidates), nil
+}
+
+// DetectFunctionsFromKernelModule parses a Linux kernel module (.ko) from r
+// and detects function candidates in each of its executable sections like
+// DetectFunctionsFromObject, applying the module relocations. On x86-64,
+// kernel code conventions are taken into account as in
+// DetectFunctionsFromKernel, with __fentry__ and the thunks located through
+// the relocations that reference them.
+func DetectFunctionsFromKernelModule(r io.ReaderAt) ([]ObjectFunctions, error) {
+	return DetectFunctionsFromKernelModuleContext(context.Background(), r, Options{})
+}
+
+// DetectFunctionsFromKernelModuleWithOptions is like
+// DetectFunctionsFromKernelModule but applies the settings in opts. When
+// opts lists sections, only those are analyzed.
+func DetectFunctionsFromKernelModuleWithOptions(r io.ReaderAt, opts Options) ([]ObjectFunctions, error) {
+	return DetectFunctionsFromKernelModuleContext(context.Background(), r, opts)
+}
+
+// DetectFunctionsFromKernelModuleContext is like
+// DetectFunctionsFromKernelModuleWithOptions but stops with the context
+// error as soon as ctx is done, and reports progress to opts.Progress.
+func DetectFunctionsFromKernelModuleContext(ctx context.Context, r io.ReaderAt, opts Options) ([]ObjectFunctions, error) {
+	opts.kernel = &kernelThunks{}
+	return detectObjectFunctions(ctx, r, "", opts)
+}
+
+// moduleThunks returns the transparent kernel symbols referenced by the
+// branch relocations of a module section.
+func moduleThunks(relocs map[uint64]objectReloc) *kernelThunks {
+	thunks := &kernelThunks{sites: make(map[uint64]thunkKind)}
+	for off, rel := range relocs {
+		if kind := kernelThunkKind(rel.symbol); kind != thunkNone {
+			thunks.sites[off] = kind
+		}
+	}
+	return thunks
+}
@@ -0,0 +1,166 @@
+package resurgo_test
+
+import (
+	"debug/elf"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+const kernelSource = "testdata/kernel.c"
+
+// kernelFlags are the code generation options of the Linux kernel on x86-64
+// that affect function entries and exits.
+var kernelFlags = []string{
+	"-O2", "-fno-pic", "-fno-omit-frame-pointer", "-pg", "-mfentry",
+	"-mfunction-return=thunk-extern", "-mindirect-branch=thunk-extern",
+	"-fcf-protection=branch",
+}
+
+// compileKernel builds the kernel-style test source with gcc and the extra
+// args, and returns the path of the output.
+func compileKernel(t *testing.T, name string, args ...string) string {
+	t.Helper()
+	if runtime.GOARCH != "amd64" {
+		t.Skip("kernel code generation options are x86-64 specific")
+	}
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found, skipping")
+	}
+
+	outPath := filepath.Join(t.TempDir(), name)
+	cmd := exec.Command("gcc", append(append(kernelFlags, args...), "-o", outPath, kernelSource)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile %s: %v\n%s", kernelSource, err, out)
+	}
+	return outPath
+}
+
+func TestDetectFunctionsFromKernel(t *testing.T) {
+	// The image has the ftrace call sites replaced with 5-byte NOPs, as
+	// in vmlinux.
+	binPath := compileKernel(t, "vmlinux", "-mnop-mcount", "-DKERNEL_IMAGE",
+		"-nostdlib", "-static", "-no-pie", "-Wl,-e,_start")
+
+	ef, err := elf.Open(binPath)
+	if err != nil {
+		t.Fatalf("failed to open image: %v", err)
+	}
+	syms, err := ef.Symbols()
+	ef.Close()
+	if err != nil {
+		t.Fatalf("failed to read symbols: %v", err)
+	}
+	addrs := make(map[string]uint64)
+	for _, s := range syms {
+		addrs[s.Name] = s.Value
+	}
+
+	f, err := os.Open(binPath)
+	if err != nil {
+		t.Fatalf("failed to open image: %v", err)
+	}
+	defer f.Close()
+
+	candidates, err := resurgo.DetectFunctionsFromKernel(f)
+	if err != nil {
+		t.Fatalf("DetectFunctionsFromKernel: %v", err)
+	}
+	byAddr := make(map[uint64]resurgo.FunctionCandidate)
+	for _, c := range candidates {
+		byAddr[c.Address] = c
+	}
+
+	// walk has a classic prologue behind ENDBR64 and the ftrace NOP, and is
+	// called from .init.text: both are reported at its entry.
+	if c := byAddr[addrs["walk"]]; c.DetectionType != resurgo.DetectionBoth || c.PrologueType != resurgo.PrologueClassic {
+		t.Errorf("walk: expected classic prologue and call target at entry, got %+v", c)
+	}
+	// step and dispatch are called across sections.
+	for _, name := range []string{"step", "dispatch"} {
+		if c, ok := byAddr[addrs[name]]; !ok || len(c.CalledFrom) == 0 {
+			t.Errorf("%s: expected call target at 0x%x, got %+v", name, addrs[name], c)
+		}
+	}
+	// Jumps to the return thunk stand for ret, and calls through the
+	// retpoline thunk are indirect.
+	for _, name := range []string{"__x86_return_thunk", "__x86_indirect_thunk_rax"} {
+		if c, ok := byAddr[addrs[name]]; ok {
+			t.Errorf("%s: unexpected candidate %+v", name, c)
+		}
+	}
+	// No prologue is reported past the transparent entry instructions.
+	for _, name := range []string{"step", "walk", "dispatch"} {
+		if c, ok := byAddr[addrs[name]+9]; ok {
+			t.Errorf("%s: unexpected candidate inside the entry sequence: %+v", name, c)
+		}
+	}
+}
+
+func TestDetectFunctionsFromKernelModule(t *testing.T) {
+	objPath := compileKernel(t, "module.ko", "-c")
+
+	f, err := os.Open(objPath)
+	if err != nil {
+		t.Fatalf("failed to open module: %v", err)
+	}
+	defer f.Close()
+
+	results, err := resurgo.DetectFunctionsFromKernelModule(f)
+	if err != nil {
+		t.Fatalf("DetectFunctionsFromKernelModule: %v", err)
+	}
+
+	sections := make(map[string]resurgo.ObjectFunctions)
+	for _, r := range results {
+		sections[r.Section] = r
+	}
+	text, ok := sections[".text"]
+	if !ok {
+		t.Fatal("expected a .text section")
+	}
+	init, ok := sections[".init.text"]
+	if !ok {
+		t.Fatal("expected a .init.text section")
+	}
+
+	// walk follows step at the next 16-byte boundary; its classic prologue
+	// is reported at its entry, behind ENDBR64 and call __fentry__.
+	var walk bool
+	for _, c := range text.Functions {
+		if c.PrologueType == resurgo.PrologueClassic && c.Address%16 == 0 && c.Address > 0 {
+			walk = true
+		}
+	}
+	if !walk {
+		t.Errorf("expected a classic prologue at an aligned entry, got %+v", text.Functions)
+	}
+
+	var indirect bool
+	for _, r := range []resurgo.ObjectFunctions{text, init} {
+		for _, e := range r.CallSites {
+			switch e.TargetSymbol {
+			case "__fentry__", "__x86_return_thunk", "__x86_indirect_thunk_rax":
+				t.Errorf("%s: unexpected call site to %s at 0x%x", r.Section, e.TargetSymbol, e.SourceAddr)
+			}
+			if e.AddressMode == resurgo.AddressingModeRegisterIndirect {
+				indirect = true
+			}
+		}
+	}
+	if !indirect {
+		t.Error("expected the retpoline call in dispatch to be register-indirect")
+	}
+
+	targets := make(map[string]bool)
+	for _, e := range init.CallSites {
+		targets[e.TargetSymbol] = true
+	}
+	if !targets["walk"] || !targets["dispatch"] {
+		t.Errorf("expected calls from .init.text to walk and dispatch, got %v", targets)
+	}
+}
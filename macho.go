@@ -0,0 +1,95 @@
+package resurgo
+
+import (
+	"bytes"
+	"debug/macho"
+	"fmt"
+	"io"
+)
+
+// Mach-O section flags.
+const (
+	machoSectionType          = 0x000000ff
+	machoZerofill             = 0x01
+	machoGBZerofill           = 0x0c
+	machoThreadLocalZerofill  = 0x12
+	machoAttrPureInstructions = 0x80000000
+	machoAttrSomeInstructions = 0x00000400
+)
+
+// machoImage is the Image of a Mach-O executable or dynamic library, made
+// of its sections.
+type machoImage struct {
+	arch     Arch
+	sections []*macho.Section
+	segments []ImageSegment
+}
+
+// machoArch maps a Mach-O CPU type to the corresponding architecture.
+func machoArch(cpu macho.Cpu) (Arch, error) {
+	switch cpu {
+	case macho.CpuAmd64:
+		return ArchAMD64, nil
+	case macho.CpuArm64:
+		return ArchARM64, nil
+	default:
+		return "", fmt.Errorf("unsupported Mach-O CPU: %s", cpu)
+	}
+}
+
+// NewMachOImage parses a thin Mach-O executable or dynamic library from r
+// and returns its Image, with a segment for each section. Sections holding
+// instructions (S_ATTR_PURE_INSTRUCTIONS or S_ATTR_SOME_INSTRUCTIONS) in an
+// executable segment are executable.
+func NewMachOImage(r io.ReaderAt) (Image, error) {
+	f, err := macho.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Mach-O file: %w", err)
+	}
+
+	arch, err := machoArch(f.Cpu)
+	if err != nil {
+		return nil, err
+	}
+
+	img := &machoImage{arch: arch}
+	for _, sec := range f.Sections {
+		var prot uint32
+		if seg := f.Segment(sec.Seg); seg != nil {
+			prot = seg.Prot
+		}
+		perms := []byte("---")
+		if prot&0x1 != 0 {
+			perms[0] = 'r'
+		}
+		if prot&0x2 != 0 {
+			perms[1] = 'w'
+		}
+		if prot&0x4 != 0 && sec.Flags&(machoAttrPureInstructions|machoAttrSomeInstructions) != 0 {
+			perms[2] = 'x'
+		}
+		img.sections = append(img.sections, sec)
+		img.segments = append(img.segments, ImageSegment{
+			Name:  sec.Seg + "," + sec.Name,
+			Addr:  sec.Addr,
+			Size:  sec.Size,
+			Perms: string(perms),
+		})
+	}
+
+	return img, nil
+}
+
+func (img *machoImage) Arch() Arch { return img.arch }
+
+func (img *machoImage) Segments() []ImageSegment { return img.segments }
+
+func (img *machoImage) SegmentReader(i int) (io.ReaderAt, error) {
+	sec := img.sections[i]
+	switch sec.Flags & machoSectionType {
+	case machoZerofill, machoGBZerofill, machoThreadLocalZerofill:
+		// Zero-filled sections have no contents in the file.
+		return bytes.NewReader(nil), nil
+	}
+	return io.NewSectionReader(sec, 0, int64(sec.Size)), nil
+}
@@ -0,0 +1,71 @@
+package resurgo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Mapping describes an executable memory region of a process or core dump.
+type Mapping struct {
+	Start  uint64 `json:"start"`
+	End    uint64 `json:"end"`
+	Offset uint64 `json:"offset"`
+	Perms  string `json:"perms"`
+	// Path is the backing file of the mapping, a pseudo-path such as
+	// [vdso], or empty for anonymous memory (e.g. JIT-compiled code).
+	Path string `json:"path,omitempty"`
+}
+
+// Anonymous reports whether the mapping has no backing file.
+func (m Mapping) Anonymous() bool {
+	return m.Path == "" || strings.HasPrefix(m.Path, "[")
+}
+
+// label returns the name used to report progress on the mapping.
+func (m Mapping) label() string {
+	if m.Path == "" {
+		return "[anon]"
+	}
+	return m.Path
+}
+
+// MappingFunctions holds the function candidates detected in a mapping.
+type MappingFunctions struct {
+	Mapping   Mapping             `json:"mapping"`
+	Functions []FunctionCandidate `json:"functions"`
+}
+
+// mappingSource returns a source for the size bytes of a mapping read from
+// r. The bytes are loaded in memory, unless opts sets a window size.
+func mappingSource(r io.ReaderAt, size int, opts Options) (source, error) {
+	if window := opts.windowSize(); window > 0 {
+		return source{r: r, size: size, window: window}, nil
+	}
+	code := make([]byte, size)
+	if _, err := r.ReadAt(code, 0); err != nil && err != io.EOF {
+		return source{}, err
+	}
+	return memSource(code), nil
+}
+
+// detectMappingFunctions detects function candidates in the code of mapping
+// m read from src. Call targets outside the mapping are dropped: they are
+// attributed to their own mapping when it is analyzed.
+func detectMappingFunctions(ctx context.Context, src source, m Mapping, arch Arch, opts Options) (MappingFunctions, error) {
+	opts.logger().Debug("analyzing mapping",
+		"path", m.Path, "start", m.Start, "size", src.size)
+
+	opts.section = m.label()
+	candidates, err := detectFunctions(ctx, src, m.Start, arch, opts)
+	if err != nil {
+		return MappingFunctions{}, fmt.Errorf("failed to analyze mapping 0x%x-0x%x: %w", m.Start, m.End, err)
+	}
+	candidates = slices.DeleteFunc(candidates, func(c FunctionCandidate) bool {
+		return c.Address < m.Start || c.Address >= m.End
+	})
+
+	return MappingFunctions{Mapping: m, Functions: opts.filterCandidates(candidates)}, nil
+}
@@ -0,0 +1,115 @@
+package resurgo
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ulikunitz/xz"
+)
+
+// miniDebugInfoSection is the section holding MiniDebugInfo: an
+// xz-compressed ELF file whose symbol table lists the functions that are
+// not exported through .dynsym.
+const miniDebugInfoSection = ".gnu_debugdata"
+
+// MiniDebugInfo is decompressed up to maxMiniDebugInfoRatio times the size
+// of its section, and at most maxMiniDebugInfoSize bytes, so that a small
+// crafted xz stream cannot exhaust memory. Symbol tables compress far less.
+const (
+	maxMiniDebugInfoRatio = 64
+	maxMiniDebugInfoSize  = 256 << 20
+)
+
+// readMiniDebugInfo returns the function symbols of the MiniDebugInfo of the
+// ELF binary read from r, or nil if it has none.
+func readMiniDebugInfo(r io.ReaderAt) ([]elf.Symbol, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ELF file: %w", err)
+	}
+	defer f.Close()
+
+	sec := f.Section(miniDebugInfoSection)
+	if sec == nil {
+		return nil, nil
+	}
+
+	xr, err := xz.NewReader(sec.Open())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s section: %w", miniDebugInfoSection, err)
+	}
+	limit := int64(min(sec.Size, maxMiniDebugInfoSize/maxMiniDebugInfoRatio) * maxMiniDebugInfoRatio)
+	data, err := io.ReadAll(io.LimitReader(xr, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s section: %w", miniDebugInfoSection, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s section decompresses to more than %d bytes", miniDebugInfoSection, limit)
+	}
+
+	mini, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s ELF file: %w", miniDebugInfoSection, err)
+	}
+	defer mini.Close()
+
+	syms, err := mini.Symbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, fmt.Errorf("failed to read %s symbols: %w", miniDebugInfoSection, err)
+	}
+
+	var funcs []elf.Symbol
+	for _, s := range syms {
+		if elf.ST_TYPE(s.Info) == elf.STT_FUNC && s.Section != elf.SHN_UNDEF && s.Value != 0 {
+			funcs = append(funcs, s)
+		}
+	}
+	return funcs, nil
+}
+
+// mergeSymbols merges function symbols lying within sections into
+// candidates. A symbol names and sizes the candidate at its address and
+// raises it to high confidence; symbols without a candidate are added as
+// symbol-only candidates. The result is not sorted.
+func mergeSymbols(candidates []FunctionCandidate, syms []elf.Symbol, sections []codeSection) []FunctionCandidate {
+	index := make(map[uint64]int, len(candidates))
+	for i, c := range candidates {
+		index[c.Address] = i
+	}
+
+	for _, s := range syms {
+		inSection := false
+		for _, sec := range sections {
+			if sec.contains(s.Value) {
+				inSection = true
+				break
+			}
+		}
+		if !inSection {
+			continue
+		}
+
+		if i, ok := index[s.Value]; ok {
+			if candidates[i].Name == "" {
+				candidates[i].Name = s.Name
+				candidates[i].Size = s.Size
+			}
+			candidates[i].Confidence = ConfidenceHigh
+			continue
+		}
+
+		index[s.Value] = len(candidates)
+		candidates = append(candidates, FunctionCandidate{
+			Address:       s.Value,
+			DetectionType: DetectionSymbol,
+			Name:          s.Name,
+			Size:          s.Size,
+			Confidence:    ConfidenceHigh,
+		})
+	}
+
+	return candidates
+}
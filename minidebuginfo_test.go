@@ -0,0 +1,282 @@
+package resurgo_test
+
+import (
+	"bytes"
+	"cmp"
+	"compress/zlib"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+
+	"github.com/maxgio92/resurgo"
+)
+
+// buildCompressedELF returns an x86-64 ELF executable whose .text section
+// holds code at addr, compressed with zlib (SHF_COMPRESSED). The gABI only
+// allows compressing sections that are not allocated, so the section lacks
+// SHF_ALLOC.
+func buildCompressedELF(code []byte, addr uint64) []byte {
+	le := binary.LittleEndian
+
+	var text bytes.Buffer
+	binary.Write(&text, le, elf.Chdr64{Type: uint32(elf.COMPRESS_ZLIB), Size: uint64(len(code)), Addralign: 16})
+	zw := zlib.NewWriter(&text)
+	zw.Write(code)
+	zw.Close()
+
+	shstrtab := []byte("\x00.text\x00.shstrtab\x00")
+
+	const ehsize, shentsize = 64, 64
+	textOff := uint64(ehsize)
+	strOff := textOff + uint64(text.Len())
+	shOff := strOff + uint64(len(shstrtab))
+
+	var buf bytes.Buffer
+	hdr := elf.Header64{
+		Type: uint16(elf.ET_EXEC), Machine: uint16(elf.EM_X86_64), Version: 1,
+		Entry: addr, Shoff: shOff, Ehsize: ehsize, Shentsize: shentsize, Shnum: 3, Shstrndx: 2,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	binary.Write(&buf, le, hdr)
+	buf.Write(text.Bytes())
+	buf.Write(shstrtab)
+
+	binary.Write(&buf, le, elf.Section64{})
+	binary.Write(&buf, le, elf.Section64{
+		Name: 1, Type: uint32(elf.SHT_PROGBITS),
+		Flags: uint64(elf.SHF_EXECINSTR | elf.SHF_COMPRESSED),
+		Addr:  addr, Off: textOff, Size: uint64(text.Len()), Addralign: 16,
+	})
+	binary.Write(&buf, le, elf.Section64{
+		Name: 7, Type: uint32(elf.SHT_STRTAB), Off: strOff, Size: uint64(len(shstrtab)), Addralign: 1,
+	})
+	return buf.Bytes()
+}
+
+func TestDetectFromELF_CompressedSection(t *testing.T) {
+	code, base := buildSyntheticAMD64()
+	want, err := resurgo.DetectFunctions(code, base, resurgo.ArchAMD64)
+	if err != nil {
+		t.Fatalf("DetectFunctions: %v", err)
+	}
+
+	bin := buildCompressedELF(code, base)
+	for _, opts := range []resurgo.Options{{}, {WindowSize: 64}} {
+		got, err := resurgo.DetectFunctionsFromELFWithOptions(bytes.NewReader(bin), opts)
+		if err != nil {
+			t.Fatalf("DetectFunctionsFromELFWithOptions(%+v): %v", opts, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("expected %d candidates, got %d", len(want), len(got))
+		}
+		for i := range want {
+			if got[i].Address != want[i].Address || got[i].DetectionType != want[i].DetectionType {
+				t.Errorf("candidate %d: expected %+v, got %+v", i, want[i], got[i])
+			}
+		}
+	}
+}
+
+// addMiniDebugInfo strips the binary at path and embeds an xz-compressed
+// ELF file holding the symbols of the given functions as .gnu_debugdata,
+// the way Fedora builds MiniDebugInfo.
+func addMiniDebugInfo(t *testing.T, path string, funcs []string) string {
+	t.Helper()
+	if _, err := exec.LookPath("objcopy"); err != nil {
+		t.Skip("objcopy not found, skipping")
+	}
+	dir := t.TempDir()
+
+	run := func(args ...string) {
+		t.Helper()
+		if out, err := exec.Command("objcopy", args...).CombinedOutput(); err != nil {
+			t.Fatalf("objcopy %v: %v\n%s", args, err, out)
+		}
+	}
+
+	debug := filepath.Join(dir, "debug")
+	run("--only-keep-debug", path, debug)
+	mini := filepath.Join(dir, "mini")
+	args := []string{"-S", "--remove-section", ".comment"}
+	for _, name := range funcs {
+		args = append(args, "--keep-symbol", name)
+	}
+	run(append(args, debug, mini)...)
+
+	data, err := os.ReadFile(mini)
+	if err != nil {
+		t.Fatalf("failed to read MiniDebugInfo: %v", err)
+	}
+	var compressed bytes.Buffer
+	xw, err := xz.NewWriter(&compressed)
+	if err != nil {
+		t.Fatalf("failed to create xz writer: %v", err)
+	}
+	xw.Write(data)
+	xw.Close()
+	xzPath := filepath.Join(dir, "mini.xz")
+	if err := os.WriteFile(xzPath, compressed.Bytes(), 0o644); err != nil {
+		t.Fatalf("failed to write MiniDebugInfo: %v", err)
+	}
+
+	stripped := filepath.Join(dir, "stripped")
+	run("--strip-all", "--add-section", ".gnu_debugdata="+xzPath, path, stripped)
+	return stripped
+}
+
+func TestDetectFunctionsFromELF_MiniDebugInfo(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found, skipping")
+	}
+	binPath := filepath.Join(t.TempDir(), "demo-app-c")
+	if out, err := exec.Command("gcc", "-O2", "-o", binPath, demoAppCSource).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile %s: %v\n%s", demoAppCSource, err, out)
+	}
+
+	ef, err := elf.Open(binPath)
+	if err != nil {
+		t.Fatalf("failed to open binary: %v", err)
+	}
+	syms, err := ef.Symbols()
+	ef.Close()
+	if err != nil {
+		t.Fatalf("failed to read symbols: %v", err)
+	}
+	// frame_dummy is never called directly nor has a recognized prologue:
+	// only its symbol and the padding before it reveal it.
+	funcs := []string{"add", "multiply", "observe", "frame_dummy"}
+	addrs := make(map[string]uint64)
+	for _, s := range syms {
+		if slices.Contains(funcs, s.Name) && elf.ST_TYPE(s.Info) == elf.STT_FUNC {
+			addrs[s.Name] = s.Value
+		}
+	}
+
+	f, err := os.Open(addMiniDebugInfo(t, binPath, funcs))
+	if err != nil {
+		t.Fatalf("failed to open stripped binary: %v", err)
+	}
+	defer f.Close()
+
+	candidates, err := resurgo.DetectFunctionsFromELF(f)
+	if err != nil {
+		t.Fatalf("DetectFunctionsFromELF: %v", err)
+	}
+	if !slices.IsSortedFunc(candidates, func(a, b resurgo.FunctionCandidate) int {
+		return cmp.Compare(a.Address, b.Address)
+	}) {
+		t.Error("expected candidates sorted by address")
+	}
+
+	byName := make(map[string]resurgo.FunctionCandidate)
+	for _, c := range candidates {
+		if c.Name != "" {
+			byName[c.Name] = c
+		}
+	}
+	for _, name := range funcs {
+		c, ok := byName[name]
+		if !ok {
+			t.Errorf("%s: expected a named candidate", name)
+			continue
+		}
+		if c.Address != addrs[name] || c.Confidence != resurgo.ConfidenceHigh {
+			t.Errorf("%s: expected high confidence at 0x%x, got %+v", name, addrs[name], c)
+		}
+	}
+	if c := byName["frame_dummy"]; c.DetectionType != resurgo.DetectionSymbol && c.DetectionType != resurgo.DetectionPadding {
+		t.Errorf("frame_dummy: expected symbol or padding candidate, got %+v", c)
+	}
+	if c := byName["add"]; len(c.CalledFrom) == 0 {
+		t.Errorf("add: expected symbol merged with call site evidence, got %+v", c)
+	}
+
+	without, err := resurgo.DetectFunctionsFromELFWithOptions(f, resurgo.Options{
+		Sources: []resurgo.EvidenceSource{resurgo.EvidencePrologue, resurgo.EvidenceCallSite},
+	})
+	if err != nil {
+		t.Fatalf("DetectFunctionsFromELFWithOptions: %v", err)
+	}
+	for _, c := range without {
+		if c.Name != "" || c.DetectionType == resurgo.DetectionSymbol {
+			t.Errorf("unexpected symbol evidence with EvidenceSymbol disabled: %+v", c)
+		}
+	}
+}
+
+func TestDetectFunctionsFromELF_CorruptMiniDebugInfo(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found, skipping")
+	}
+	if _, err := exec.LookPath("objcopy"); err != nil {
+		t.Skip("objcopy not found, skipping")
+	}
+	dir := t.TempDir()
+	binPath := filepath.Join(dir, "demo-app-c")
+	if out, err := exec.Command("gcc", "-O2", "-o", binPath, demoAppCSource).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile %s: %v\n%s", demoAppCSource, err, out)
+	}
+
+	// A few hundred bytes of xz expanding to 16 MiB of zeros.
+	var bomb bytes.Buffer
+	xw, err := xz.NewWriter(&bomb)
+	if err != nil {
+		t.Fatalf("failed to create xz writer: %v", err)
+	}
+	xw.Write(make([]byte, 16<<20))
+	xw.Close()
+
+	tests := []struct {
+		name    string
+		section []byte
+		log     string
+	}{
+		{"not xz", []byte("not an xz stream"), "failed to decompress"},
+		{"decompression bomb", bomb.Bytes(), "decompresses to more than"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section := filepath.Join(dir, fmt.Sprintf("mini%d.xz", i))
+			if err := os.WriteFile(section, tt.section, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			stripped := filepath.Join(dir, fmt.Sprintf("stripped%d", i))
+			args := []string{"--strip-all", "--add-section", ".gnu_debugdata=" + section, binPath, stripped}
+			if out, err := exec.Command("objcopy", args...).CombinedOutput(); err != nil {
+				t.Fatalf("objcopy %v: %v\n%s", args, err, out)
+			}
+
+			f, err := os.Open(stripped)
+			if err != nil {
+				t.Fatalf("failed to open stripped binary: %v", err)
+			}
+			defer f.Close()
+
+			var log bytes.Buffer
+			candidates, err := resurgo.DetectFunctionsFromELFWithOptions(f, resurgo.Options{
+				Logger: slog.New(slog.NewTextHandler(&log, nil)),
+			})
+			if err != nil {
+				t.Fatalf("DetectFunctionsFromELFWithOptions: %v", err)
+			}
+			if len(candidates) == 0 {
+				t.Error("expected the candidates found in the code")
+			}
+			if !strings.Contains(log.String(), tt.log) {
+				t.Errorf("expected %q to be logged, got %q", tt.log, log.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,648 @@
+package resurgo
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// noReturnNames lists the functions known never to return to their caller.
+var noReturnNames = []string{
+	// C library
+	"abort", "exit", "_exit", "_Exit", "quick_exit",
+	"__stack_chk_fail", "__stack_chk_fail_local",
+	"__assert_fail", "__assert_perror_fail", "__assert_rtn",
+	"__fortify_fail", "__chk_fail",
+	"longjmp", "_longjmp", "siglongjmp", "__longjmp_chk",
+	"pthread_exit", "err", "errx", "verr", "verrx",
+	"__libc_start_main",
+	// C++ runtime
+	"__cxa_throw", "__cxa_rethrow", "__cxa_bad_cast", "__cxa_bad_typeid",
+	"__cxa_pure_virtual", "__cxa_throw_bad_array_new_length",
+	"_Unwind_Resume", "_ZSt9terminatev",
+	// Go runtime
+	"runtime.throw", "runtime.fatal", "runtime.fatalthrow",
+	"runtime.fatalpanic", "runtime.gopanic", "runtime.goexit0",
+	// Linux kernel
+	"panic", "do_exit", "make_task_dead", "kthread_exit",
+}
+
+// noReturnPrefixes lists the name prefixes of functions known never to
+// return to their caller.
+var noReturnPrefixes = []string{
+	"_ZSt19__throw_", "_ZSt20__throw_", "_ZSt21__throw_", "_ZSt24__throw_",
+	"_ZSt16__throw_", "_ZSt17__throw_", "_ZSt18__throw_", "_ZSt25__throw_",
+	"runtime.panic", "runtime.goPanic",
+}
+
+// isNoReturnName reports whether the function called name is known never to
+// return.
+func isNoReturnName(name string) bool {
+	if slices.Contains(noReturnNames, name) {
+		return true
+	}
+	for _, prefix := range noReturnPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// noReturnSet holds the addresses that calls never return from: the entries
+// of non-returning functions, their PLT stubs and their GOT slots, which
+// call [rip+disp] references.
+type noReturnSet map[uint64]bool
+
+// callAMD64 reports whether inst at addr is a call that never returns.
+func (n noReturnSet) callAMD64(inst x86asm.Inst, addr uint64) bool {
+	if len(n) == 0 || inst.Op != x86asm.CALL {
+		return false
+	}
+	edge := extractTargetAMD64(inst, addr, CallSiteCall, ConfidenceHigh)
+	return edge != nil && edge.Confidence != ConfidenceNone && n[edge.TargetAddr]
+}
+
+// callARM64 reports whether the instruction word w at addr is a BL that
+// never returns.
+func (n noReturnSet) callARM64(w uint32, addr uint64) bool {
+	if len(n) == 0 || w&0xfc000000 != 0x94000000 {
+		return false
+	}
+	return n[addr+uint64(int64(int32(w<<6)>>6)*4)]
+}
+
+// flowKind classifies how an instruction transfers control.
+type flowKind uint8
+
+const (
+	// flowNext falls through to the next instruction.
+	flowNext flowKind = iota
+	// flowReturn returns to the caller, or transfers control to an unknown
+	// address, which is conservatively taken as returning.
+	flowReturn
+	// flowJump jumps to target.
+	flowJump
+	// flowBranch jumps to target or falls through.
+	flowBranch
+	// flowCall calls target, if known, and falls through unless the callee
+	// never returns.
+	flowCall
+	// flowStop traps: execution does not continue.
+	flowStop
+)
+
+// flow describes the control transfer of a decoded instruction of n bytes.
+type flow struct {
+	n         int
+	kind      flowKind
+	target    uint64
+	hasTarget bool
+}
+
+// flowAMD64 decodes the x86-64 instruction at the start of code, at addr.
+func flowAMD64(code []byte, addr uint64, kernel *kernelThunks) flow {
+	if len(code) >= 4 && code[0] == 0xf3 && code[1] == 0x0f && code[2] == 0x1e &&
+		(code[3] == 0xfa || code[3] == 0xfb) {
+		return flow{n: 4}
+	}
+	inst, err := x86asm.Decode(code, 64)
+	if err != nil {
+		return flow{n: 1, kind: flowReturn}
+	}
+	return instFlowAMD64(inst, addr, kernel)
+}
+
+// instFlowAMD64 returns the control transfer of the decoded x86-64
+// instruction inst, at addr.
+func instFlowAMD64(inst x86asm.Inst, addr uint64, kernel *kernelThunks) flow {
+	f := flow{n: inst.Len}
+	if kernel != nil && kernel.kind(inst, addr) == thunkReturn {
+		f.kind = flowReturn
+		return f
+	}
+
+	switch {
+	case inst.Op == x86asm.RET:
+		f.kind = flowReturn
+	case inst.Op == x86asm.UD2, inst.Op == x86asm.HLT, isInt3AMD64(inst):
+		f.kind = flowStop
+	case inst.Op == x86asm.JMP, isConditionalJumpAMD64(inst.Op):
+		rel, ok := inst.Args[0].(x86asm.Rel)
+		if !ok {
+			// Jump tables and indirect tail calls.
+			f.kind = flowReturn
+			return f
+		}
+		f.kind = flowBranch
+		if inst.Op == x86asm.JMP {
+			f.kind = flowJump
+		}
+		f.target, f.hasTarget = addr+uint64(inst.Len)+uint64(int64(rel)), true
+	case inst.Op == x86asm.CALL:
+		f.kind = flowCall
+		if edge := extractTargetAMD64(inst, addr, CallSiteCall, ConfidenceHigh); edge != nil && edge.Confidence != ConfidenceNone {
+			f.target, f.hasTarget = edge.TargetAddr, true
+		}
+	}
+	return f
+}
+
+// flowARM64 decodes the ARM64 instruction at the start of code, at addr.
+func flowARM64(code []byte, addr uint64, _ *kernelThunks) flow {
+	if len(code) < 4 {
+		return flow{n: len(code), kind: flowReturn}
+	}
+	w := binary.LittleEndian.Uint32(code)
+	f := flow{n: 4}
+	imm26 := uint64(int64(int32(w<<6)>>6) * 4)
+	imm19 := uint64(int64(int32(w<<8)>>13) * 4)
+	imm14 := uint64(int64(int32(w<<13)>>18) * 4)
+
+	switch {
+	case w&0xfffffc1f == 0xd65f0000: // ret
+		f.kind = flowReturn
+	case w&0xfffffc1f == 0xd61f0000: // br
+		f.kind = flowReturn
+	case w>>16 == 0, w&0xffe0001f == 0xd4200000: // udf, brk
+		f.kind = flowStop
+	case w&0xfc000000 == 0x14000000: // b
+		f.kind, f.target, f.hasTarget = flowJump, addr+imm26, true
+	case w&0xfc000000 == 0x94000000: // bl
+		f.kind, f.target, f.hasTarget = flowCall, addr+imm26, true
+	case w&0xff000010 == 0x54000000, // b.cond
+		w&0x7e000000 == 0x34000000: // cbz, cbnz
+		f.kind, f.target, f.hasTarget = flowBranch, addr+imm19, true
+	case w&0x7e000000 == 0x36000000: // tbz, tbnz
+		f.kind, f.target, f.hasTarget = flowBranch, addr+imm14, true
+	}
+	return f
+}
+
+// functionExtent is the code of a function candidate, up to the next one.
+type functionExtent struct {
+	start, end uint64
+	sec        *codeSection
+}
+
+// noReturnAnalysis infers the functions that never return to their caller.
+type noReturnAnalysis struct {
+	decode func(code []byte, addr uint64, kernel *kernelThunks) flow
+	kernel *kernelThunks
+}
+
+// returns reports whether any path from the entry of fn reaches a return,
+// given the addresses that calls and tail calls never return from. Paths
+// leaving the extent other than through a call or tail call, and code that
+// cannot be decoded, are taken as returning.
+func (a *noReturnAnalysis) returns(fn functionExtent, noreturn noReturnSet) (bool, error) {
+	c := cursor{src: fn.sec.src}
+	off := fn.start - fn.sec.addr
+	size := fn.end - fn.start
+
+	visited := make(map[uint64]bool)
+	work := []uint64{0}
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		for {
+			if pc >= size {
+				// Falls through into the next function.
+				return true, nil
+			}
+			if visited[pc] {
+				break
+			}
+			visited[pc] = true
+
+			code, err := c.at(int(off + pc))
+			if err != nil {
+				return false, err
+			}
+			f := a.decode(code, fn.start+pc, a.kernel)
+			next := pc + uint64(f.n)
+			switch f.kind {
+			case flowReturn:
+				return true, nil
+			case flowStop:
+				next = size + 1
+			case flowCall:
+				if f.hasTarget && noreturn[f.target] {
+					next = size + 1
+				}
+			case flowJump, flowBranch:
+				switch {
+				case f.target >= fn.start && f.target < fn.end:
+					work = append(work, f.target-fn.start)
+				case !noreturn[f.target]:
+					// Tail call to a function that may return.
+					return true, nil
+				}
+				if f.kind == flowJump {
+					next = size + 1
+				}
+			}
+			if next > size {
+				break
+			}
+			pc = next
+		}
+	}
+	return false, nil
+}
+
+// inferNoReturn returns the entries of the functions among candidates that
+// never return, given the known non-returning addresses. Every candidate is
+// first assumed not to return, and is dropped from the set as soon as one of
+// its paths returns, until no more candidates are dropped. Only candidates
+// of at least medium confidence other than jump targets delimit functions.
+func inferNoReturn(ctx context.Context, sections []codeSection, candidates []FunctionCandidate, known noReturnSet, arch Arch, opts Options) (noReturnSet, error) {
+	a := &noReturnAnalysis{kernel: opts.kernel}
+	switch arch {
+	case ArchAMD64:
+		a.decode = flowAMD64
+	case ArchARM64:
+		a.decode = flowARM64
+	default:
+		return nil, fmt.Errorf("unsupported architecture: %s", arch)
+	}
+
+	var entries []uint64
+	for _, c := range candidates {
+		// Jump targets are mostly within functions until jumps are
+		// classified.
+		if c.Confidence.rank() >= ConfidenceMedium.rank() && c.DetectionType != DetectionJumpTarget {
+			entries = append(entries, c.Address)
+		}
+	}
+	slices.Sort(entries)
+
+	var fns []functionExtent
+	for i := range sections {
+		sec := &sections[i]
+		end := sec.addr + uint64(sec.src.size)
+		lo, _ := slices.BinarySearch(entries, sec.addr)
+		hi, _ := slices.BinarySearch(entries, end)
+		for k := lo; k < hi; k++ {
+			fnEnd := end
+			if k+1 < hi {
+				fnEnd = entries[k+1]
+			}
+			fns = append(fns, functionExtent{start: entries[k], end: fnEnd, sec: sec})
+		}
+	}
+
+	noreturn := make(noReturnSet, len(known)+len(fns))
+	for addr := range known {
+		noreturn[addr] = true
+	}
+	for _, fn := range fns {
+		noreturn[fn.start] = true
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, fn := range fns {
+			if !noreturn[fn.start] || known[fn.start] {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			returns, err := a.returns(fn, noreturn)
+			if err != nil {
+				return nil, err
+			}
+			if returns {
+				delete(noreturn, fn.start)
+				changed = true
+			}
+		}
+	}
+
+	opts.logger().Debug("inferred non-returning functions",
+		"arch", arch, "functions", len(fns), "known", len(known), "count", len(noreturn)-len(known))
+
+	return noreturn, nil
+}
+
+// refineNoReturn infers the non-returning functions among the candidates
+// merged from ev, and detects the prologues and padding of sections again,
+// taking calls to them as function boundaries. It returns the addresses
+// that calls never return from.
+func refineNoReturn(ctx context.Context, sections []codeSection, ev *evidence, arch Arch, opts Options) (noReturnSet, error) {
+	size := 0
+	for _, sec := range sections {
+		size += sec.src.size
+	}
+	opts.section = ""
+	if len(sections) == 1 {
+		opts.section = sections[0].name
+	}
+	t := newTracker(ctx, opts, PhaseNoReturn, size)
+	if err := t.start(); err != nil {
+		return nil, err
+	}
+
+	candidates := mergeCandidates(*ev, functionAlignment(arch))
+	noreturn, err := inferNoReturn(ctx, sections, candidates, opts.noreturn, arch, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Detect again only if a call site reaches a non-returning function.
+	if slices.ContainsFunc(ev.edges, func(e CallSiteEdge) bool {
+		return e.Type == CallSiteCall && noreturn[e.TargetAddr]
+	}) {
+		resweep := opts
+		resweep.noreturn = noreturn
+		resweep.Progress = nil
+		var prologues []Prologue
+		var gaps []Gap
+		for _, sec := range sections {
+			resweep.section = sec.name
+			if opts.uses(EvidencePrologue) {
+				p, err := detectPrologues(ctx, sec.src, sec.addr, arch, resweep)
+				if err != nil {
+					return nil, fmt.Errorf("failed to detect prologues: %w", err)
+				}
+				prologues = append(prologues, p...)
+			}
+			if opts.uses(EvidencePadding) {
+				g, err := detectPadding(ctx, sec.src, sec.addr, arch, resweep)
+				if err != nil {
+					return nil, fmt.Errorf("failed to detect padding: %w", err)
+				}
+				gaps = append(gaps, g...)
+			}
+		}
+		ev.prologues, ev.gaps = prologues, gaps
+	}
+
+	if err := t.advance(size); err != nil {
+		return nil, err
+	}
+	return noreturn, nil
+}
+
+// markNoReturn sets NoReturn on the candidates whose address is in
+// noreturn.
+func markNoReturn(candidates []FunctionCandidate, noreturn noReturnSet) {
+	for i := range candidates {
+		if noreturn[candidates[i].Address] {
+			candidates[i].NoReturn = true
+		}
+	}
+}
+
+// readELFNoReturn parses the ELF file in r and returns the addresses that
+// calls never return from, as elfNoReturn.
+func readELFNoReturn(r io.ReaderAt, arch Arch, opts Options) (noReturnSet, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ELF file: %w", err)
+	}
+	defer f.Close()
+
+	return elfNoReturn(f, arch, opts)
+}
+
+// noReturner is implemented by the images that know the addresses that
+// calls never return from.
+type noReturner interface {
+	noReturn(opts Options) (noReturnSet, error)
+}
+
+func (img *elfImage) noReturn(opts Options) (noReturnSet, error) {
+	return elfNoReturn(img.f, img.arch, opts)
+}
+
+// elfNoReturn returns the addresses that calls never return from in the
+// ELF file f: the entries of the known non-returning functions defined in
+// its symbol tables, and the PLT stubs and GOT slots of those it imports.
+// Sections are read in windows of the size set by opts, if any.
+func elfNoReturn(f *elf.File, arch Arch, opts Options) (noReturnSet, error) {
+	noreturn := make(noReturnSet)
+	if f.Class != elf.ELFCLASS64 {
+		return noreturn, nil
+	}
+
+	// Dynamic symbols, by index, for the relocations.
+	dynsyms := make(map[uint32]bool)
+	for _, sec := range f.Sections {
+		if sec.Type != elf.SHT_SYMTAB && sec.Type != elf.SHT_DYNSYM {
+			continue
+		}
+		syms, err := scanELFSymbols(f, sec, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, sym := range syms {
+			if sec.Type == elf.SHT_DYNSYM {
+				dynsyms[sym.index] = true
+			}
+			if elf.ST_TYPE(sym.info) == elf.STT_FUNC && sym.shndx != elf.SHN_UNDEF {
+				noreturn[sym.value] = true
+			}
+		}
+	}
+	if len(dynsyms) == 0 {
+		return noreturn, nil
+	}
+
+	slots, err := elfNoReturnSlots(f, dynsyms, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(slots) == 0 {
+		return noreturn, nil
+	}
+	for slot := range slots {
+		noreturn[slot] = true
+	}
+
+	for _, name := range []string{".plt", ".plt.sec"} {
+		sec := f.Section(name)
+		if sec == nil || sec.Type == elf.SHT_NOBITS {
+			continue
+		}
+		src, err := sectionSource(sec, opts)
+		if err != nil {
+			return nil, err
+		}
+		stubs, err := pltStubs(src, sec.Addr, arch, slots)
+		if err != nil {
+			return nil, err
+		}
+		for _, stub := range stubs {
+			noreturn[stub] = true
+		}
+	}
+
+	return noreturn, nil
+}
+
+// elfSymbol is an entry of an ELF symbol table.
+type elfSymbol struct {
+	index uint32
+	name  uint32
+	info  uint8
+	shndx elf.SectionIndex
+	value uint64
+}
+
+// sectionReader returns a buffered reader over the contents of sec, which
+// reads at most the window size set by opts at once.
+func sectionReader(sec *elf.Section, opts Options) *bufio.Reader {
+	return bufio.NewReaderSize(sec.Open(), cmp.Or(opts.windowSize(), 4096))
+}
+
+// scanELFSymbols returns the entries of the 64-bit symbol table sec of f
+// named after a known non-returning function.
+func scanELFSymbols(f *elf.File, sec *elf.Section, opts Options) ([]elfSymbol, error) {
+	if sec.Link == 0 || int(sec.Link) >= len(f.Sections) {
+		return nil, nil
+	}
+
+	var syms []elfSymbol
+	r := sectionReader(sec, opts)
+	var entry [elf.Sym64Size]byte
+	for index := uint32(0); ; index++ {
+		if _, err := io.ReadFull(r, entry[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, fmt.Errorf("failed to read %s section: %w", sec.Name, err)
+		}
+		sym := elfSymbol{
+			index: index,
+			name:  f.ByteOrder.Uint32(entry[0:]),
+			info:  entry[4],
+			shndx: elf.SectionIndex(f.ByteOrder.Uint16(entry[6:])),
+			value: f.ByteOrder.Uint64(entry[8:]),
+		}
+		if sym.name != 0 {
+			syms = append(syms, sym)
+		}
+	}
+
+	// Resolve the names in a single pass over the string table.
+	slices.SortFunc(syms, func(a, b elfSymbol) int { return cmp.Compare(a.name, b.name) })
+	strtab := f.Sections[sec.Link]
+	r = sectionReader(strtab, opts)
+	var off uint32
+	matched := syms[:0]
+	for i := 0; i < len(syms); {
+		name, err := r.ReadString(0)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("failed to read %s section: %w", strtab.Name, err)
+		}
+		end := off + uint32(len(name))
+		// Names may be suffixes of others.
+		for ; i < len(syms) && syms[i].name < end; i++ {
+			if syms[i].name >= off && isNoReturnName(name[syms[i].name-off:len(name)-1]) {
+				matched = append(matched, syms[i])
+			}
+		}
+		off = end
+	}
+	return matched, nil
+}
+
+// elfNoReturnSlots returns the GOT slots that the dynamic relocations of f
+// bind to the dynamic symbols, by index, in dynsyms.
+func elfNoReturnSlots(f *elf.File, dynsyms map[uint32]bool, opts Options) (map[uint64]bool, error) {
+	slots := make(map[uint64]bool)
+	for _, sec := range f.Sections {
+		if sec.Type != elf.SHT_RELA || sec.Link == 0 || int(sec.Link) >= len(f.Sections) ||
+			f.Sections[sec.Link].Type != elf.SHT_DYNSYM {
+			continue
+		}
+		r := sectionReader(sec, opts)
+		var rela [24]byte
+		for {
+			if _, err := io.ReadFull(r, rela[:]); err != nil {
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					break
+				}
+				return nil, fmt.Errorf("failed to read %s section: %w", sec.Name, err)
+			}
+			off := f.ByteOrder.Uint64(rela[0:])
+			info := f.ByteOrder.Uint64(rela[8:])
+			typ, idx := uint32(info), uint32(info>>32)
+			switch {
+			case f.Machine == elf.EM_X86_64 &&
+				(elf.R_X86_64(typ) == elf.R_X86_64_JMP_SLOT || elf.R_X86_64(typ) == elf.R_X86_64_GLOB_DAT),
+				f.Machine == elf.EM_AARCH64 &&
+					(elf.R_AARCH64(typ) == elf.R_AARCH64_JUMP_SLOT || elf.R_AARCH64(typ) == elf.R_AARCH64_GLOB_DAT):
+				if dynsyms[idx] {
+					slots[off] = true
+				}
+			}
+		}
+	}
+	return slots, nil
+}
+
+// pltStubs returns the addresses of the PLT stubs in src, at addr, that jump
+// through one of slots.
+func pltStubs(src source, addr uint64, arch Arch, slots map[uint64]bool) ([]uint64, error) {
+	var stubs []uint64
+	c := cursor{src: src}
+	switch arch {
+	case ArchAMD64:
+		// jmp [rip+disp], possibly after endbr64 and with a bnd prefix.
+		// Stubs are 16-byte aligned.
+		for off := 0; off < src.size; {
+			code, err := c.at(off)
+			if err != nil {
+				return nil, err
+			}
+			inst, err := x86asm.Decode(code, 64)
+			if err != nil {
+				off++
+				continue
+			}
+			pc := addr + uint64(off)
+			if inst.Op == x86asm.JMP {
+				if mem, ok := inst.Args[0].(x86asm.Mem); ok && mem.Base == x86asm.RIP && mem.Index == 0 &&
+					slots[pc+uint64(inst.Len)+uint64(mem.Disp)] {
+					stubs = append(stubs, pc&^15)
+				}
+			}
+			off += inst.Len
+		}
+	case ArchARM64:
+		// adrp x16, page; ldr x17, [x16, #off]
+		for off := 0; off+8 <= src.size; off += 4 {
+			code, err := c.at(off)
+			if err != nil {
+				return nil, err
+			}
+			adrp := binary.LittleEndian.Uint32(code)
+			ldr := binary.LittleEndian.Uint32(code[4:])
+			if adrp&0x9f00001f != 0x90000010 || ldr&0xffc003ff != 0xf9400211 {
+				continue
+			}
+			pc := addr + uint64(off)
+			imm := int64(int32((adrp>>5&0x7ffff)<<2|adrp>>29&3) << 11 >> 11)
+			page := pc&^0xfff + uint64(imm<<12)
+			if slots[page+uint64(ldr>>10&0xfff)*8] {
+				stubs = append(stubs, pc)
+			}
+		}
+	}
+	return slices.Compact(stubs), nil
+}
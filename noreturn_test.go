@@ -0,0 +1,145 @@
+package resurgo_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+func TestDetectFunctions_NoReturnAMD64(t *testing.T) {
+	// 0x1000: push rbx; call 0x1020  - never returns
+	// 0x1006: push rbx; xor eax, eax; pop rbx; ret
+	// 0x100b: int3 x21
+	// 0x1020: push rbp; mov rbp, rsp; ud2
+	code := []byte{
+		0x53, 0xe8, 0x1a, 0x00, 0x00, 0x00,
+		0x53, 0x31, 0xc0, 0x5b, 0xc3,
+	}
+	code = append(code, bytes.Repeat([]byte{0xcc}, 21)...)
+	code = append(code, 0x55, 0x48, 0x89, 0xe5, 0x0f, 0x0b)
+
+	candidates, err := resurgo.DetectFunctions(code, 0x1000, resurgo.ArchAMD64)
+	if err != nil {
+		t.Fatalf("DetectFunctions: %v", err)
+	}
+	byAddr := make(map[uint64]resurgo.FunctionCandidate)
+	for _, c := range candidates {
+		byAddr[c.Address] = c
+	}
+
+	if c, ok := byAddr[0x1006]; !ok || c.PrologueType != resurgo.ProloguePushOnly {
+		t.Errorf("0x1006: expected push-only prologue after non-returning call, got %+v", c)
+	}
+	if c := byAddr[0x1020]; !c.NoReturn {
+		t.Errorf("0x1020: expected non-returning function, got %+v", c)
+	}
+	// Its only path calls the non-returning function.
+	if c := byAddr[0x1000]; !c.NoReturn {
+		t.Errorf("0x1000: expected non-returning function, got %+v", c)
+	}
+	if c := byAddr[0x1006]; c.NoReturn {
+		t.Errorf("0x1006: expected returning function, got %+v", c)
+	}
+
+	without, err := resurgo.DetectFunctionsWithOptions(code, 0x1000, resurgo.ArchAMD64, resurgo.Options{
+		Sources: []resurgo.EvidenceSource{resurgo.EvidencePrologue, resurgo.EvidenceCallSite, resurgo.EvidencePadding},
+	})
+	if err != nil {
+		t.Fatalf("DetectFunctionsWithOptions: %v", err)
+	}
+	for _, c := range without {
+		if c.Address == 0x1006 || c.NoReturn {
+			t.Errorf("0x%x: unexpected non-returning evidence: %+v", c.Address, c)
+		}
+	}
+}
+
+func TestDetectFunctions_NoReturnARM64(t *testing.T) {
+	code := []byte{
+		0x03, 0x00, 0x00, 0x94, // 0x1000: bl 0x100c
+		0xff, 0x43, 0x00, 0xd1, // 0x1004: sub sp, sp, #16
+		0xc0, 0x03, 0x5f, 0xd6, // 0x1008: ret
+		0xfd, 0x7b, 0xbf, 0xa9, // 0x100c: stp x29, x30, [sp, #-16]!
+		0xfd, 0x03, 0x00, 0x91, // 0x1010: mov x29, sp
+		0x00, 0x00, 0x20, 0xd4, // 0x1014: brk #0
+	}
+
+	candidates, err := resurgo.DetectFunctions(code, 0x1000, resurgo.ArchARM64)
+	if err != nil {
+		t.Fatalf("DetectFunctions: %v", err)
+	}
+	byAddr := make(map[uint64]resurgo.FunctionCandidate)
+	for _, c := range candidates {
+		byAddr[c.Address] = c
+	}
+
+	if c, ok := byAddr[0x1004]; !ok || c.PrologueType != resurgo.PrologueSubSP {
+		t.Errorf("0x1004: expected sub-sp prologue after non-returning call, got %+v", c)
+	}
+	if c := byAddr[0x100c]; !c.NoReturn {
+		t.Errorf("0x100c: expected non-returning function, got %+v", c)
+	}
+}
+
+func TestDetectFunctionsFromELF_NoReturnC(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found, skipping")
+	}
+
+	binPath := filepath.Join(t.TempDir(), "noreturn")
+	cmd := exec.Command("gcc", "-O2", "-o", binPath, "testdata/noreturn.c")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile testdata/noreturn.c: %v\n%s", err, out)
+	}
+
+	f, err := os.Open(binPath)
+	if err != nil {
+		t.Fatalf("failed to open compiled binary: %v", err)
+	}
+	defer f.Close()
+
+	ef, err := elf.NewFile(f)
+	if err != nil {
+		t.Fatalf("failed to parse ELF file: %v", err)
+	}
+	syms, err := ef.Symbols()
+	if err != nil {
+		t.Fatalf("failed to read symbols: %v", err)
+	}
+	addrs := make(map[string]uint64)
+	for _, sym := range syms {
+		if elf.ST_TYPE(sym.Info) == elf.STT_FUNC && sym.Value != 0 {
+			addrs[sym.Name] = sym.Value
+		}
+	}
+
+	candidates, err := resurgo.DetectFunctionsFromELF(f)
+	if err != nil {
+		t.Fatalf("DetectFunctionsFromELF: %v", err)
+	}
+	byAddr := make(map[uint64]resurgo.FunctionCandidate)
+	for _, c := range candidates {
+		byAddr[c.Address] = c
+	}
+
+	// fail calls exit through the PLT, and fail_twice calls fail.
+	for name, want := range map[string]bool{"fail": true, "fail_twice": true, "check": false} {
+		addr, ok := addrs[name]
+		if !ok {
+			t.Fatalf("symbol %s not found", name)
+		}
+		c, ok := byAddr[addr]
+		if !ok {
+			t.Errorf("%s: no candidate at 0x%x", name, addr)
+			continue
+		}
+		if c.NoReturn != want {
+			t.Errorf("%s: expected NoReturn %v, got %+v", name, want, c)
+		}
+	}
+}
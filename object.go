@@ -0,0 +1,292 @@
+package resurgo
+
+import (
+	"context"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// ObjectFunctions holds the function candidates detected in an executable
+// section of a relocatable object. Addresses are relative to the start of
+// the section, since sections of an object have no final address until
+// linked.
+type ObjectFunctions struct {
+	// Member is the name of the archive member holding the object, or
+	// empty when analyzing a single object.
+	Member    string              `json:"member,omitempty"`
+	Section   string              `json:"section"`
+	Functions []FunctionCandidate `json:"functions"`
+	// CallSites holds the call sites of the section. Relocated call sites
+	// name the referenced symbol in TargetSymbol; their TargetAddr is zero
+	// unless the symbol is defined in the same section.
+	CallSites []CallSiteEdge `json:"call_sites"`
+}
+
+// objectReloc is a branch relocation applied to a call site of an object.
+type objectReloc struct {
+	symbol string
+	// local reports whether the symbol is defined in the relocated section,
+	// in which case target is the address the branch resolves to.
+	local  bool
+	target uint64
+}
+
+// DetectFunctionsFromObject parses an ELF relocatable object (ET_REL) from r
+// and detects function candidates in each of its executable sections, such
+// as .text and the .text.<name> sections emitted by -ffunction-sections.
+// Addresses are relative to each section. The branch relocations of a
+// section are applied to its call sites, so that calls resolve to the
+// referenced symbol rather than to the placeholder left by the assembler;
+// only calls to symbols defined in the same section contribute candidates.
+// The architecture is inferred from the ELF header.
+func DetectFunctionsFromObject(r io.ReaderAt) ([]ObjectFunctions, error) {
+	return DetectFunctionsFromObjectContext(context.Background(), r, Options{})
+}
+
+// DetectFunctionsFromObjectWithOptions is like DetectFunctionsFromObject but
+// applies the settings in opts. When opts lists sections, only those are
+// analyzed.
+func DetectFunctionsFromObjectWithOptions(r io.ReaderAt, opts Options) ([]ObjectFunctions, error) {
+	return DetectFunctionsFromObjectContext(context.Background(), r, opts)
+}
+
+// DetectFunctionsFromObjectContext is like
+// DetectFunctionsFromObjectWithOptions but stops with the context error as
+// soon as ctx is done, and reports progress to opts.Progress.
+func DetectFunctionsFromObjectContext(ctx context.Context, r io.ReaderAt, opts Options) ([]ObjectFunctions, error) {
+	return detectObjectFunctions(ctx, r, "", opts)
+}
+
+// detectObjectFunctions analyzes the relocatable object read from r, held
+// by the archive member named member, if any.
+func detectObjectFunctions(ctx context.Context, r io.ReaderAt, member string, opts Options) ([]ObjectFunctions, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ELF file: %w", err)
+	}
+	defer f.Close()
+
+	if f.Type != elf.ET_REL {
+		return nil, fmt.Errorf("not an ELF relocatable object: %s", f.Type)
+	}
+
+	arch, err := elfArch(f.Machine)
+	if err != nil {
+		return nil, err
+	}
+
+	syms, err := f.Symbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, fmt.Errorf("failed to read symbols: %w", err)
+	}
+
+	indices, err := objectSections(f, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	kernel := opts.kernel != nil
+
+	var result []ObjectFunctions
+	for _, i := range indices {
+		sec := f.Sections[i]
+		src, err := sectionSource(sec, opts)
+		if err != nil {
+			return nil, err
+		}
+		relocs, err := readObjectRelocs(f, i, syms, arch)
+		if err != nil {
+			return nil, err
+		}
+
+		opts.logger().Debug("analyzing object section",
+			"member", member, "section", sec.Name, "size", src.size, "relocations", len(relocs))
+
+		if kernel {
+			opts.kernel = moduleThunks(relocs)
+		}
+		opts.section = sec.Name
+		if member != "" {
+			opts.section = member + ":" + sec.Name
+		}
+
+		ev, err := detectEvidence(ctx, src, 0, arch, opts)
+		if err != nil {
+			return nil, err
+		}
+		edges := ev.edges
+		ev.edges, err = applyObjectRelocs(edges, relocs, src, arch)
+		if err != nil {
+			return nil, err
+		}
+		candidates, err := mergeEvidence(ctx, ev, 0, arch, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, ObjectFunctions{
+			Member:    member,
+			Section:   sec.Name,
+			Functions: opts.filterCandidates(candidates),
+			CallSites: opts.filterCallSites(edges),
+		})
+	}
+
+	return result, nil
+}
+
+// objectSections returns the indices of the sections of f to analyze: the
+// sections listed in opts, or all the executable sections with contents.
+func objectSections(f *elf.File, opts Options) ([]int, error) {
+	var indices []int
+	if len(opts.Sections) > 0 {
+		for _, name := range opts.Sections {
+			i := slices.IndexFunc(f.Sections, func(s *elf.Section) bool { return s.Name == name })
+			if i < 0 {
+				return nil, fmt.Errorf("no %s section found", name)
+			}
+			indices = append(indices, i)
+		}
+		return indices, nil
+	}
+
+	for i, sec := range f.Sections {
+		if sec.Type == elf.SHT_PROGBITS && sec.Flags&elf.SHF_EXECINSTR != 0 && sec.Size > 0 {
+			indices = append(indices, i)
+		}
+	}
+	return indices, nil
+}
+
+// readObjectRelocs returns the branch relocations applied to the section of
+// f at index, keyed by the offset of the relocated field.
+func readObjectRelocs(f *elf.File, index int, syms []elf.Symbol, arch Arch) (map[uint64]objectReloc, error) {
+	relocs := make(map[uint64]objectReloc)
+	for _, rs := range f.Sections {
+		if rs.Type != elf.SHT_RELA || rs.Info != uint32(index) {
+			continue
+		}
+
+		data, err := rs.Data()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s section: %w", rs.Name, err)
+		}
+
+		for ; len(data) >= 24; data = data[24:] {
+			off := f.ByteOrder.Uint64(data[0:])
+			info := f.ByteOrder.Uint64(data[8:])
+			addend := int64(f.ByteOrder.Uint64(data[16:]))
+
+			symIndex, typ := int(elf.R_SYM64(info)), elf.R_TYPE64(info)
+			if symIndex == 0 || symIndex > len(syms) || !isBranchReloc(arch, typ) {
+				continue
+			}
+			sym := syms[symIndex-1]
+
+			// The branch lands on S + A, plus the distance from the
+			// relocated field to the end of the instruction on x86-64,
+			// where displacements are relative to the next instruction.
+			target := sym.Value + uint64(addend)
+			if arch == ArchAMD64 {
+				target += 4
+			}
+
+			name := sym.Name
+			if elf.ST_TYPE(sym.Info) == elf.STT_SECTION && int(sym.Section) < len(f.Sections) {
+				name = f.Sections[sym.Section].Name
+				if target != 0 {
+					name += fmt.Sprintf("+0x%x", target)
+				}
+			}
+
+			relocs[off] = objectReloc{
+				symbol: name,
+				local:  sym.Section == elf.SectionIndex(index),
+				target: target,
+			}
+		}
+	}
+
+	return relocs, nil
+}
+
+// isBranchReloc reports whether a relocation type patches the target of a
+// PC-relative branch.
+func isBranchReloc(arch Arch, typ uint32) bool {
+	switch arch {
+	case ArchAMD64:
+		switch elf.R_X86_64(typ) {
+		case elf.R_X86_64_PC32, elf.R_X86_64_PLT32:
+			return true
+		}
+	case ArchARM64:
+		switch elf.R_AARCH64(typ) {
+		case elf.R_AARCH64_CALL26, elf.R_AARCH64_JUMP26,
+			elf.R_AARCH64_CONDBR19, elf.R_AARCH64_TSTBR14:
+			return true
+		}
+	}
+	return false
+}
+
+// applyObjectRelocs resolves the targets of the relocated call sites in
+// edges, and returns the edges whose target lies in the section.
+func applyObjectRelocs(edges []CallSiteEdge, relocs map[uint64]objectReloc, src source, arch Arch) ([]CallSiteEdge, error) {
+	local := make([]CallSiteEdge, 0, len(edges))
+	for i := range edges {
+		e := &edges[i]
+		if e.AddressMode == AddressingModePCRelative && len(relocs) > 0 {
+			off, ok, err := branchFieldOffset(*e, src, arch)
+			if err != nil {
+				return nil, err
+			}
+			if rel, found := relocs[off]; ok && found {
+				e.TargetSymbol = rel.symbol
+				e.TargetAddr = 0
+				if !rel.local {
+					continue
+				}
+				e.TargetAddr = rel.target
+			}
+		}
+
+		if e.Confidence != ConfidenceNone && e.TargetAddr < uint64(src.size) {
+			local = append(local, *e)
+		}
+	}
+	return local, nil
+}
+
+// branchFieldOffset returns the offset of the field holding the target of
+// the PC-relative branch at e.SourceAddr: the instruction itself on ARM64,
+// and its trailing 32-bit displacement on x86-64. It reports false for
+// branches with a shorter displacement, which are never relocated.
+func branchFieldOffset(e CallSiteEdge, src source, arch Arch) (uint64, bool, error) {
+	if arch != ArchAMD64 {
+		return e.SourceAddr, true, nil
+	}
+
+	start := int(e.SourceAddr)
+	code := src.code
+	if src.r != nil {
+		code = make([]byte, min(windowLookahead, src.size-start))
+		if _, err := src.r.ReadAt(code, int64(start)); err != nil && err != io.EOF {
+			return 0, false, fmt.Errorf("failed to read code at offset 0x%x: %w", start, err)
+		}
+		start = 0
+	}
+
+	inst, err := x86asm.Decode(code[start:], 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to decode call site at 0x%x: %w", e.SourceAddr, err)
+	}
+	if inst.Len < 5 {
+		return 0, false, nil
+	}
+	return e.SourceAddr + uint64(inst.Len) - 4, true, nil
+}
@@ -0,0 +1,220 @@
+package resurgo_test
+
+import (
+	"debug/elf"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+const demoAppCSource = "testdata/demo-app.c"
+
+// compileObject compiles the C demo application into a relocatable object
+// named name with the given extra flags, and returns its path.
+func compileObject(t *testing.T, dir, name string, args ...string) string {
+	t.Helper()
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found, skipping")
+	}
+
+	outPath := filepath.Join(dir, name)
+	cmd := exec.Command("gcc", append(args, "-c", "-o", outPath, demoAppCSource)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile %s: %v\n%s", demoAppCSource, err, out)
+	}
+	return outPath
+}
+
+// objectFuncs returns the section and value of the function symbols
+// defined in the object at path.
+func objectFuncs(t *testing.T, path string) map[string]elf.Symbol {
+	t.Helper()
+	f, err := elf.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open object: %v", err)
+	}
+	defer f.Close()
+
+	syms, err := f.Symbols()
+	if err != nil {
+		t.Fatalf("failed to read symbols: %v", err)
+	}
+	funcs := make(map[string]elf.Symbol)
+	for _, s := range syms {
+		if elf.ST_TYPE(s.Info) == elf.STT_FUNC && s.Section != elf.SHN_UNDEF {
+			funcs[s.Name] = s
+		}
+	}
+	return funcs
+}
+
+func TestDetectFunctionsFromObject(t *testing.T) {
+	objPath := compileObject(t, t.TempDir(), "demo-app.o", "-O0")
+	funcs := objectFuncs(t, objPath)
+
+	f, err := os.Open(objPath)
+	if err != nil {
+		t.Fatalf("failed to open object: %v", err)
+	}
+	defer f.Close()
+
+	results, err := resurgo.DetectFunctionsFromObject(f)
+	if err != nil {
+		t.Fatalf("DetectFunctionsFromObject: %v", err)
+	}
+	if len(results) != 1 || results[0].Section != ".text" {
+		t.Fatalf("expected a single .text section, got %+v", results)
+	}
+	text := results[0]
+
+	called := make(map[uint64]bool)
+	for _, c := range text.Functions {
+		if len(c.CalledFrom) > 0 {
+			called[c.Address] = true
+		}
+	}
+	// Each of these is called from main or from another function, through
+	// a relocation against its symbol.
+	for _, name := range []string{"observe", "add", "multiply", "subtract", "divide"} {
+		if !called[funcs[name].Value] {
+			t.Errorf("expected %s at 0x%x to be a call target", name, funcs[name].Value)
+		}
+	}
+
+	var printf bool
+	for _, e := range text.CallSites {
+		if e.TargetSymbol == "printf" {
+			printf = true
+			if e.TargetAddr != 0 {
+				t.Errorf("expected external call to printf without target address, got 0x%x", e.TargetAddr)
+			}
+		}
+	}
+	if !printf {
+		t.Error("expected a call site relocated against printf")
+	}
+}
+
+func TestDetectFunctionsFromObject_FunctionSections(t *testing.T) {
+	objPath := compileObject(t, t.TempDir(), "demo-app.o", "-O0", "-ffunction-sections")
+
+	f, err := os.Open(objPath)
+	if err != nil {
+		t.Fatalf("failed to open object: %v", err)
+	}
+	defer f.Close()
+
+	results, err := resurgo.DetectFunctionsFromObject(f)
+	if err != nil {
+		t.Fatalf("DetectFunctionsFromObject: %v", err)
+	}
+
+	sections := make(map[string]resurgo.ObjectFunctions)
+	for _, r := range results {
+		sections[r.Section] = r
+	}
+	main, ok := sections[".text.main"]
+	if !ok {
+		t.Fatalf("expected a .text.main section, got %d sections", len(results))
+	}
+
+	// Calls from main cross into other sections: they name their target
+	// but contribute no candidates to main's section.
+	targets := make(map[string]bool)
+	for _, e := range main.CallSites {
+		targets[e.TargetSymbol] = true
+	}
+	for _, name := range []string{"add", "multiply", "subtract", "divide", "printf"} {
+		if !targets[name] {
+			t.Errorf("expected a call from main to %s", name)
+		}
+	}
+	for _, c := range main.Functions {
+		if len(c.CalledFrom) > 0 {
+			t.Errorf("unexpected call target 0x%x in .text.main", c.Address)
+		}
+	}
+}
+
+func TestDetectFunctionsFromArchive(t *testing.T) {
+	if _, err := exec.LookPath("ar"); err != nil {
+		t.Skip("ar not found, skipping")
+	}
+
+	dir := t.TempDir()
+	// The second name is longer than 15 characters and is stored in the
+	// GNU long name table.
+	short := compileObject(t, dir, "demo.o", "-O0")
+	long := compileObject(t, dir, "demo-function-sections.o", "-O0", "-ffunction-sections")
+
+	archive := filepath.Join(dir, "libdemo.a")
+	if out, err := exec.Command("ar", "rcs", archive, short, long).CombinedOutput(); err != nil {
+		t.Fatalf("failed to create archive: %v\n%s", err, out)
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer f.Close()
+
+	results, err := resurgo.DetectFunctionsFromArchive(f)
+	if err != nil {
+		t.Fatalf("DetectFunctionsFromArchive: %v", err)
+	}
+
+	members := make(map[string]int)
+	for _, r := range results {
+		members[r.Member]++
+	}
+	if members["demo.o"] != 1 {
+		t.Errorf("expected 1 section from demo.o, got %d", members["demo.o"])
+	}
+	if members["demo-function-sections.o"] < 6 {
+		t.Errorf("expected a section per function from demo-function-sections.o, got %d", members["demo-function-sections.o"])
+	}
+}
+
+func TestDetectFunctionsFromArchive_NotArchive(t *testing.T) {
+	if _, err := resurgo.DetectFunctionsFromArchive(strings.NewReader("not an archive")); err == nil {
+		t.Error("expected error for non-archive input")
+	}
+}
+
+func TestDetectFunctionsFromArchive_Malformed(t *testing.T) {
+	// member returns an ar member header and its data, padded to an even
+	// size.
+	member := func(name, size, data string) string {
+		hdr := fmt.Sprintf("%-16s%-12s%-6s%-6s%-8s%-10s`\n", name, "0", "0", "0", "644", size)
+		if len(data)%2 == 1 {
+			data += "\n"
+		}
+		return hdr + data
+	}
+	longNames := member("//", "8", "long.o/\n")
+
+	tests := []struct {
+		name    string
+		members string
+	}{
+		{"negative long name offset", longNames + member("/-1", "2", "xx")},
+		{"long name offset past the table", longNames + member("/8", "2", "xx")},
+		{"long name without a table", member("/0", "2", "xx")},
+		{"negative BSD name length", member("#1/-3", "2", "xx")},
+		{"BSD name longer than the member", member("#1/4", "2", "xx")},
+		{"negative size", member("demo.o/", "-5", "xx")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := resurgo.DetectFunctionsFromArchive(strings.NewReader("!<arch>\n" + tt.members))
+			if err == nil {
+				t.Error("expected an error for a malformed archive")
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package resurgo
+
+import (
+	"log/slog"
+	"slices"
+)
+
+// EvidenceSource identifies a detection signal used by DetectFunctions.
+type EvidenceSource string
+
+// Recognized evidence sources.
+const (
+	EvidencePrologue EvidenceSource = "prologue"
+	EvidenceCallSite EvidenceSource = "call-site"
+	// EvidenceSymbol uses the function symbols of MiniDebugInfo
+	// (.gnu_debugdata), when present. It only applies to the FromELF
+	// variants.
+	EvidenceSymbol EvidenceSource = "symbol"
+	// EvidencePadding uses the padding between functions: a candidate at
+	// an aligned address right after padding gains confidence, and such
+	// an address without other evidence becomes a low-confidence
+	// candidate.
+	EvidencePadding EvidenceSource = "padding"
+	// EvidenceNoReturn infers the functions that never return (exit,
+	// abort, __stack_chk_fail and their callers), and takes the code
+	// following a call to them as a possible function entry, like the code
+	// following a RET.
+	EvidenceNoReturn EvidenceSource = "noreturn"
+)
+
+// AddressRange is a half-open virtual address interval [Start, End).
+type AddressRange struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+// Contains reports whether addr lies within the range.
+func (r AddressRange) Contains(addr uint64) bool {
+	return addr >= r.Start && addr < r.End
+}
+
+// Options configures the Detect*WithOptions functions. The zero value
+// reproduces the behaviour of the corresponding functions without options.
+type Options struct {
+	// Sources selects the evidence sources combined by DetectFunctions.
+	// A nil or empty slice enables all sources.
+	Sources []EvidenceSource
+
+	// MinConfidence drops call sites and function candidates whose
+	// confidence is lower than the given level. Prologues carry no
+	// confidence and are not affected. The empty value keeps everything.
+	MinConfidence Confidence
+
+	// IncludeConditionalJumps reports conditional branches (Jcc on x86_64,
+	// B.cond, CBZ, CBNZ, TBZ and TBNZ on ARM64) as low-confidence jump
+	// call sites. DetectFunctions promotes their targets to low-confidence
+	// candidates.
+	IncludeConditionalJumps bool
+
+	// Ranges restricts results to addresses within at least one of the
+	// given ranges: the prologue address, the call site source address and
+	// the candidate address. Code outside the ranges is still decoded, so
+	// callers outside a range are still attributed to candidates inside it.
+	// A nil or empty slice places no restriction.
+	Ranges []AddressRange
+
+	// Sections lists the ELF sections analyzed by the FromELF variants.
+	// A nil or empty slice analyzes .text only.
+	Sections []string
+
+	// Concurrency is the number of goroutines decoding code in parallel.
+	// Code is split into chunks that are decoded independently and merged
+	// to the same output as a serial decoding. Values lower than 2 decode
+	// serially. The streaming iterators always decode serially.
+	// On ARM64, arm64asm.Decode is not safe for concurrent use: workers
+	// cache the instructions they decode and only serialise the decoding
+	// of instruction words they have not seen yet.
+	Concurrency int
+
+	// ChunkSize is the size in bytes of the chunks decoded in parallel,
+	// rounded up to a multiple of 4. Code no larger than one chunk is
+	// decoded serially. Zero selects a default of 1 MiB.
+	ChunkSize int
+
+	// WindowSize, when positive, makes the FromELF variants read each
+	// section from the underlying reader in windows of WindowSize bytes
+	// (rounded up to a multiple of 4) instead of loading it whole, bounding
+	// the memory used for code regardless of the section size. Windowed
+	// sections are decoded serially; Concurrency does not apply to them.
+	// Passing a memory-mapped io.ReaderAt, such as
+	// golang.org/x/exp/mmap.ReaderAt, decodes directly from the mapping.
+	WindowSize int
+
+	// Progress, if set, is called as detection advances, with the bytes
+	// decoded so far in the current phase. It is never called concurrently.
+	Progress func(Progress)
+
+	// Logger receives debug diagnostics. A nil Logger discards them.
+	Logger *slog.Logger
+
+	// section is the name of the ELF section being analyzed, used to label
+	// progress reports.
+	section string
+
+	// kernel enables Linux kernel mode on x86-64 when non-nil.
+	kernel *kernelThunks
+
+	// noreturn holds the addresses that calls never return from. The
+	// FromELF variants seed it with the known non-returning functions
+	// of the binary.
+	noreturn noReturnSet
+}
+
+// defaultSections is the set of ELF sections analyzed when Options.Sections
+// is empty.
+var defaultSections = []string{".text"}
+
+func (o *Options) sections() []string {
+	if len(o.Sections) == 0 {
+		return defaultSections
+	}
+	return o.Sections
+}
+
+func (o *Options) chunkSize() int {
+	if o.ChunkSize <= 0 {
+		return defaultChunkSize
+	}
+	// Keep chunk boundaries on ARM64 instruction boundaries.
+	return (o.ChunkSize + 3) &^ 3
+}
+
+func (o *Options) windowSize() int {
+	if o.WindowSize <= 0 {
+		return 0
+	}
+	// Keep window boundaries on ARM64 instruction boundaries.
+	return (o.WindowSize + 3) &^ 3
+}
+
+func (o *Options) logger() *slog.Logger {
+	if o.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return o.Logger
+}
+
+func (o *Options) uses(src EvidenceSource) bool {
+	return len(o.Sources) == 0 || slices.Contains(o.Sources, src)
+}
+
+func (o *Options) inRanges(addr uint64) bool {
+	if len(o.Ranges) == 0 {
+		return true
+	}
+	for _, r := range o.Ranges {
+		if r.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *Options) meetsConfidence(c Confidence) bool {
+	if o.MinConfidence == "" {
+		return true
+	}
+	return c.rank() >= o.MinConfidence.rank()
+}
+
+// rank orders confidence levels from ConfidenceNone (0) to ConfidenceHigh (3).
+func (c Confidence) rank() int {
+	switch c {
+	case ConfidenceHigh:
+		return 3
+	case ConfidenceMedium:
+		return 2
+	case ConfidenceLow:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (o *Options) filterPrologues(prologues []Prologue) []Prologue {
+	if len(o.Ranges) == 0 {
+		return prologues
+	}
+	return slices.DeleteFunc(prologues, func(p Prologue) bool {
+		return !o.inRanges(p.Address)
+	})
+}
+
+func (o *Options) filterCallSites(edges []CallSiteEdge) []CallSiteEdge {
+	if len(o.Ranges) == 0 && o.MinConfidence == "" {
+		return edges
+	}
+	return slices.DeleteFunc(edges, func(e CallSiteEdge) bool {
+		return !o.inRanges(e.SourceAddr) || !o.meetsConfidence(e.Confidence)
+	})
+}
+
+func (o *Options) filterGaps(gaps []Gap) []Gap {
+	if len(o.Ranges) == 0 {
+		return gaps
+	}
+	return slices.DeleteFunc(gaps, func(g Gap) bool {
+		return !o.inRanges(g.Address)
+	})
+}
+
+func (o *Options) filterCandidates(candidates []FunctionCandidate) []FunctionCandidate {
+	if len(o.Ranges) == 0 && o.MinConfidence == "" {
+		return candidates
+	}
+	return slices.DeleteFunc(candidates, func(c FunctionCandidate) bool {
+		return !o.inRanges(c.Address) || !o.meetsConfidence(c.Confidence)
+	})
+}
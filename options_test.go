@@ -0,0 +1,207 @@
+package resurgo_test
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+func TestDetectCallSitesWithOptions_ConditionalJumps(t *testing.T) {
+	tests := []struct {
+		name       string
+		arch       resurgo.Arch
+		code       []byte
+		baseAddr   uint64
+		wantTarget uint64
+	}{
+		{
+			// je $+0x10 (rel8 = 0x0E, instruction length = 2)
+			name:       "amd64/je-rel8",
+			arch:       resurgo.ArchAMD64,
+			code:       []byte{0x74, 0x0E},
+			wantTarget: 0x10,
+		},
+		{
+			// jne rel32 (0F 85 <rel32>), target = 0 + 6 + 0x1A = 0x20
+			name:       "amd64/jne-rel32",
+			arch:       resurgo.ArchAMD64,
+			code:       []byte{0x0F, 0x85, 0x1A, 0x00, 0x00, 0x00},
+			wantTarget: 0x20,
+		},
+		{
+			// b.eq +0x20
+			name:       "arm64/b.eq",
+			arch:       resurgo.ArchARM64,
+			code:       arm64Insn(0x54000100),
+			baseAddr:   0x1000,
+			wantTarget: 0x1020,
+		},
+		{
+			// cbz x0, +0x10
+			name:       "arm64/cbz",
+			arch:       resurgo.ArchARM64,
+			code:       arm64Insn(0xb4000080),
+			baseAddr:   0x1000,
+			wantTarget: 0x1010,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edges, err := resurgo.DetectCallSites(tt.code, tt.baseAddr, tt.arch)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(edges) != 0 {
+				t.Fatalf("expected no edges without options, got %+v", edges)
+			}
+
+			opts := resurgo.Options{IncludeConditionalJumps: true}
+			edges, err = resurgo.DetectCallSitesWithOptions(tt.code, tt.baseAddr, tt.arch, opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(edges) != 1 {
+				t.Fatalf("expected 1 edge, got %d: %+v", len(edges), edges)
+			}
+			if edges[0].Type != resurgo.CallSiteJump {
+				t.Errorf("expected type jump, got %s", edges[0].Type)
+			}
+			if edges[0].Confidence != resurgo.ConfidenceLow {
+				t.Errorf("expected low confidence, got %s", edges[0].Confidence)
+			}
+			if edges[0].TargetAddr != tt.wantTarget {
+				t.Errorf("expected target 0x%x, got 0x%x", tt.wantTarget, edges[0].TargetAddr)
+			}
+		})
+	}
+}
+
+func TestDetectFunctionsWithOptions(t *testing.T) {
+	code, base := buildSyntheticAMD64()
+
+	all, err := resurgo.DetectFunctions(code, base, resurgo.ArchAMD64)
+	if err != nil {
+		t.Fatalf("DetectFunctions: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		opts  resurgo.Options
+		check func(t *testing.T, candidates []resurgo.FunctionCandidate)
+	}{
+		{
+			name: "zero-value",
+			opts: resurgo.Options{},
+			check: func(t *testing.T, candidates []resurgo.FunctionCandidate) {
+				if len(candidates) != len(all) {
+					t.Errorf("expected %d candidates, got %d", len(all), len(candidates))
+				}
+			},
+		},
+		{
+			name: "prologues-only",
+			opts: resurgo.Options{Sources: []resurgo.EvidenceSource{resurgo.EvidencePrologue}},
+			check: func(t *testing.T, candidates []resurgo.FunctionCandidate) {
+				for _, c := range candidates {
+					if c.DetectionType != resurgo.DetectionPrologueOnly {
+						t.Errorf("0x%x: expected prologue-only, got %s", c.Address, c.DetectionType)
+					}
+				}
+			},
+		},
+		{
+			name: "call-sites-only",
+			opts: resurgo.Options{Sources: []resurgo.EvidenceSource{resurgo.EvidenceCallSite}},
+			check: func(t *testing.T, candidates []resurgo.FunctionCandidate) {
+				for _, c := range candidates {
+					if c.PrologueType != "" {
+						t.Errorf("0x%x: unexpected prologue type %s", c.Address, c.PrologueType)
+					}
+				}
+			},
+		},
+		{
+			name: "min-confidence-high",
+			opts: resurgo.Options{MinConfidence: resurgo.ConfidenceHigh},
+			check: func(t *testing.T, candidates []resurgo.FunctionCandidate) {
+				if len(candidates) == 0 {
+					t.Fatal("expected at least one high-confidence candidate")
+				}
+				for _, c := range candidates {
+					if c.Confidence != resurgo.ConfidenceHigh {
+						t.Errorf("0x%x: expected high confidence, got %s", c.Address, c.Confidence)
+					}
+				}
+			},
+		},
+		{
+			name: "address-range",
+			opts: resurgo.Options{Ranges: []resurgo.AddressRange{{Start: base + 0x100, End: base + 0x200}}},
+			check: func(t *testing.T, candidates []resurgo.FunctionCandidate) {
+				if len(candidates) == 0 {
+					t.Fatal("expected at least one candidate in range")
+				}
+				for _, c := range candidates {
+					if c.Address < base+0x100 || c.Address >= base+0x200 {
+						t.Errorf("0x%x: candidate outside range", c.Address)
+					}
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidates, err := resurgo.DetectFunctionsWithOptions(code, base, resurgo.ArchAMD64, tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tt.check(t, candidates)
+		})
+	}
+}
+
+func TestDetectFunctionsFromELFWithOptions(t *testing.T) {
+	binPath := filepath.Join(t.TempDir(), demoAppBinary)
+	cmd := exec.Command("go", "build", "-o", binPath, demoAppSource)
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOARCH=amd64")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile demo-app: %v\n%s", err, out)
+	}
+
+	f, err := os.Open(binPath)
+	if err != nil {
+		t.Fatalf("failed to open compiled binary: %v", err)
+	}
+	defer f.Close()
+
+	t.Run("logger", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+		candidates, err := resurgo.DetectFunctionsFromELFWithOptions(f, resurgo.Options{Logger: logger})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(candidates) == 0 {
+			t.Fatal("expected at least one function candidate, got none")
+		}
+		if !strings.Contains(buf.String(), "section=.text") {
+			t.Errorf("expected debug log for .text section, got:\n%s", buf.String())
+		}
+	})
+
+	t.Run("missing-section", func(t *testing.T) {
+		_, err := resurgo.DetectFunctionsFromELFWithOptions(f, resurgo.Options{Sections: []string{".nonexistent"}})
+		if err == nil {
+			t.Fatal("expected error for missing section, got nil")
+		}
+	})
+}
@@ -0,0 +1,277 @@
+package resurgo
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+
+	"golang.org/x/arch/arm64/arm64asm"
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// PaddingKind represents the instructions filling a gap between functions.
+type PaddingKind string
+
+// Recognized padding kinds.
+const (
+	// PaddingTrap is a run of trapping instructions: int3 on x86-64, udf
+	// on ARM64.
+	PaddingTrap PaddingKind = "trap"
+	// PaddingNOP is a run of single or multi-byte NOPs.
+	PaddingNOP PaddingKind = "nop"
+)
+
+// Gap is a run of padding instructions inserted by the compiler or linker
+// between two functions, to align the entry of the second.
+type Gap struct {
+	Address uint64      `json:"address"`
+	Size    uint64      `json:"size"`
+	Kind    PaddingKind `json:"kind"`
+}
+
+// End returns the address following the gap, which is the entry of the next
+// function.
+func (g Gap) End() uint64 {
+	return g.Address + g.Size
+}
+
+// functionAlignment returns the alignment of function entries emitted by
+// compilers for arch. Padding is only taken as evidence of a function entry
+// when it ends on such an address.
+func functionAlignment(arch Arch) uint64 {
+	if arch == ArchAMD64 {
+		return 16
+	}
+	// ARM64 instructions are 4-byte aligned, and the entries of functions
+	// built without -falign-functions are not aligned any further.
+	return 4
+}
+
+// DetectPadding analyzes raw machine code bytes and returns the padding runs
+// found between functions. Trap padding (int3, udf) is always reported; NOP
+// padding is only reported when it follows an instruction that does not fall
+// through (ret, unconditional jump, trap), as NOPs also align loop heads
+// within functions. Padding at the end of code is not reported.
+func DetectPadding(code []byte, baseAddr uint64, arch Arch) ([]Gap, error) {
+	return DetectPaddingWithOptions(code, baseAddr, arch, Options{})
+}
+
+// DetectPaddingWithOptions is like DetectPadding but applies the address
+// range restrictions in opts to the gap address.
+func DetectPaddingWithOptions(code []byte, baseAddr uint64, arch Arch, opts Options) ([]Gap, error) {
+	return DetectPaddingContext(context.Background(), code, baseAddr, arch, opts)
+}
+
+// DetectPaddingContext is like DetectPaddingWithOptions but stops with the
+// context error as soon as ctx is done, and reports progress to
+// opts.Progress.
+func DetectPaddingContext(ctx context.Context, code []byte, baseAddr uint64, arch Arch, opts Options) ([]Gap, error) {
+	gaps, err := detectPadding(ctx, memSource(code), baseAddr, arch, opts)
+	if err != nil {
+		return nil, err
+	}
+	return opts.filterGaps(gaps), nil
+}
+
+func detectPadding(ctx context.Context, src source, baseAddr uint64, arch Arch, opts Options) ([]Gap, error) {
+	newSweeper, err := paddingSweepers(src.code, baseAddr, arch, opts)
+	if err != nil {
+		return nil, err
+	}
+	gaps, err := sweepAll(src, newSweeper, opts, newTracker(ctx, opts, PhasePadding, src.size))
+	if err != nil {
+		return nil, err
+	}
+	opts.logger().Debug("detected padding",
+		"arch", arch, "base", baseAddr, "size", src.size, "count", len(gaps))
+
+	return gaps, nil
+}
+
+// paddingSweepers returns a constructor of padding sweepers over code,
+// starting at a given offset, for the given architecture.
+func paddingSweepers(code []byte, baseAddr uint64, arch Arch, opts Options) (func(start int) sweeper[Gap], error) {
+	switch arch {
+	case ArchAMD64:
+		return func(start int) sweeper[Gap] {
+			return &paddingSweeper{code: code, baseAddr: baseAddr, offset: start, decode: decodePaddingAMD64, noreturn: opts.noreturn}
+		}, nil
+	case ArchARM64:
+		return func(start int) sweeper[Gap] {
+			return &paddingSweeper{code: code, baseAddr: baseAddr, offset: start, decode: decodePaddingARM64, noreturn: opts.noreturn}
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported architecture: %s", arch)
+	}
+}
+
+// paddingDecoder decodes the instruction at the start of code, at addr, and
+// returns its length, the padding kind it belongs to, if any, and whether it
+// ends the flow of execution (no fall-through to the next instruction). Calls
+// to the addresses in noreturn end the flow of execution. ARM64 code is
+// decoded through dec.
+type paddingDecoder func(code []byte, addr uint64, noreturn noReturnSet, dec *arm64Decoder) (n int, kind PaddingKind, terminal bool)
+
+// decodePaddingAMD64 is the paddingDecoder of x86-64.
+func decodePaddingAMD64(code []byte, addr uint64, noreturn noReturnSet, _ *arm64Decoder) (int, PaddingKind, bool) {
+	// ENDBR64 and ENDBR32 are not recognised by x86asm.
+	if len(code) >= 4 && code[0] == 0xf3 && code[1] == 0x0f && code[2] == 0x1e &&
+		(code[3] == 0xfa || code[3] == 0xfb) {
+		return 4, "", false
+	}
+	if code[0] == 0xcc {
+		return 1, PaddingTrap, true
+	}
+
+	inst, err := x86asm.Decode(code, 64)
+	if err != nil {
+		return 1, "", false
+	}
+	switch inst.Op {
+	case x86asm.NOP:
+		return inst.Len, PaddingNOP, false
+	case x86asm.RET, x86asm.JMP, x86asm.UD2, x86asm.HLT:
+		return inst.Len, "", true
+	case x86asm.CALL:
+		return inst.Len, "", noreturn.callAMD64(inst, addr)
+	}
+	return inst.Len, "", false
+}
+
+// decodePaddingARM64 is the paddingDecoder of ARM64.
+func decodePaddingARM64(code []byte, addr uint64, noreturn noReturnSet, dec *arm64Decoder) (int, PaddingKind, bool) {
+	const insnLen = 4
+
+	if len(code) < insnLen {
+		return len(code), "", false
+	}
+	// udf #imm16 is 0x0000xxxx, and is not recognised by arm64asm.
+	if binary.LittleEndian.Uint32(code)>>16 == 0 {
+		return insnLen, PaddingTrap, true
+	}
+
+	inst, err := dec.decode(code[:insnLen])
+	if err != nil {
+		return insnLen, "", false
+	}
+	switch inst.Op {
+	case arm64asm.NOP:
+		return insnLen, PaddingNOP, false
+	case arm64asm.RET, arm64asm.BR, arm64asm.BRK:
+		return insnLen, "", true
+	case arm64asm.BL:
+		return insnLen, "", noreturn.callARM64(binary.LittleEndian.Uint32(code), addr)
+	case arm64asm.B:
+		// B.cond carries a Cond argument and falls through.
+		for _, arg := range inst.Args {
+			if _, ok := arg.(arm64asm.Cond); ok {
+				return insnLen, "", false
+			}
+		}
+		return insnLen, "", true
+	}
+	return insnLen, "", false
+}
+
+// paddingSweeper detects padding runs one instruction at a time.
+type paddingSweeper struct {
+	code     []byte
+	baseAddr uint64
+	offset   int
+	decode   paddingDecoder
+	noreturn noReturnSet
+	// terminal is set when the previous instruction does not fall through.
+	terminal bool
+	// run is the padding run in progress, if run.Size is non-zero. It is
+	// reported only if between is set, that is if it follows a terminal
+	// instruction or is made of traps.
+	run     Gap
+	between bool
+	// dec decodes ARM64 instructions; it is nil in serial sweeps.
+	dec *arm64Decoder
+}
+
+func (s *paddingSweeper) setDecoder(d *arm64Decoder) { s.dec = d }
+
+func (s *paddingSweeper) pos() int { return s.offset }
+
+func (s *paddingSweeper) shift(code []byte) {
+	s.baseAddr += uint64(s.offset)
+	s.code, s.offset = code, 0
+}
+
+func (s *paddingSweeper) step(out []Gap) ([]Gap, bool) {
+	addr := s.baseAddr + uint64(s.offset)
+	n, kind, terminal := s.decode(s.code[s.offset:], addr, s.noreturn, s.dec)
+	s.offset += n
+
+	if s.run.Size > 0 && kind != s.run.Kind {
+		// The run ends: a trap run ends the flow of execution, so a
+		// following NOP run is padding as well.
+		if s.between {
+			out = append(out, s.run)
+		}
+		s.terminal = s.run.Kind == PaddingTrap
+		s.run = Gap{}
+	}
+
+	if kind != "" {
+		if s.run.Size == 0 {
+			s.run = Gap{Address: addr, Kind: kind}
+			s.between = s.terminal || kind == PaddingTrap
+		}
+		s.run.Size += uint64(n)
+		return out, false
+	}
+
+	s.terminal = terminal
+	return out, true
+}
+
+// DetectPaddingFromELF parses an ELF binary from the given reader, extracts
+// the .text section, and returns the padding runs found between functions.
+// The architecture is inferred from the ELF header.
+func DetectPaddingFromELF(r io.ReaderAt) ([]Gap, error) {
+	return DetectPaddingFromELFWithOptions(r, Options{})
+}
+
+// DetectPaddingFromELFWithOptions is like DetectPaddingFromELF but analyzes
+// the sections listed in opts and applies its address range restrictions.
+// Results are sorted by address.
+func DetectPaddingFromELFWithOptions(r io.ReaderAt, opts Options) ([]Gap, error) {
+	return DetectPaddingFromELFContext(context.Background(), r, opts)
+}
+
+// DetectPaddingFromELFContext is like DetectPaddingFromELFWithOptions but
+// stops with the context error as soon as ctx is done, and reports progress
+// to opts.Progress.
+func DetectPaddingFromELFContext(ctx context.Context, r io.ReaderAt, opts Options) ([]Gap, error) {
+	arch, sections, err := readELFSections(r, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Gap
+	for _, sec := range sections {
+		opts.logger().Debug("analyzing ELF section",
+			"section", sec.name, "addr", sec.addr, "size", sec.src.size)
+
+		opts.section = sec.name
+		gaps, err := detectPadding(ctx, sec.src, sec.addr, arch, opts)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, opts.filterGaps(gaps)...)
+	}
+
+	if len(sections) > 1 {
+		slices.SortStableFunc(result, func(a, b Gap) int {
+			return cmp.Compare(a.Address, b.Address)
+		})
+	}
+
+	return result, nil
+}
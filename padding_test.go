@@ -0,0 +1,195 @@
+package resurgo_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+func TestDetectPaddingAMD64(t *testing.T) {
+	// AMD64 instruction encodings:
+	// ret                        = 0xc3
+	// int3                       = 0xcc
+	// nop                        = 0x90
+	// nop word cs:[rax+rax+0x0]  = 0x66 0x2e 0x0f 0x1f 0x84 0x00 0x00 0x00 0x00 0x00
+	// push rbp                   = 0x55
+	// mov rbp, rsp               = 0x48 0x89 0xe5
+	// xor eax, eax               = 0x31 0xc0
+	nopw := []byte{0x66, 0x2e, 0x0f, 0x1f, 0x84, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+	tests := []struct {
+		name string
+		code []byte
+		want []resurgo.Gap
+	}{
+		{
+			// ret; int3 x3; push rbp
+			name: "int3",
+			code: []byte{0xc3, 0xcc, 0xcc, 0xcc, 0x55},
+			want: []resurgo.Gap{{Address: 0x1001, Size: 3, Kind: resurgo.PaddingTrap}},
+		},
+		{
+			// ret; nop; nopw; push rbp
+			name: "multi-byte-nop",
+			code: append(append([]byte{0xc3, 0x90}, nopw...), 0x55),
+			want: []resurgo.Gap{{Address: 0x1001, Size: 11, Kind: resurgo.PaddingNOP}},
+		},
+		{
+			// xor eax, eax; nopw; xor eax, eax  - loop head alignment
+			// within a function is not padding.
+			name: "nop-after-fall-through",
+			code: append(append([]byte{0x31, 0xc0}, nopw...), 0x31, 0xc0),
+		},
+		{
+			// ret; int3; nop; push rbp  - a NOP run after traps.
+			name: "int3-then-nop",
+			code: []byte{0xc3, 0xcc, 0x90, 0x55},
+			want: []resurgo.Gap{
+				{Address: 0x1001, Size: 1, Kind: resurgo.PaddingTrap},
+				{Address: 0x1002, Size: 1, Kind: resurgo.PaddingNOP},
+			},
+		},
+		{
+			// ret; int3 x2  - trailing padding is not reported.
+			name: "trailing",
+			code: []byte{0xc3, 0xcc, 0xcc},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gaps, err := resurgo.DetectPadding(tt.code, 0x1000, resurgo.ArchAMD64)
+			if err != nil {
+				t.Fatalf("DetectPadding: %v", err)
+			}
+			if !slices.Equal(gaps, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, gaps)
+			}
+		})
+	}
+}
+
+func TestDetectPaddingARM64(t *testing.T) {
+	// ret; nop; udf #0; stp x29, x30, [sp, #-16]!
+	code := []byte{
+		0xc0, 0x03, 0x5f, 0xd6,
+		0x1f, 0x20, 0x03, 0xd5,
+		0x00, 0x00, 0x00, 0x00,
+		0xfd, 0x7b, 0xbf, 0xa9,
+	}
+	gaps, err := resurgo.DetectPadding(code, 0x1000, resurgo.ArchARM64)
+	if err != nil {
+		t.Fatalf("DetectPadding: %v", err)
+	}
+	want := []resurgo.Gap{
+		{Address: 0x1004, Size: 4, Kind: resurgo.PaddingNOP},
+		{Address: 0x1008, Size: 4, Kind: resurgo.PaddingTrap},
+	}
+	if !slices.Equal(gaps, want) {
+		t.Errorf("expected %+v, got %+v", want, gaps)
+	}
+
+	// Parallel decoding yields the same gaps.
+	code = bytes.Repeat(code, 64)
+	serial, err := resurgo.DetectPadding(code, 0x1000, resurgo.ArchARM64)
+	if err != nil {
+		t.Fatalf("DetectPadding: %v", err)
+	}
+	parallel, err := resurgo.DetectPaddingWithOptions(code, 0x1000, resurgo.ArchARM64, resurgo.Options{Concurrency: 4, ChunkSize: 16})
+	if err != nil {
+		t.Fatalf("DetectPaddingWithOptions: %v", err)
+	}
+	if len(serial) != 128 || !slices.Equal(serial, parallel) {
+		t.Errorf("parallel decoding: expected %d gaps, got %d", len(serial), len(parallel))
+	}
+}
+
+func TestDetectPadding_PushOnlyAfterPadding(t *testing.T) {
+	// ret; int3; push rbx  - the push follows padding, not a RET.
+	code := []byte{0xc3, 0xcc, 0x53}
+	prologues, err := resurgo.DetectPrologues(code, 0x1000, resurgo.ArchAMD64)
+	if err != nil {
+		t.Fatalf("DetectPrologues: %v", err)
+	}
+	if len(prologues) != 1 || prologues[0].Type != resurgo.ProloguePushOnly || prologues[0].Address != 0x1002 {
+		t.Errorf("expected push-only prologue at 0x1002, got %+v", prologues)
+	}
+}
+
+func TestDetectFunctions_Padding(t *testing.T) {
+	// 0x1000: push rbp; mov rbp, rsp; ret; int3 x11
+	// 0x1010: push rbp; mov rbp, rsp; ret; int3 x11
+	// 0x1020: xor eax, eax; ret  - no prologue, never called
+	fn := append([]byte{0x55, 0x48, 0x89, 0xe5, 0xc3}, bytes.Repeat([]byte{0xcc}, 11)...)
+	code := slices.Concat(fn, fn, []byte{0x31, 0xc0, 0xc3})
+
+	candidates, err := resurgo.DetectFunctions(code, 0x1000, resurgo.ArchAMD64)
+	if err != nil {
+		t.Fatalf("DetectFunctions: %v", err)
+	}
+	byAddr := make(map[uint64]resurgo.FunctionCandidate)
+	for _, c := range candidates {
+		byAddr[c.Address] = c
+	}
+
+	if c := byAddr[0x1010]; c.DetectionType != resurgo.DetectionPrologueOnly ||
+		!c.AfterPadding || c.Confidence != resurgo.ConfidenceHigh {
+		t.Errorf("0x1010: expected high-confidence prologue after padding, got %+v", c)
+	}
+	if c := byAddr[0x1020]; c.DetectionType != resurgo.DetectionPadding ||
+		!c.AfterPadding || c.Confidence != resurgo.ConfidenceLow {
+		t.Errorf("0x1020: expected low-confidence padding candidate, got %+v", c)
+	}
+
+	without, err := resurgo.DetectFunctionsWithOptions(code, 0x1000, resurgo.ArchAMD64, resurgo.Options{
+		Sources: []resurgo.EvidenceSource{resurgo.EvidencePrologue, resurgo.EvidenceCallSite},
+	})
+	if err != nil {
+		t.Fatalf("DetectFunctionsWithOptions: %v", err)
+	}
+	for _, c := range without {
+		if c.AfterPadding || c.Confidence != resurgo.ConfidenceMedium {
+			t.Errorf("0x%x: unexpected padding evidence: %+v", c.Address, c)
+		}
+	}
+}
+
+func TestDetectPaddingFromELF_C(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found, skipping")
+	}
+
+	binPath := filepath.Join(t.TempDir(), "demo-app-c")
+	cmd := exec.Command("gcc", "-O2", "-o", binPath, "testdata/demo-app.c")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile testdata/demo-app.c: %v\n%s", err, out)
+	}
+
+	f, err := os.Open(binPath)
+	if err != nil {
+		t.Fatalf("failed to open compiled binary: %v", err)
+	}
+	defer f.Close()
+
+	gaps, err := resurgo.DetectPaddingFromELF(f)
+	if err != nil {
+		t.Fatalf("DetectPaddingFromELF: %v", err)
+	}
+	if len(gaps) == 0 {
+		t.Fatal("expected padding between functions, got none")
+	}
+
+	// Parallel decoding yields the same gaps.
+	parallel, err := resurgo.DetectPaddingFromELFWithOptions(f, resurgo.Options{Concurrency: 4, ChunkSize: 64})
+	if err != nil {
+		t.Fatalf("DetectPaddingFromELFWithOptions: %v", err)
+	}
+	if !slices.Equal(gaps, parallel) {
+		t.Errorf("parallel decoding: expected %d gaps, got %d", len(gaps), len(parallel))
+	}
+}
@@ -0,0 +1,90 @@
+package resurgo
+
+import (
+	"debug/pe"
+	"fmt"
+	"io"
+)
+
+// peImage is the Image of a PE executable or DLL, made of its sections.
+type peImage struct {
+	arch     Arch
+	sections []*pe.Section
+	segments []ImageSegment
+}
+
+// peArch maps a PE machine to the corresponding architecture.
+func peArch(m uint16) (Arch, error) {
+	switch m {
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		return ArchAMD64, nil
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		return ArchARM64, nil
+	default:
+		return "", fmt.Errorf("unsupported PE machine: 0x%x", m)
+	}
+}
+
+// NewPEImage parses a PE executable or DLL from r and returns its Image,
+// with a segment for each section, loaded at the image base. Sections
+// marked IMAGE_SCN_MEM_EXECUTE are executable.
+func NewPEImage(r io.ReaderAt) (Image, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PE file: %w", err)
+	}
+
+	arch, err := peArch(f.Machine)
+	if err != nil {
+		return nil, err
+	}
+
+	var base uint64
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader64:
+		base = oh.ImageBase
+	case *pe.OptionalHeader32:
+		base = uint64(oh.ImageBase)
+	default:
+		return nil, fmt.Errorf("missing PE optional header")
+	}
+
+	img := &peImage{arch: arch}
+	for _, sec := range f.Sections {
+		// The raw data of a section is padded to the file alignment, and
+		// the virtual size is the actual size of the contents.
+		size := uint64(sec.VirtualSize)
+		if size == 0 {
+			size = uint64(sec.Size)
+		}
+		perms := []byte("---")
+		if sec.Characteristics&pe.IMAGE_SCN_MEM_READ != 0 {
+			perms[0] = 'r'
+		}
+		if sec.Characteristics&pe.IMAGE_SCN_MEM_WRITE != 0 {
+			perms[1] = 'w'
+		}
+		if sec.Characteristics&pe.IMAGE_SCN_MEM_EXECUTE != 0 {
+			perms[2] = 'x'
+		}
+		img.sections = append(img.sections, sec)
+		img.segments = append(img.segments, ImageSegment{
+			Name:  sec.Name,
+			Addr:  base + uint64(sec.VirtualAddress),
+			Size:  size,
+			Perms: string(perms),
+		})
+	}
+
+	return img, nil
+}
+
+func (img *peImage) Arch() Arch { return img.arch }
+
+func (img *peImage) Segments() []ImageSegment { return img.segments }
+
+func (img *peImage) SegmentReader(i int) (io.ReaderAt, error) {
+	sec := img.sections[i]
+	size := min(uint64(sec.Size), img.segments[i].Size)
+	return io.NewSectionReader(sec, 0, int64(size)), nil
+}
@@ -0,0 +1,125 @@
+package resurgo
+
+import (
+	"bufio"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// PerfMapPath returns the path where perf looks for the perf map of the
+// process with the given PID: /tmp/perf-<pid>.map.
+func PerfMapPath(pid int) string {
+	return fmt.Sprintf("/tmp/perf-%d.map", pid)
+}
+
+// WritePerfMap writes the functions of mappings to w in the perf map
+// format read by perf report for code perf cannot symbolize, such as
+// anonymous and JIT-compiled code: one "START SIZE NAME" line per function,
+// with start and size in hexadecimal.
+//
+// Functions are named after the candidate, or sub_ followed by their
+// address, and sized after the candidate, or up to the next candidate or
+// the end of the mapping. Addresses are written as they are, so the
+// candidates must hold run-time addresses, as those returned by
+// DetectFunctionsFromProcess.
+func WritePerfMap(w io.Writer, mappings []MappingFunctions) error {
+	bw := bufio.NewWriter(w)
+	for _, mf := range mappings {
+		candidates := sortedFunctions(mf.Functions)
+		for i, c := range candidates {
+			fmt.Fprintf(bw, "%x %x %s\n", c.Address, functionSize(candidates, i, mf.Mapping.End), symbolName(c))
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write perf map: %w", err)
+	}
+	return nil
+}
+
+// jitdump format constants, from tools/perf/Documentation/jitdump-specification.txt
+// in the Linux sources.
+const (
+	jitdumpMagic      = 0x4A695444 // "JiTD"
+	jitdumpVersion    = 1
+	jitdumpHeaderSize = 40
+	jitCodeLoad       = 0
+	// jitCodeLoadSize is the size of a JIT_CODE_LOAD record before the
+	// function name and code: the record header, pid, tid, vma, code_addr,
+	// code_size and code_index.
+	jitCodeLoadSize = 16 + 4 + 4 + 8 + 8 + 8 + 8
+	// maxJITCodeSize bounds the code of a JIT_CODE_LOAD record: longer
+	// functions, usually sized up to the end of their mapping, are
+	// truncated.
+	maxJITCodeSize = 1 << 20
+)
+
+// WriteJITDump writes the functions of mappings to w in the jitdump format
+// read by perf inject --jit, as a JIT_CODE_LOAD record per function holding
+// its name and code, named and sized as by WritePerfMap. The code is read
+// from mem at the address of each function, as from /proc/<pid>/mem, up
+// to 1 MiB. Functions at addresses past the largest offset of mem, such as
+// those of [vsyscall], are skipped.
+//
+// Records are timestamped 0, as loaded before any sample. perf inject
+// only reads the dump of a process recorded while it mapped the file, so
+// the dump is usually written to jit-<pid>.dump by the process itself.
+func WriteJITDump(w io.Writer, pid int, arch Arch, mem io.ReaderAt, mappings []MappingFunctions) error {
+	var mach elf.Machine
+	switch arch {
+	case ArchAMD64:
+		mach = elf.EM_X86_64
+	case ArchARM64:
+		mach = elf.EM_AARCH64
+	default:
+		return fmt.Errorf("unsupported architecture: %s", arch)
+	}
+
+	bw := bufio.NewWriter(w)
+	le := binary.LittleEndian
+	var header [jitdumpHeaderSize]byte
+	le.PutUint32(header[0:], jitdumpMagic)
+	le.PutUint32(header[4:], jitdumpVersion)
+	le.PutUint32(header[8:], jitdumpHeaderSize)
+	le.PutUint32(header[12:], uint32(mach))
+	le.PutUint32(header[20:], uint32(pid))
+	bw.Write(header[:])
+
+	var index uint64
+	for _, mf := range mappings {
+		candidates := sortedFunctions(mf.Functions)
+		for i, c := range candidates {
+			if c.Address > math.MaxInt64 {
+				continue
+			}
+			size := min(functionSize(candidates, i, mf.Mapping.End), maxJITCodeSize, math.MaxInt64-c.Address)
+			code := make([]byte, size)
+			if _, err := mem.ReadAt(code, int64(c.Address)); err != nil {
+				return fmt.Errorf("failed to read function 0x%x: %w", c.Address, err)
+			}
+			name := symbolName(c)
+
+			var rec [jitCodeLoadSize]byte
+			le.PutUint32(rec[0:], jitCodeLoad)
+			le.PutUint32(rec[4:], uint32(jitCodeLoadSize+len(name)+1+len(code)))
+			le.PutUint32(rec[16:], uint32(pid))
+			le.PutUint32(rec[20:], uint32(pid))
+			le.PutUint64(rec[24:], c.Address)
+			le.PutUint64(rec[32:], c.Address)
+			le.PutUint64(rec[40:], size)
+			le.PutUint64(rec[48:], index)
+			index++
+
+			bw.Write(rec[:])
+			bw.WriteString(name)
+			bw.WriteByte(0)
+			bw.Write(code)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write jitdump: %w", err)
+	}
+	return nil
+}
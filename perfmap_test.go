@@ -0,0 +1,138 @@
+package resurgo_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+// testPerfMappings returns two mappings of functions, the first holding
+// unsorted candidates, one of them named and sized.
+func testPerfMappings() []resurgo.MappingFunctions {
+	return []resurgo.MappingFunctions{
+		{
+			Mapping: resurgo.Mapping{Start: 0x1000, End: 0x1100, Perms: "r-xp"},
+			Functions: []resurgo.FunctionCandidate{
+				{Address: 0x1040},
+				{Address: 0x1000, Name: "main", Size: 0x20},
+			},
+		},
+		{
+			Mapping:   resurgo.Mapping{Start: 0x2000, End: 0x2010, Perms: "r-xp"},
+			Functions: []resurgo.FunctionCandidate{{Address: 0x2008}},
+		},
+	}
+}
+
+func TestWritePerfMap(t *testing.T) {
+	var buf bytes.Buffer
+	if err := resurgo.WritePerfMap(&buf, testPerfMappings()); err != nil {
+		t.Fatalf("WritePerfMap: %v", err)
+	}
+	want := "1000 20 main\n" +
+		"1040 c0 sub_1040\n" +
+		"2008 8 sub_2008\n"
+	if got := buf.String(); got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+
+	if got := resurgo.PerfMapPath(42); got != "/tmp/perf-42.map" {
+		t.Errorf("PerfMapPath: expected /tmp/perf-42.map, got %s", got)
+	}
+}
+
+func TestWriteJITDump(t *testing.T) {
+	// Memory addressed by virtual address, as /proc/<pid>/mem.
+	mem := make([]byte, 0x2010)
+	for i := range mem {
+		mem[i] = byte(i)
+	}
+
+	var buf bytes.Buffer
+	if err := resurgo.WriteJITDump(&buf, 42, resurgo.ArchAMD64, bytes.NewReader(mem), testPerfMappings()); err != nil {
+		t.Fatalf("WriteJITDump: %v", err)
+	}
+	data := buf.Bytes()
+	le := binary.LittleEndian
+
+	if magic := le.Uint32(data[0:]); magic != 0x4A695444 {
+		t.Fatalf("expected the JiTD magic, got 0x%x", magic)
+	}
+	if size, mach, pid := le.Uint32(data[8:]), le.Uint32(data[12:]), le.Uint32(data[20:]); size != 40 || mach != 62 || pid != 42 {
+		t.Errorf("header: expected size 40, EM_X86_64 and pid 42, got %d, %d and %d", size, mach, pid)
+	}
+
+	type load struct {
+		addr, size, index uint64
+		name              string
+	}
+	want := []load{
+		{0x1000, 0x20, 0, "main"},
+		{0x1040, 0xc0, 1, "sub_1040"},
+		{0x2008, 0x8, 2, "sub_2008"},
+	}
+	rec := data[40:]
+	for _, w := range want {
+		if len(rec) < 56 {
+			t.Fatalf("record %s: truncated dump", w.name)
+		}
+		id, total := le.Uint32(rec[0:]), le.Uint32(rec[4:])
+		vma, size, index := le.Uint64(rec[24:]), le.Uint64(rec[40:]), le.Uint64(rec[48:])
+		if id != 0 || vma != w.addr || size != w.size || index != w.index {
+			t.Errorf("record %s: expected load of 0x%x (%d bytes, index %d), got id %d, 0x%x (%d bytes, index %d)",
+				w.name, w.addr, w.size, w.index, id, vma, size, index)
+		}
+		name := rec[56 : 56+len(w.name)+1]
+		if string(name) != w.name+"\x00" {
+			t.Errorf("record %s: got name %q", w.name, name)
+		}
+		code := rec[56+len(name) : total]
+		if !bytes.Equal(code, mem[w.addr:w.addr+w.size]) {
+			t.Errorf("record %s: expected the function code", w.name)
+		}
+		rec = rec[total:]
+	}
+	if len(rec) != 0 {
+		t.Errorf("expected no more records, got %d bytes", len(rec))
+	}
+}
+
+// zeroMemory is process memory reading as zeros at any offset.
+type zeroMemory struct{}
+
+func (zeroMemory) ReadAt(p []byte, off int64) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestWriteJITDump_Bounds(t *testing.T) {
+	mappings := []resurgo.MappingFunctions{
+		{
+			// Sized far beyond any function.
+			Mapping:   resurgo.Mapping{Start: 0x1000, End: 0x2000, Perms: "r-xp"},
+			Functions: []resurgo.FunctionCandidate{{Address: 0x1000, Size: 1 << 40}},
+		},
+		{
+			// Past the offsets of /proc/<pid>/mem.
+			Mapping:   resurgo.Mapping{Start: 0xffffffffff600000, End: 0xffffffffff601000, Perms: "--xp", Path: "[vsyscall]"},
+			Functions: []resurgo.FunctionCandidate{{Address: 0xffffffffff600000}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := resurgo.WriteJITDump(&buf, 42, resurgo.ArchAMD64, zeroMemory{}, mappings); err != nil {
+		t.Fatalf("WriteJITDump: %v", err)
+	}
+	rec := buf.Bytes()[40:]
+	le := binary.LittleEndian
+	if len(rec) < 56 {
+		t.Fatal("expected a record")
+	}
+	if vma, size := le.Uint64(rec[24:]), le.Uint64(rec[40:]); vma != 0x1000 || size != 1<<20 {
+		t.Errorf("expected 1 MiB of code at 0x1000, got %d bytes at 0x%x", size, vma)
+	}
+	if rest := rec[le.Uint32(rec[4:]):]; len(rest) != 0 {
+		t.Errorf("expected the [vsyscall] function to be skipped, got %d more bytes", len(rest))
+	}
+}
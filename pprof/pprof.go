@@ -0,0 +1,400 @@
+// Package pprof symbolizes pprof profiles of stripped binaries with the
+// functions recovered by resurgo, so that pprof aggregates samples by
+// function rather than by address.
+//
+// Profiles are read and written in the profile.proto format, gzipped or
+// not, with a minimal decoder keeping every field it does not update
+// unchanged.
+package pprof
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/maxgio92/resurgo"
+)
+
+// Field numbers of profile.proto.
+const (
+	profileMapping     = 3
+	profileLocation    = 4
+	profileFunction    = 5
+	profileStringTable = 6
+
+	mappingID           = 1
+	mappingStart        = 2
+	mappingLimit        = 3
+	mappingOffset       = 4
+	mappingFilename     = 5
+	mappingBuildID      = 6
+	mappingHasFunctions = 7
+
+	locationID        = 1
+	locationMappingID = 2
+	locationAddress   = 3
+	locationLine      = 4
+
+	lineFunctionID = 1
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+)
+
+// Mapping is a mapping of a profile, as passed to Options.Open.
+type Mapping struct {
+	// Start and Limit bound the addresses of the mapping, and Offset is
+	// the file offset mapped at Start.
+	Start  uint64
+	Limit  uint64
+	Offset uint64
+	// File is the path of the mapped file, and BuildID its build ID, as
+	// recorded in the profile.
+	File    string
+	BuildID string
+	// Main is set for the first mapping of the profile, which is the main
+	// binary by convention.
+	Main bool
+}
+
+// Options configures SymbolizeWithOptions and SymbolizeContext.
+type Options struct {
+	// Open returns the ELF binary mapped by m, or nil to leave the mapping
+	// unsymbolized. Each binary is opened once, for the mappings sharing
+	// its file and build ID.
+	Open func(m Mapping) (io.ReaderAt, error)
+
+	// Detect configures function recovery.
+	Detect resurgo.Options
+}
+
+// Symbolize reads a profile from r, symbolizes the locations of its main
+// mapping with the functions recovered from binary, and writes it to w.
+func Symbolize(w io.Writer, r io.Reader, binary io.ReaderAt) error {
+	return SymbolizeWithOptions(w, r, Options{
+		Open: func(m Mapping) (io.ReaderAt, error) {
+			if m.Main {
+				return binary, nil
+			}
+			return nil, nil
+		},
+	})
+}
+
+// SymbolizeWithOptions reads a profile from r, symbolizes the locations of
+// the mappings opened by opts.Open with the functions recovered from them,
+// and writes it to w.
+func SymbolizeWithOptions(w io.Writer, r io.Reader, opts Options) error {
+	return SymbolizeContext(context.Background(), w, r, opts)
+}
+
+// SymbolizeContext is like SymbolizeWithOptions but stops with the context
+// error as soon as ctx is done.
+//
+// Mappings already marked as having functions are left unchanged. In the
+// others, each location without lines gets a line in the function
+// containing its address, added to the functions of the profile with the
+// recovered name, or sub_ followed by the address of the function in the
+// binary. The mapping is then marked as having functions. The output is
+// gzipped when the input is, as pprof writes it.
+func SymbolizeContext(ctx context.Context, w io.Writer, r io.Reader, opts Options) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read profile: %w", err)
+	}
+	gzipped := bytes.HasPrefix(data, []byte{0x1f, 0x8b})
+	if gzipped {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to decompress profile: %w", err)
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return fmt.Errorf("failed to decompress profile: %w", err)
+		}
+	}
+
+	prof, err := parseMessage(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse profile: %w", err)
+	}
+	s, err := newSymbolizer(prof)
+	if err != nil {
+		return err
+	}
+	if err := s.symbolize(ctx, opts); err != nil {
+		return err
+	}
+
+	out := s.profile().encode(nil)
+	if gzipped {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(out)
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("failed to compress profile: %w", err)
+		}
+		out = buf.Bytes()
+	}
+	if _, err := w.Write(out); err != nil {
+		return fmt.Errorf("failed to write profile: %w", err)
+	}
+	return nil
+}
+
+// symbolizer holds a profile being symbolized: its fields, with the
+// mappings and locations decoded, by position in prof.
+type symbolizer struct {
+	prof      message
+	mappings  map[int]message
+	locations map[int]message
+	strings   []string
+	stringIDs map[string]uint64
+	functions []message
+	// functionIDs are the IDs of the functions added, by binary and
+	// address in the binary.
+	functionIDs  map[functionKey]uint64
+	nextFunction uint64
+}
+
+type functionKey struct {
+	bin   *binaryFunctions
+	start uint64
+}
+
+// binaryFunctions are the functions recovered from a binary, and its
+// loadable segments.
+type binaryFunctions struct {
+	symbolizer *resurgo.Symbolizer
+	loads      []*elf.Prog
+}
+
+func newSymbolizer(prof message) (*symbolizer, error) {
+	s := &symbolizer{
+		prof:        prof,
+		mappings:    make(map[int]message),
+		locations:   make(map[int]message),
+		stringIDs:   make(map[string]uint64),
+		functionIDs: make(map[functionKey]uint64),
+	}
+	for i, f := range prof {
+		var err error
+		switch f.num {
+		case profileMapping:
+			s.mappings[i], err = parseMessage(f.data)
+		case profileLocation:
+			s.locations[i], err = parseMessage(f.data)
+		case profileFunction:
+			var fn message
+			if fn, err = parseMessage(f.data); err == nil {
+				s.nextFunction = max(s.nextFunction, fn.getUint(functionID))
+			}
+		case profileStringTable:
+			s.stringIDs[string(f.data)] = uint64(len(s.strings))
+			s.strings = append(s.strings, string(f.data))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse profile: %w", err)
+		}
+	}
+	// The string table starts with the empty string, even in a profile
+	// without one.
+	if len(s.strings) == 0 {
+		s.stringID("")
+	}
+	s.nextFunction++
+	return s, nil
+}
+
+// str returns the string of the string table at index i.
+func (s *symbolizer) str(i uint64) string {
+	if i < uint64(len(s.strings)) {
+		return s.strings[i]
+	}
+	return ""
+}
+
+// stringID returns the index of str in the string table, adding it.
+func (s *symbolizer) stringID(str string) uint64 {
+	if id, ok := s.stringIDs[str]; ok {
+		return id
+	}
+	id := uint64(len(s.strings))
+	s.strings = append(s.strings, str)
+	s.stringIDs[str] = id
+	return id
+}
+
+func (s *symbolizer) symbolize(ctx context.Context, opts Options) error {
+	type binaryKey struct{ file, buildID string }
+	binaries := make(map[binaryKey]*binaryFunctions)
+	// byID holds the mappings to symbolize, by mapping ID.
+	type mappingFunctions struct {
+		m   Mapping
+		bin *binaryFunctions
+	}
+	byID := make(map[uint64]mappingFunctions)
+	var ordered []mappingFunctions
+
+	main := true
+	for i := range s.prof {
+		pm, ok := s.mappings[i]
+		if !ok {
+			continue
+		}
+		m := Mapping{
+			Start:   pm.getUint(mappingStart),
+			Limit:   pm.getUint(mappingLimit),
+			Offset:  pm.getUint(mappingOffset),
+			File:    s.str(pm.getUint(mappingFilename)),
+			BuildID: s.str(pm.getUint(mappingBuildID)),
+			Main:    main,
+		}
+		main = false
+		if pm.getUint(mappingHasFunctions) != 0 || opts.Open == nil {
+			continue
+		}
+
+		key := binaryKey{m.File, m.BuildID}
+		bin, seen := binaries[key]
+		if !seen {
+			var err error
+			if bin, err = openBinary(ctx, m, opts); err != nil {
+				return err
+			}
+			binaries[key] = bin
+		}
+		if bin == nil {
+			continue
+		}
+		mf := mappingFunctions{m, bin}
+		byID[pm.getUint(mappingID)] = mf
+		ordered = append(ordered, mf)
+		s.mappings[i] = pm.setUint(mappingHasFunctions, 1)
+	}
+
+	for i := range s.prof {
+		loc, ok := s.locations[i]
+		if !ok || loc.has(locationLine) {
+			continue
+		}
+		addr := loc.getUint(locationAddress)
+		mf, ok := byID[loc.getUint(locationMappingID)]
+		if !ok && loc.getUint(locationMappingID) == 0 {
+			for _, o := range ordered {
+				if addr >= o.m.Start && addr < o.m.Limit {
+					mf, ok = o, true
+					break
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		vaddr, ok := mf.bin.vaddr(mf.m, addr)
+		if !ok {
+			continue
+		}
+		sym, ok := mf.bin.symbolizer.Lookup(vaddr)
+		if !ok {
+			continue
+		}
+		id := s.function(mf.bin, sym)
+		s.locations[i] = loc.appendMessage(locationLine, message{{num: lineFunctionID, wire: wireVarint, varint: id}})
+	}
+	return nil
+}
+
+// function returns the ID of the function of sym in bin, adding it.
+func (s *symbolizer) function(bin *binaryFunctions, sym resurgo.Symbol) uint64 {
+	key := functionKey{bin, sym.Start}
+	if id, ok := s.functionIDs[key]; ok {
+		return id
+	}
+	id := s.nextFunction
+	s.nextFunction++
+	s.functionIDs[key] = id
+	name := s.stringID(sym.Name)
+	s.functions = append(s.functions, message{
+		{num: functionID, wire: wireVarint, varint: id},
+		{num: functionName, wire: wireVarint, varint: name},
+		{num: functionSystemName, wire: wireVarint, varint: name},
+	})
+	return id
+}
+
+// profile returns the symbolized profile: its fields, with the mappings
+// and locations updated, followed by the functions and strings added.
+func (s *symbolizer) profile() message {
+	out := make(message, 0, len(s.prof)+len(s.functions))
+	strings := 0
+	for i, f := range s.prof {
+		switch f.num {
+		case profileMapping:
+			f.data = s.mappings[i].encode(nil)
+		case profileLocation:
+			f.data = s.locations[i].encode(nil)
+		case profileStringTable:
+			strings++
+		}
+		out = append(out, f)
+	}
+	for _, fn := range s.functions {
+		out = out.appendMessage(profileFunction, fn)
+	}
+	for _, str := range s.strings[strings:] {
+		out = append(out, field{num: profileStringTable, wire: wireLen, data: []byte(str)})
+	}
+	return out
+}
+
+// openBinary opens the binary of m with opts.Open and recovers its
+// functions, or returns nil if there is no binary.
+func openBinary(ctx context.Context, m Mapping, opts Options) (*binaryFunctions, error) {
+	r, err := opts.Open(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open binary of mapping %s: %w", m.File, err)
+	}
+	if r == nil {
+		return nil, nil
+	}
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ELF file %s: %w", m.File, err)
+	}
+	syms, err := f.Symbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, fmt.Errorf("failed to read symbols of %s: %w", m.File, err)
+	}
+	candidates, err := resurgo.DetectFunctionsFromELFContext(ctx, r, opts.Detect)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect functions of %s: %w", m.File, err)
+	}
+
+	bin := &binaryFunctions{symbolizer: resurgo.NewSymbolizer(candidates, syms)}
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_LOAD {
+			bin.loads = append(bin.loads, p)
+		}
+	}
+	return bin, nil
+}
+
+// vaddr returns the virtual address in the binary of addr in m, through
+// the file offset it maps, and false if no loadable segment holds it.
+func (b *binaryFunctions) vaddr(m Mapping, addr uint64) (uint64, bool) {
+	if addr < m.Start {
+		return 0, false
+	}
+	off := addr - m.Start + m.Offset
+	for _, p := range b.loads {
+		if off >= p.Off && off-p.Off < p.Filesz {
+			return off - p.Off + p.Vaddr, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,271 @@
+package pprof_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxgio92/resurgo/pprof"
+)
+
+// pbField appends a protocol buffer field to b: a varint for uint64 values,
+// bytes otherwise.
+func pbField(b []byte, num int, v any) []byte {
+	switch v := v.(type) {
+	case uint64:
+		b = binary.AppendUvarint(b, uint64(num)<<3)
+		return binary.AppendUvarint(b, v)
+	case string:
+		return pbField(b, num, []byte(v))
+	case []byte:
+		b = binary.AppendUvarint(b, uint64(num)<<3|2)
+		b = binary.AppendUvarint(b, uint64(len(v)))
+		return append(b, v...)
+	}
+	panic(fmt.Sprintf("unsupported value %T", v))
+}
+
+// pbFields decodes the fields of the message b: varints as uint64 values,
+// length-delimited fields as []byte.
+func pbFields(t *testing.T, b []byte) map[int][]any {
+	t.Helper()
+	fields := make(map[int][]any)
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("truncated message")
+		}
+		b = b[n:]
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("truncated message")
+		}
+		b = b[n:]
+		switch key & 7 {
+		case 0:
+			fields[int(key>>3)] = append(fields[int(key>>3)], v)
+		case 2:
+			fields[int(key>>3)] = append(fields[int(key>>3)], b[:v])
+			b = b[v:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return fields
+}
+
+// compile compiles testdata/demo-app.c without optimizations, as a
+// position-dependent executable, and returns its contents, its text
+// segment, and the addresses of its functions by name.
+func compile(t *testing.T, args ...string) ([]byte, *elf.Prog, map[string]uint64) {
+	t.Helper()
+	binPath := filepath.Join(t.TempDir(), "demo-app")
+	args = append([]string{"-O0", "-fno-pie", "-no-pie", "-o", binPath, "../testdata/demo-app.c"}, args...)
+	if out, err := exec.Command("gcc", args...).CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile testdata/demo-app.c: %v\n%s", err, out)
+	}
+	data, err := os.ReadFile(binPath)
+	if err != nil {
+		t.Fatalf("failed to read compiled binary: %v", err)
+	}
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to parse ELF file: %v", err)
+	}
+	var text *elf.Prog
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_LOAD && p.Flags&elf.PF_X != 0 {
+			text = p
+		}
+	}
+	if text == nil {
+		t.Fatal("no executable segment")
+	}
+	addrs := make(map[string]uint64)
+	if syms, err := f.Symbols(); err == nil {
+		for _, s := range syms {
+			addrs[s.Name] = s.Value
+		}
+	}
+	return data, text, addrs
+}
+
+// testProfile returns a profile with a mapping of text, and a location in
+// add, a location in multiply already symbolized with the function 7, and
+// a location in main, in this order.
+func testProfile(text *elf.Prog, addrs map[string]uint64) []byte {
+	var mapping []byte
+	mapping = pbField(mapping, 1, uint64(1))
+	mapping = pbField(mapping, 2, text.Vaddr)
+	mapping = pbField(mapping, 3, text.Vaddr+text.Memsz)
+	mapping = pbField(mapping, 4, text.Off)
+	mapping = pbField(mapping, 5, uint64(1))
+
+	var prof []byte
+	// A sample_type, passed through unchanged.
+	prof = pbField(prof, 1, pbField(pbField(nil, 1, uint64(2)), 2, uint64(3)))
+	prof = pbField(prof, 3, mapping)
+	for i, addr := range []uint64{addrs["add"] + 4, addrs["multiply"] + 2, addrs["main"] + 8} {
+		var loc []byte
+		loc = pbField(loc, 1, uint64(i+1))
+		loc = pbField(loc, 2, uint64(1))
+		loc = pbField(loc, 3, addr)
+		if i == 1 {
+			loc = pbField(loc, 4, pbField(nil, 1, uint64(7)))
+		}
+		prof = pbField(prof, 4, loc)
+	}
+	prof = pbField(prof, 5, pbField(pbField(nil, 1, uint64(7)), 2, uint64(4)))
+	for _, s := range []string{"", "demo-app", "samples", "count", "multiply"} {
+		prof = pbField(prof, 6, s)
+	}
+	return prof
+}
+
+// locationNames returns the names of the functions of the lines of the
+// locations of the profile prof, by location ID.
+func locationNames(t *testing.T, prof []byte) map[uint64][]string {
+	t.Helper()
+	fields := pbFields(t, prof)
+	var strs []string
+	for _, s := range fields[6] {
+		strs = append(strs, string(s.([]byte)))
+	}
+	functions := make(map[uint64]string)
+	for _, fn := range fields[5] {
+		ff := pbFields(t, fn.([]byte))
+		id := ff[1][0].(uint64)
+		if _, dup := functions[id]; dup {
+			t.Errorf("duplicate function ID %d", id)
+		}
+		functions[id] = strs[ff[2][0].(uint64)]
+	}
+	names := make(map[uint64][]string)
+	for _, loc := range fields[4] {
+		lf := pbFields(t, loc.([]byte))
+		id := lf[1][0].(uint64)
+		for _, line := range lf[4] {
+			fn := pbFields(t, line.([]byte))[1][0].(uint64)
+			names[id] = append(names[id], functions[fn])
+		}
+	}
+	return names
+}
+
+func TestSymbolize(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found, skipping")
+	}
+	_, text, addrs := compile(t)
+	stripped, _, _ := compile(t, "-s")
+
+	// The input is gzipped, as written by pprof.
+	var in bytes.Buffer
+	zw := gzip.NewWriter(&in)
+	zw.Write(testProfile(text, addrs))
+	zw.Close()
+
+	var out bytes.Buffer
+	if err := pprof.Symbolize(&out, &in, bytes.NewReader(stripped)); err != nil {
+		t.Fatalf("Symbolize: %v", err)
+	}
+	zr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatalf("expected a gzipped profile: %v", err)
+	}
+	prof, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("failed to decompress profile: %v", err)
+	}
+
+	names := locationNames(t, prof)
+	for id, want := range map[uint64]string{
+		1: fmt.Sprintf("sub_%x", addrs["add"]),
+		2: "multiply",
+		3: fmt.Sprintf("sub_%x", addrs["main"]),
+	} {
+		if len(names[id]) != 1 || names[id][0] != want {
+			t.Errorf("location %d: expected function %q, got %q", id, want, names[id])
+		}
+	}
+
+	fields := pbFields(t, prof)
+	if len(fields[1]) != 1 {
+		t.Errorf("expected the sample type to be kept, got %d", len(fields[1]))
+	}
+	if hf := pbFields(t, fields[3][0].([]byte))[7]; len(hf) != 1 || hf[0] != uint64(1) {
+		t.Errorf("expected the mapping to have functions, got %v", hf)
+	}
+}
+
+func TestSymbolizeWithOptions(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found, skipping")
+	}
+	data, text, addrs := compile(t)
+
+	var opened []pprof.Mapping
+	var out bytes.Buffer
+	err := pprof.SymbolizeWithOptions(&out, bytes.NewReader(testProfile(text, addrs)), pprof.Options{
+		Open: func(m pprof.Mapping) (io.ReaderAt, error) {
+			opened = append(opened, m)
+			return bytes.NewReader(data), nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("SymbolizeWithOptions: %v", err)
+	}
+	want := pprof.Mapping{Start: text.Vaddr, Limit: text.Vaddr + text.Memsz, Offset: text.Off, File: "demo-app", Main: true}
+	if len(opened) != 1 || opened[0] != want {
+		t.Errorf("expected %+v to be opened, got %+v", want, opened)
+	}
+
+	// The symbols of the binary name its functions.
+	names := locationNames(t, out.Bytes())
+	for id, want := range map[uint64]string{1: "add", 3: "main"} {
+		if len(names[id]) != 1 || names[id][0] != want {
+			t.Errorf("location %d: expected function %q, got %q", id, want, names[id])
+		}
+	}
+
+	// A symbolized profile is left unchanged.
+	var again bytes.Buffer
+	if err := pprof.Symbolize(&again, bytes.NewReader(out.Bytes()), bytes.NewReader(data)); err != nil {
+		t.Fatalf("Symbolize: %v", err)
+	}
+	if !bytes.Equal(again.Bytes(), out.Bytes()) {
+		t.Error("expected a symbolized profile to be left unchanged")
+	}
+}
+
+func TestSymbolize_NoStringTable(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found, skipping")
+	}
+	data, text, addrs := compile(t)
+
+	// A profile with a mapping and a location in add, and no string table.
+	var prof []byte
+	prof = pbField(prof, 3, pbField(pbField(pbField(pbField(nil, 1, uint64(1)),
+		2, text.Vaddr), 3, text.Vaddr+text.Memsz), 4, text.Off))
+	prof = pbField(prof, 4, pbField(pbField(pbField(nil, 1, uint64(1)), 2, uint64(1)), 3, addrs["add"]+4))
+
+	var out bytes.Buffer
+	if err := pprof.Symbolize(&out, bytes.NewReader(prof), bytes.NewReader(data)); err != nil {
+		t.Fatalf("Symbolize: %v", err)
+	}
+	strs := pbFields(t, out.Bytes())[6]
+	if len(strs) == 0 || string(strs[0].([]byte)) != "" {
+		t.Fatalf("expected the string table to start with the empty string, got %q", strs)
+	}
+	if names := locationNames(t, out.Bytes()); len(names[1]) != 1 || names[1][0] != "add" {
+		t.Errorf("location 1: expected function %q, got %q", "add", names[1])
+	}
+}
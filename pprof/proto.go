@@ -0,0 +1,131 @@
+package pprof
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Protocol buffer wire types.
+const (
+	wireVarint = 0
+	wireI64    = 1
+	wireLen    = 2
+	wireI32    = 5
+)
+
+var errTruncated = errors.New("truncated message")
+
+// field is a field of a protocol buffer message, as encoded: its number,
+// its wire type, and its value, as a varint or as the bytes of the other
+// wire types.
+type field struct {
+	num    int
+	wire   int
+	varint uint64
+	data   []byte
+}
+
+// message is a protocol buffer message, as the list of its fields in
+// encoding order. Fields are kept encoded, so that unknown fields are
+// written back unchanged.
+type message []field
+
+// parseMessage decodes the fields of the message encoded in b.
+func parseMessage(b []byte) (message, error) {
+	var m message
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errTruncated
+		}
+		b = b[n:]
+		f := field{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case wireVarint:
+			f.varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errTruncated
+			}
+			b = b[n:]
+		case wireI64, wireI32:
+			size := 8
+			if f.wire == wireI32 {
+				size = 4
+			}
+			if len(b) < size {
+				return nil, errTruncated
+			}
+			f.data, b = b[:size], b[size:]
+		case wireLen:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return nil, errTruncated
+			}
+			f.data, b = b[n:n+int(size)], b[n+int(size):]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", f.wire)
+		}
+		m = append(m, f)
+	}
+	return m, nil
+}
+
+// encode appends the encoding of m to b.
+func (m message) encode(b []byte) []byte {
+	for _, f := range m {
+		b = binary.AppendUvarint(b, uint64(f.num)<<3|uint64(f.wire))
+		switch f.wire {
+		case wireVarint:
+			b = binary.AppendUvarint(b, f.varint)
+		case wireLen:
+			b = binary.AppendUvarint(b, uint64(len(f.data)))
+			b = append(b, f.data...)
+		default:
+			b = append(b, f.data...)
+		}
+	}
+	return b
+}
+
+// getUint returns the value of the last varint field num of m, or 0.
+func (m message) getUint(num int) uint64 {
+	for i := len(m) - 1; i >= 0; i-- {
+		if m[i].num == num && m[i].wire == wireVarint {
+			return m[i].varint
+		}
+	}
+	return 0
+}
+
+// setUint sets the varint field num of m to v.
+func (m message) setUint(num int, v uint64) message {
+	m = m.remove(num)
+	return append(m, field{num: num, wire: wireVarint, varint: v})
+}
+
+// has reports whether m has a field num.
+func (m message) has(num int) bool {
+	for _, f := range m {
+		if f.num == num {
+			return true
+		}
+	}
+	return false
+}
+
+// remove returns m without its fields num.
+func (m message) remove(num int) message {
+	out := m[:0:0]
+	for _, f := range m {
+		if f.num != num {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// appendMessage appends sub to m as the field num.
+func (m message) appendMessage(num int, sub message) message {
+	return append(m, field{num: num, wire: wireLen, data: sub.encode(nil)})
+}
@@ -0,0 +1,23 @@
+package resurgo
+
+import "context"
+
+// DetectFunctionsFromProcess enumerates the executable mappings of the live
+// process pid from /proc/<pid>/maps, reads their contents from
+// /proc/<pid>/mem, and detects function candidates in each of them, using
+// the mapping start address as base address. Results are attributed to the
+// backing file of each mapping, or to the anonymous region, which recovers
+// functions in JIT-compiled code that has no on-disk counterpart.
+// The architecture is inferred from the ELF header of /proc/<pid>/exe.
+// Reading another process memory requires ptrace access to it.
+// This function is only supported on Linux.
+func DetectFunctionsFromProcess(pid int) ([]MappingFunctions, error) {
+	return DetectFunctionsFromProcessContext(context.Background(), pid, Options{})
+}
+
+// DetectFunctionsFromProcessWithOptions is like DetectFunctionsFromProcess
+// but applies the settings in opts to each mapping. Options.Sections does
+// not apply.
+func DetectFunctionsFromProcessWithOptions(pid int, opts Options) ([]MappingFunctions, error) {
+	return DetectFunctionsFromProcessContext(context.Background(), pid, opts)
+}
@@ -0,0 +1,140 @@
+//go:build linux
+
+package resurgo
+
+import (
+	"bufio"
+	"context"
+	"debug/elf"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DetectFunctionsFromProcessContext is like
+// DetectFunctionsFromProcessWithOptions but stops with the context error as
+// soon as ctx is done, and reports progress to opts.Progress, labelled with
+// the mapping path.
+func DetectFunctionsFromProcessContext(ctx context.Context, pid int, opts Options) ([]MappingFunctions, error) {
+	dir := fmt.Sprintf("/proc/%d", pid)
+
+	arch, err := processArch(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings, err := readProcessMappings(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	mem, err := os.Open(dir + "/mem")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open process memory: %w", err)
+	}
+	defer mem.Close()
+
+	var result []MappingFunctions
+	for _, m := range mappings {
+		if m.End > math.MaxInt64 {
+			opts.logger().Debug("skipping unreadable mapping", "path", m.Path, "start", m.Start)
+			continue
+		}
+
+		r := io.NewSectionReader(mem, int64(m.Start), int64(m.End-m.Start))
+		src, err := mappingSource(r, int(m.End-m.Start), opts)
+		if err != nil {
+			// Some mappings, such as [vsyscall], cannot be read through
+			// /proc/<pid>/mem.
+			opts.logger().Debug("skipping unreadable mapping",
+				"path", m.Path, "start", m.Start, "error", err)
+			continue
+		}
+
+		mf, err := detectMappingFunctions(ctx, src, m, arch, opts)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, mf)
+	}
+
+	return result, nil
+}
+
+// processArch returns the architecture of the executable of the process
+// whose /proc directory is dir.
+func processArch(dir string) (Arch, error) {
+	f, err := elf.Open(dir + "/exe")
+	if err != nil {
+		return "", fmt.Errorf("failed to parse process executable: %w", err)
+	}
+	defer f.Close()
+
+	return elfArch(f.Machine)
+}
+
+// readProcessMappings returns the executable mappings listed in the maps
+// file of the process whose /proc directory is dir.
+func readProcessMappings(dir string) ([]Mapping, error) {
+	f, err := os.Open(dir + "/maps")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open process mappings: %w", err)
+	}
+	defer f.Close()
+
+	var mappings []Mapping
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		m, err := parseMapsLine(sc.Text())
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(m.Perms, "x") {
+			mappings = append(mappings, m)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read process mappings: %w", err)
+	}
+
+	return mappings, nil
+}
+
+// parseMapsLine parses a line of /proc/<pid>/maps, formatted as
+//
+//	start-end perms offset dev inode [path]
+//
+// The path may contain spaces and is kept verbatim, including a trailing
+// " (deleted)" marker.
+func parseMapsLine(line string) (Mapping, error) {
+	fields := strings.SplitN(line, " ", 6)
+	if len(fields) < 5 {
+		return Mapping{}, fmt.Errorf("malformed mapping: %q", line)
+	}
+
+	start, end, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return Mapping{}, fmt.Errorf("malformed mapping range: %q", fields[0])
+	}
+
+	var m Mapping
+	var err error
+	if m.Start, err = strconv.ParseUint(start, 16, 64); err != nil {
+		return Mapping{}, fmt.Errorf("malformed mapping start: %w", err)
+	}
+	if m.End, err = strconv.ParseUint(end, 16, 64); err != nil {
+		return Mapping{}, fmt.Errorf("malformed mapping end: %w", err)
+	}
+	if m.Offset, err = strconv.ParseUint(fields[2], 16, 64); err != nil {
+		return Mapping{}, fmt.Errorf("malformed mapping offset: %w", err)
+	}
+	m.Perms = fields[1]
+	if len(fields) == 6 {
+		m.Path = strings.TrimLeft(fields[5], " ")
+	}
+
+	return m, nil
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+package resurgo_test
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+func TestDetectFunctionsFromProcess(t *testing.T) {
+	bin, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not available")
+	}
+	cmd := exec.Command(bin, "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start child process: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	var results []resurgo.MappingFunctions
+	// The maps of the child only list its executable once exec completes.
+	for range 100 {
+		results, err = resurgo.DetectFunctionsFromProcess(cmd.Process.Pid)
+		if err != nil {
+			t.Fatalf("DetectFunctionsFromProcess: %v", err)
+		}
+		if len(results) > 1 {
+			break
+		}
+	}
+
+	var found bool
+	for _, r := range results {
+		if r.Mapping.Start >= r.Mapping.End {
+			t.Errorf("invalid mapping range 0x%x-0x%x", r.Mapping.Start, r.Mapping.End)
+		}
+		for _, c := range r.Functions {
+			if c.Address < r.Mapping.Start || c.Address >= r.Mapping.End {
+				t.Errorf("%s: candidate 0x%x outside mapping 0x%x-0x%x",
+					r.Mapping.Path, c.Address, r.Mapping.Start, r.Mapping.End)
+			}
+		}
+		if filepath.Base(r.Mapping.Path) == filepath.Base(bin) && len(r.Functions) > 0 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected candidates in the %s mapping, got %d mappings", bin, len(results))
+	}
+}
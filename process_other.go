@@ -0,0 +1,17 @@
+//go:build !linux
+
+package resurgo
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// DetectFunctionsFromProcessContext is like
+// DetectFunctionsFromProcessWithOptions but stops with the context error as
+// soon as ctx is done, and reports progress to opts.Progress, labelled with
+// the mapping path.
+func DetectFunctionsFromProcessContext(ctx context.Context, pid int, opts Options) ([]MappingFunctions, error) {
+	return nil, fmt.Errorf("process analysis is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,75 @@
+package resurgo
+
+import (
+	"context"
+	"sync"
+)
+
+// Phase identifies a stage of function detection.
+type Phase string
+
+// Detection phases reported through Options.Progress.
+const (
+	PhasePrologues Phase = "prologues"
+	PhaseCallSites Phase = "call-sites"
+	PhasePadding   Phase = "padding"
+	PhaseNoReturn  Phase = "noreturn"
+	PhaseMerge     Phase = "merge"
+)
+
+// Progress describes how far a detection phase has advanced.
+type Progress struct {
+	// Phase is the detection phase in progress.
+	Phase Phase `json:"phase"`
+	// Section is the name of the ELF section being analyzed, or empty when
+	// analyzing raw bytes.
+	Section string `json:"section,omitempty"`
+	// Done and Total count the bytes of code decoded so far and in total.
+	// In PhaseMerge they count the prologues and call sites merged.
+	Done  int64 `json:"done"`
+	Total int64 `json:"total"`
+}
+
+// progressInterval is the number of bytes decoded between two cancellation
+// checks and progress reports.
+const progressInterval = 1 << 16
+
+// tracker checks for cancellation and reports the progress of one phase.
+// It is safe for concurrent use; the progress callback is never invoked
+// concurrently.
+type tracker struct {
+	ctx context.Context
+	fn  func(Progress)
+
+	mu       sync.Mutex
+	progress Progress
+}
+
+func newTracker(ctx context.Context, opts Options, phase Phase, total int) *tracker {
+	return &tracker{
+		ctx: ctx,
+		fn:  opts.Progress,
+		progress: Progress{
+			Phase:   phase,
+			Section: opts.section,
+			Total:   int64(total),
+		},
+	}
+}
+
+// start reports the beginning of the phase.
+func (t *tracker) start() error {
+	return t.advance(0)
+}
+
+// advance records n more units of work as done, reports the progress and
+// returns the context error, if any.
+func (t *tracker) advance(n int) error {
+	if t.fn != nil {
+		t.mu.Lock()
+		t.progress.Done += int64(n)
+		t.fn(t.progress)
+		t.mu.Unlock()
+	}
+	return t.ctx.Err()
+}
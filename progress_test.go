@@ -0,0 +1,116 @@
+package resurgo_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+func TestDetectContext_Canceled(t *testing.T) {
+	code, base := buildSyntheticAMD64()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := resurgo.DetectProloguesContext(ctx, code, base, resurgo.ArchAMD64, resurgo.Options{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("DetectProloguesContext: expected context.Canceled, got %v", err)
+	}
+	if _, err := resurgo.DetectCallSitesContext(ctx, code, base, resurgo.ArchAMD64, resurgo.Options{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("DetectCallSitesContext: expected context.Canceled, got %v", err)
+	}
+	if _, err := resurgo.DetectFunctionsContext(ctx, code, base, resurgo.ArchAMD64, resurgo.Options{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("DetectFunctionsContext: expected context.Canceled, got %v", err)
+	}
+
+	opts := resurgo.Options{Concurrency: 4, ChunkSize: 0x40}
+	if _, err := resurgo.DetectFunctionsContext(ctx, code, base, resurgo.ArchAMD64, opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("DetectFunctionsContext (concurrent): expected context.Canceled, got %v", err)
+	}
+}
+
+func TestDetectContext_CancelDuringProgress(t *testing.T) {
+	// A large buffer of NOPs decoded serially reports progress several
+	// times; canceling from the callback must stop decoding.
+	code := make([]byte, 1<<20)
+	for i := range code {
+		code[i] = 0x90
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var reports int
+	opts := resurgo.Options{Progress: func(p resurgo.Progress) {
+		reports++
+		if p.Done > 0 {
+			cancel()
+		}
+	}}
+	_, err := resurgo.DetectCallSitesContext(ctx, code, 0, resurgo.ArchAMD64, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if reports != 2 {
+		t.Errorf("expected decoding to stop at the first report after start, got %d reports", reports)
+	}
+}
+
+func TestDetectFunctionsFromELFContext_Progress(t *testing.T) {
+	binPath := filepath.Join(t.TempDir(), demoAppBinary)
+	cmd := exec.Command("go", "build", "-o", binPath, demoAppSource)
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOARCH=amd64")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile demo-app: %v\n%s", err, out)
+	}
+
+	f, err := os.Open(binPath)
+	if err != nil {
+		t.Fatalf("failed to open compiled binary: %v", err)
+	}
+	defer f.Close()
+
+	for _, concurrency := range []int{0, 4} {
+		var reports []resurgo.Progress
+		opts := resurgo.Options{
+			Concurrency: concurrency,
+			ChunkSize:   1 << 16,
+			Progress:    func(p resurgo.Progress) { reports = append(reports, p) },
+		}
+		if _, err := resurgo.DetectFunctionsFromELFContext(context.Background(), f, opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		last := make(map[resurgo.Phase]resurgo.Progress)
+		var order []resurgo.Phase
+		for _, p := range reports {
+			if p.Section != ".text" {
+				t.Errorf("expected section .text, got %q", p.Section)
+			}
+			if prev, ok := last[p.Phase]; ok && p.Done < prev.Done {
+				t.Errorf("%s: progress went backwards: %d -> %d", p.Phase, prev.Done, p.Done)
+			}
+			if _, ok := last[p.Phase]; !ok {
+				order = append(order, p.Phase)
+			}
+			last[p.Phase] = p
+		}
+
+		wantOrder := []resurgo.Phase{resurgo.PhasePrologues, resurgo.PhaseCallSites, resurgo.PhasePadding, resurgo.PhaseNoReturn, resurgo.PhaseMerge}
+		if len(order) != len(wantOrder) {
+			t.Fatalf("concurrency %d: expected phases %v, got %v", concurrency, wantOrder, order)
+		}
+		for i, phase := range wantOrder {
+			if order[i] != phase {
+				t.Errorf("concurrency %d: expected phase %s at position %d, got %s", concurrency, phase, i, order[i])
+			}
+			if p := last[phase]; p.Done != p.Total || p.Total == 0 {
+				t.Errorf("concurrency %d: %s: expected completed progress, got %d/%d", concurrency, phase, p.Done, p.Total)
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package resurgo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ScriptOptions configures WriteIDAPython, WriteGhidraScript and
+// WriteR2Script. The zero value seeds every candidate at its address.
+type ScriptOptions struct {
+	// MinConfidence leaves out the candidates whose confidence is lower
+	// than the given level. The empty value keeps every candidate.
+	MinConfidence Confidence
+
+	// Rebase moves the addresses by the difference between the image base
+	// chosen by the disassembler and ImageBase, the link-time base of the
+	// binary, usually the lowest PT_LOAD address. Ghidra, for instance,
+	// loads position-independent ELF binaries linked at 0 at 0x100000.
+	Rebase    bool
+	ImageBase uint64
+}
+
+// scriptFunctions returns the candidates of a script: sorted by address,
+// one per address, and at least as confident as opts requires.
+func scriptFunctions(candidates []FunctionCandidate, opts ScriptOptions) []FunctionCandidate {
+	filter := Options{MinConfidence: opts.MinConfidence}
+	var kept []FunctionCandidate
+	for _, c := range sortedFunctions(candidates) {
+		if filter.meetsConfidence(c.Confidence) {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// scriptComment returns the comment describing how c was detected.
+func scriptComment(c FunctionCandidate) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "resurgo: %s confidence, %s", c.Confidence, c.DetectionType)
+	if c.PrologueType != "" {
+		fmt.Fprintf(&b, ", %s prologue", c.PrologueType)
+	}
+	if n := len(c.CalledFrom); n > 0 {
+		fmt.Fprintf(&b, ", %d callers", n)
+	}
+	if c.NoReturn {
+		b.WriteString(", no return")
+	}
+	return b.String()
+}
+
+// WriteIDAPython writes to w an IDAPython script creating a function at
+// each candidate with ida_funcs.add_func, naming it after the candidate
+// when its name is known, and commenting it with its confidence and how it
+// was detected. The script waits for auto-analysis to finish, so that it
+// can run with idat -A -S in headless batch runs.
+func WriteIDAPython(w io.Writer, candidates []FunctionCandidate, opts ScriptOptions) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("# Functions recovered by resurgo.\n")
+	bw.WriteString("import ida_auto\nimport ida_funcs\nimport ida_name\nimport ida_nalt\n\n")
+	if opts.Rebase {
+		fmt.Fprintf(bw, "DELTA = ida_nalt.get_imagebase() - 0x%x\n", opts.ImageBase)
+	} else {
+		bw.WriteString("DELTA = 0\n")
+	}
+	bw.WriteString("FUNCTIONS = [\n")
+	for _, c := range scriptFunctions(candidates, opts) {
+		fmt.Fprintf(bw, "    (0x%x, %s, %s),\n", c.Address, strconv.QuoteToASCII(c.Name), strconv.QuoteToASCII(scriptComment(c)))
+	}
+	bw.WriteString(`]
+
+ida_auto.auto_wait()
+for ea, name, comment in FUNCTIONS:
+    ea += DELTA
+    ida_funcs.add_func(ea)
+    if name:
+        ida_name.set_name(ea, name, ida_name.SN_NOWARN | ida_name.SN_NOCHECK)
+    pfn = ida_funcs.get_func(ea)
+    if pfn is not None and pfn.start_ea == ea:
+        ida_funcs.set_func_cmt(pfn, comment, False)
+ida_auto.auto_wait()
+`)
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write IDAPython script: %w", err)
+	}
+	return nil
+}
+
+// WriteGhidraScript writes to w a Ghidra Python script creating a function
+// at each candidate with createFunction, named after the candidate when
+// its name is known, and adding a bookmark of the resurgo category with
+// its confidence and how it was detected. Functions already defined at a
+// candidate are kept and only bookmarked. The script uses the flat API
+// only, so that it runs with Jython and PyGhidra, in analyzeHeadless
+// -postScript batch runs too.
+func WriteGhidraScript(w io.Writer, candidates []FunctionCandidate, opts ScriptOptions) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("# Functions recovered by resurgo.\n# @category resurgo\n\n")
+	if opts.Rebase {
+		fmt.Fprintf(bw, "DELTA = currentProgram.getImageBase().getOffset() - 0x%x\n", opts.ImageBase)
+	} else {
+		bw.WriteString("DELTA = 0\n")
+	}
+	bw.WriteString("FUNCTIONS = [\n")
+	for _, c := range scriptFunctions(candidates, opts) {
+		name := "None"
+		if c.Name != "" {
+			name = "u" + strconv.QuoteToASCII(c.Name)
+		}
+		fmt.Fprintf(bw, "    (0x%x, %s, u%s),\n", c.Address, name, strconv.QuoteToASCII(scriptComment(c)))
+	}
+	bw.WriteString(`]
+
+for offset, name, comment in FUNCTIONS:
+    addr = toAddr(offset + DELTA)
+    if getFunctionAt(addr) is None:
+        disassemble(addr)
+        createFunction(addr, name)
+    createBookmark(addr, "resurgo", comment)
+`)
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write Ghidra script: %w", err)
+	}
+	return nil
+}
+
+// WriteR2Script writes to w a radare2 script, run with r2 -i, analyzing a
+// function at each candidate with af, renaming it with afn after the
+// candidate when its name is known, and commenting it with CC with its
+// confidence and how it was detected. Names holding characters other than
+// letters, digits, underscores and dots, which r2 would parse as command
+// syntax, are left to r2. With Rebase, addresses are relative to $B, the
+// base address of the binary.
+func WriteR2Script(w io.Writer, candidates []FunctionCandidate, opts ScriptOptions) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("# Functions recovered by resurgo.\n")
+	for _, c := range scriptFunctions(candidates, opts) {
+		addr := fmt.Sprintf("0x%x", c.Address)
+		if opts.Rebase {
+			if c.Address < opts.ImageBase {
+				continue
+			}
+			addr = fmt.Sprintf("$B+0x%x", c.Address-opts.ImageBase)
+		}
+		fmt.Fprintf(bw, "af @ %s\n", addr)
+		if r2Name(c.Name) {
+			fmt.Fprintf(bw, "afn %s @ %s\n", c.Name, addr)
+		}
+		fmt.Fprintf(bw, "CC %s @ %s\n", scriptComment(c), addr)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write r2 script: %w", err)
+	}
+	return nil
+}
+
+// r2Name reports whether name can be written in an r2 command as it is.
+func r2Name(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
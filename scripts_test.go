@@ -0,0 +1,121 @@
+package resurgo_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+var scriptCandidates = []resurgo.FunctionCandidate{
+	{Address: 0x1200, DetectionType: resurgo.DetectionCallTarget, Confidence: resurgo.ConfidenceMedium, CalledFrom: []uint64{0x1010, 0x1110}},
+	{Address: 0x1000, DetectionType: resurgo.DetectionBoth, PrologueType: resurgo.PrologueClassic, Confidence: resurgo.ConfidenceHigh, Name: "main"},
+	{Address: 0x1100, DetectionType: resurgo.DetectionPadding, Confidence: resurgo.ConfidenceLow, Name: `op"s; ls`},
+}
+
+// checkPython compiles the Python script src, when python3 is available.
+func checkPython(t *testing.T, src string) {
+	t.Helper()
+	if _, err := exec.LookPath("python3"); err != nil {
+		return
+	}
+	path := filepath.Join(t.TempDir(), "script.py")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("python3", "-c", "import sys; compile(open(sys.argv[1]).read(), sys.argv[1], 'exec')", path)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("invalid Python script: %v\n%s\n%s", err, out, src)
+	}
+}
+
+func TestWriteIDAPython(t *testing.T) {
+	var buf bytes.Buffer
+	if err := resurgo.WriteIDAPython(&buf, scriptCandidates, resurgo.ScriptOptions{}); err != nil {
+		t.Fatalf("WriteIDAPython: %v", err)
+	}
+	out := buf.String()
+	checkPython(t, out)
+
+	for _, want := range []string{
+		"DELTA = 0\n",
+		`    (0x1000, "main", "resurgo: high confidence, both, classic prologue"),` + "\n" +
+			`    (0x1100, "op\"s; ls", "resurgo: low confidence, padding"),` + "\n" +
+			`    (0x1200, "", "resurgo: medium confidence, call-target, 2 callers"),` + "\n",
+		"ida_funcs.add_func(ea)",
+		"ida_funcs.set_func_cmt(pfn, comment, False)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestWriteGhidraScript(t *testing.T) {
+	var buf bytes.Buffer
+	opts := resurgo.ScriptOptions{MinConfidence: resurgo.ConfidenceMedium, Rebase: true, ImageBase: 0x1000}
+	if err := resurgo.WriteGhidraScript(&buf, scriptCandidates, opts); err != nil {
+		t.Fatalf("WriteGhidraScript: %v", err)
+	}
+	out := buf.String()
+	checkPython(t, out)
+
+	for _, want := range []string{
+		"DELTA = currentProgram.getImageBase().getOffset() - 0x1000\n",
+		`    (0x1000, u"main", u"resurgo: high confidence, both, classic prologue"),` + "\n" +
+			`    (0x1200, None, u"resurgo: medium confidence, call-target, 2 callers"),` + "\n",
+		"createFunction(addr, name)",
+		`createBookmark(addr, "resurgo", comment)`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "0x1100") {
+		t.Errorf("expected the low confidence candidate to be left out:\n%s", out)
+	}
+}
+
+func TestWriteR2Script(t *testing.T) {
+	tests := []struct {
+		name string
+		opts resurgo.ScriptOptions
+		want string
+	}{
+		{
+			name: "absolute",
+			want: "# Functions recovered by resurgo.\n" +
+				"af @ 0x1000\n" +
+				"afn main @ 0x1000\n" +
+				"CC resurgo: high confidence, both, classic prologue @ 0x1000\n" +
+				// The name would be parsed as commands.
+				"af @ 0x1100\n" +
+				"CC resurgo: low confidence, padding @ 0x1100\n" +
+				"af @ 0x1200\n" +
+				"CC resurgo: medium confidence, call-target, 2 callers @ 0x1200\n",
+		},
+		{
+			name: "rebased",
+			opts: resurgo.ScriptOptions{MinConfidence: resurgo.ConfidenceHigh, Rebase: true, ImageBase: 0x1000},
+			want: "# Functions recovered by resurgo.\n" +
+				"af @ $B+0x0\n" +
+				"afn main @ $B+0x0\n" +
+				"CC resurgo: high confidence, both, classic prologue @ $B+0x0\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := resurgo.WriteR2Script(&buf, scriptCandidates, tt.opts); err != nil {
+				t.Fatalf("WriteR2Script: %v", err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("expected:\n%s\ngot:\n%s", tt.want, got)
+			}
+		})
+	}
+}
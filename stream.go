@@ -0,0 +1,84 @@
+package resurgo
+
+import (
+	"context"
+	"iter"
+)
+
+// Prologues returns an iterator over the function prologues detected in code.
+// Prologues are yielded as decoding proceeds, so no result slice is held in
+// memory, and decoding stops as soon as the caller breaks out of the loop.
+// An unsupported architecture is reported as a single error.
+func Prologues(code []byte, baseAddr uint64, arch Arch) iter.Seq2[Prologue, error] {
+	return ProloguesWithOptions(code, baseAddr, arch, Options{})
+}
+
+// ProloguesWithOptions is like Prologues but applies the address range
+// restrictions in opts.
+func ProloguesWithOptions(code []byte, baseAddr uint64, arch Arch, opts Options) iter.Seq2[Prologue, error] {
+	return func(yield func(Prologue, error) bool) {
+		err := scanPrologues(code, baseAddr, arch, opts, func(p Prologue) bool {
+			if !opts.inRanges(p.Address) {
+				return true
+			}
+			return yield(p, nil)
+		})
+		if err != nil {
+			yield(Prologue{}, err)
+		}
+	}
+}
+
+// CallSites returns an iterator over the call sites detected in code, in
+// source address order. Edges are yielded as decoding proceeds and decoding
+// stops as soon as the caller breaks out of the loop. An unsupported
+// architecture is reported as a single error.
+func CallSites(code []byte, baseAddr uint64, arch Arch) iter.Seq2[CallSiteEdge, error] {
+	return CallSitesWithOptions(code, baseAddr, arch, Options{})
+}
+
+// CallSitesWithOptions is like CallSites but honours the conditional jump,
+// minimum confidence and address range settings in opts.
+func CallSitesWithOptions(code []byte, baseAddr uint64, arch Arch, opts Options) iter.Seq2[CallSiteEdge, error] {
+	return func(yield func(CallSiteEdge, error) bool) {
+		err := scanCallSites(code, baseAddr, arch, opts, func(e CallSiteEdge) bool {
+			if !opts.inRanges(e.SourceAddr) || !opts.meetsConfidence(e.Confidence) {
+				return true
+			}
+			return yield(e, nil)
+		})
+		if err != nil {
+			yield(CallSiteEdge{}, err)
+		}
+	}
+}
+
+// Functions returns an iterator over the function candidates detected in
+// code, in address order. Unlike Prologues and CallSites, a candidate is only
+// complete once every call site has been seen, so the whole of code is
+// decoded before the first candidate is yielded. Breaking out of the loop
+// still avoids materializing the remaining results.
+func Functions(code []byte, baseAddr uint64, arch Arch) iter.Seq2[FunctionCandidate, error] {
+	return FunctionsWithOptions(code, baseAddr, arch, Options{})
+}
+
+// FunctionsWithOptions is like Functions but combines only the evidence
+// sources enabled in opts and applies its remaining settings.
+func FunctionsWithOptions(code []byte, baseAddr uint64, arch Arch, opts Options) iter.Seq2[FunctionCandidate, error] {
+	return func(yield func(FunctionCandidate, error) bool) {
+		candidates, err := detectFunctions(context.Background(), memSource(code), baseAddr, arch, opts)
+		if err != nil {
+			yield(FunctionCandidate{}, err)
+			return
+		}
+
+		for _, c := range candidates {
+			if !opts.inRanges(c.Address) || !opts.meetsConfidence(c.Confidence) {
+				continue
+			}
+			if !yield(c, nil) {
+				return
+			}
+		}
+	}
+}
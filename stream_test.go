@@ -0,0 +1,104 @@
+package resurgo_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+func TestStreams_MatchSlices(t *testing.T) {
+	tests := []struct {
+		name  string
+		arch  resurgo.Arch
+		build func() ([]byte, uint64)
+	}{
+		{name: "amd64", arch: resurgo.ArchAMD64, build: buildSyntheticAMD64},
+		{name: "arm64", arch: resurgo.ArchARM64, build: buildSyntheticARM64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, base := tt.build()
+
+			wantPrologues, err := resurgo.DetectPrologues(code, base, tt.arch)
+			if err != nil {
+				t.Fatalf("DetectPrologues: %v", err)
+			}
+			var gotPrologues []resurgo.Prologue
+			for p, err := range resurgo.Prologues(code, base, tt.arch) {
+				if err != nil {
+					t.Fatalf("Prologues: %v", err)
+				}
+				gotPrologues = append(gotPrologues, p)
+			}
+			if !slices.Equal(gotPrologues, wantPrologues) {
+				t.Errorf("Prologues mismatch:\n got: %+v\nwant: %+v", gotPrologues, wantPrologues)
+			}
+
+			wantEdges, err := resurgo.DetectCallSites(code, base, tt.arch)
+			if err != nil {
+				t.Fatalf("DetectCallSites: %v", err)
+			}
+			var gotEdges []resurgo.CallSiteEdge
+			for e, err := range resurgo.CallSites(code, base, tt.arch) {
+				if err != nil {
+					t.Fatalf("CallSites: %v", err)
+				}
+				gotEdges = append(gotEdges, e)
+			}
+			if !slices.Equal(gotEdges, wantEdges) {
+				t.Errorf("CallSites mismatch:\n got: %+v\nwant: %+v", gotEdges, wantEdges)
+			}
+
+			wantCandidates, err := resurgo.DetectFunctions(code, base, tt.arch)
+			if err != nil {
+				t.Fatalf("DetectFunctions: %v", err)
+			}
+			var gotAddrs []uint64
+			for c, err := range resurgo.Functions(code, base, tt.arch) {
+				if err != nil {
+					t.Fatalf("Functions: %v", err)
+				}
+				gotAddrs = append(gotAddrs, c.Address)
+			}
+			var wantAddrs []uint64
+			for _, c := range wantCandidates {
+				wantAddrs = append(wantAddrs, c.Address)
+			}
+			if !slices.Equal(gotAddrs, wantAddrs) {
+				t.Errorf("Functions mismatch:\n got: %x\nwant: %x", gotAddrs, wantAddrs)
+			}
+		})
+	}
+}
+
+func TestStreams_EarlyTermination(t *testing.T) {
+	code, base := buildSyntheticAMD64()
+
+	n := 0
+	for _, err := range resurgo.CallSites(code, base, resurgo.ArchAMD64) {
+		if err != nil {
+			t.Fatalf("CallSites: %v", err)
+		}
+		n++
+		if n == 2 {
+			break
+		}
+	}
+	if n != 2 {
+		t.Errorf("expected to stop after 2 edges, got %d", n)
+	}
+}
+
+func TestStreams_UnsupportedArch(t *testing.T) {
+	var errs int
+	for _, err := range resurgo.Prologues([]byte{0x00}, 0, resurgo.Arch("mips")) {
+		if err != nil {
+			errs++
+		}
+	}
+	if errs != 1 {
+		t.Errorf("expected exactly one error, got %d", errs)
+	}
+}
@@ -0,0 +1,333 @@
+package resurgo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+
+	"golang.org/x/arch/arm64/arm64asm"
+)
+
+// defaultChunkSize is the size of the chunks decoded in parallel when
+// Options.ChunkSize is zero.
+const defaultChunkSize = 1 << 20
+
+// syncWindow is the number of leading instruction offsets recorded for each
+// chunk decoded in parallel. The serial continuation of the previous chunk
+// must land on one of them to reuse the chunk results; otherwise the chunk
+// is decoded again serially.
+const syncWindow = 4096
+
+// sweeper performs a linear sweep over a code buffer, decoding one
+// instruction at a time and carrying the state needed to detect patterns
+// spanning consecutive instructions.
+type sweeper[T any] interface {
+	// pos returns the offset of the next instruction to decode.
+	pos() int
+	// step decodes the instruction at pos, appends the results it produces
+	// to out and advances pos. It reports whether the sweeper state after
+	// the step depends only on the bytes at the decoded offset, and not on
+	// the instructions decoded before it.
+	step(out []T) ([]T, bool)
+	// shift continues the sweep on a new buffer whose first byte is the
+	// byte at pos, keeping the decoding state.
+	shift(code []byte)
+}
+
+// arm64Sweeper is implemented by the sweepers of ARM64 code, which decode
+// through the decoder of their worker in a parallel sweep.
+type arm64Sweeper interface {
+	setDecoder(d *arm64Decoder)
+}
+
+// arm64DecodeMu serialises the calls to arm64asm.Decode of the workers of
+// parallel sweeps: it records decoder coverage in a package-level table and
+// is not safe for concurrent use.
+var arm64DecodeMu sync.Mutex
+
+// arm64Decoder decodes ARM64 instructions for the sweepers of a worker of a
+// parallel sweep. It caches the instructions it decoded, so that the
+// instruction words repeated across the code are decoded without taking
+// arm64DecodeMu. A nil decoder, as used by serial sweeps, calls
+// arm64asm.Decode directly.
+type arm64Decoder struct {
+	cache map[uint32]arm64Decoded
+}
+
+// arm64Decoded is a cached result of arm64asm.Decode.
+type arm64Decoded struct {
+	inst arm64asm.Inst
+	err  error
+}
+
+// maxARM64DecodeCache bounds the instructions cached by an arm64Decoder.
+const maxARM64DecodeCache = 1 << 16
+
+// newARM64Decoder returns a decoder for a worker of a parallel sweep.
+func newARM64Decoder() *arm64Decoder {
+	return &arm64Decoder{cache: make(map[uint32]arm64Decoded)}
+}
+
+// decode decodes the instruction at the start of code, like
+// arm64asm.Decode.
+func (d *arm64Decoder) decode(code []byte) (arm64asm.Inst, error) {
+	// Truncated instructions are rejected before the coverage table is
+	// touched.
+	if d == nil || len(code) < 4 {
+		return arm64asm.Decode(code)
+	}
+	w := binary.LittleEndian.Uint32(code)
+	if r, ok := d.cache[w]; ok {
+		return r.inst, r.err
+	}
+	arm64DecodeMu.Lock()
+	inst, err := arm64asm.Decode(code)
+	arm64DecodeMu.Unlock()
+	if len(d.cache) >= maxARM64DecodeCache {
+		clear(d.cache)
+	}
+	d.cache[w] = arm64Decoded{inst, err}
+	return inst, err
+}
+
+// windowLookahead is the number of bytes read past the end of a window, so
+// that an instruction starting in the window is always decoded whole. It
+// covers the longest x86-64 instruction (15 bytes).
+const windowLookahead = 16
+
+// source is the machine code swept by the detectors. It is either held in
+// memory, or read through r in windows of window bytes.
+type source struct {
+	code   []byte
+	r      io.ReaderAt
+	size   int
+	window int
+}
+
+// memSource returns a source for code held in memory.
+func memSource(code []byte) source {
+	return source{code: code, size: len(code)}
+}
+
+// cursor reads code from a source at increasing offsets, through windows of
+// the source window size when the code is not held in memory.
+type cursor struct {
+	src   source
+	buf   []byte
+	start int
+}
+
+// at returns the code from offset off, holding at least the next
+// windowLookahead bytes unless the code ends before.
+func (c *cursor) at(off int) ([]byte, error) {
+	if c.src.r == nil {
+		return c.src.code[off:], nil
+	}
+	end := c.start + len(c.buf)
+	if off < c.start || off >= end || end-off < windowLookahead && end < c.src.size {
+		n := min(c.src.window+windowLookahead, c.src.size-off)
+		if cap(c.buf) < n {
+			c.buf = make([]byte, n)
+		}
+		c.buf, c.start = c.buf[:n], off
+		if _, err := c.src.r.ReadAt(c.buf, int64(off)); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read code at offset 0x%x: %w", off, err)
+		}
+	}
+	return c.buf[off-c.start:], nil
+}
+
+// sweep drives s until it reaches end, passing results to yield. It stops
+// as soon as yield returns false.
+func sweep[T any](s sweeper[T], end int, yield func(T) bool) {
+	var buf []T
+	for s.pos() < end {
+		buf, _ = s.step(buf[:0])
+		for _, v := range buf {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// sweepAll sweeps a code buffer of size n and returns all the results,
+// reporting progress to t and stopping early if its context is done. When
+// opts enables concurrency, the buffer is split into chunks swept by worker
+// goroutines, and the results are merged to the same output as a serial
+// sweep.
+func sweepAll[T any](src source, newSweeper func(start int) sweeper[T], opts Options, t *tracker) ([]T, error) {
+	if err := t.start(); err != nil {
+		return nil, err
+	}
+	if src.r != nil {
+		return sweepWindows(src, newSweeper(0), t)
+	}
+
+	n := src.size
+	chunkSize := opts.chunkSize()
+	if opts.Concurrency <= 1 || n <= chunkSize {
+		c, err := sweepChunk(newSweeper(0), n, t)
+		if err != nil {
+			return nil, err
+		}
+		return c.results, nil
+	}
+
+	bounds := []int{0}
+	for off := chunkSize; off < n; off += chunkSize {
+		bounds = append(bounds, off)
+	}
+	bounds = append(bounds, n)
+
+	chunks := make([]sweptChunk[T], len(bounds)-1)
+	work := make(chan int, len(chunks))
+	for i := range chunks {
+		work <- i
+	}
+	close(work)
+
+	var wg sync.WaitGroup
+	for range min(opts.Concurrency, len(chunks)) {
+		wg.Go(func() {
+			dec := newARM64Decoder()
+			for i := range work {
+				s := newSweeper(bounds[i])
+				if s, ok := s.(arm64Sweeper); ok {
+					s.setDecoder(dec)
+				}
+				c, err := sweepChunk(s, bounds[i+1], t)
+				if err != nil {
+					// The context is done: the error is returned below.
+					return
+				}
+				chunks[i] = c
+			}
+		})
+	}
+	wg.Wait()
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	opts.logger().Debug("swept chunks in parallel",
+		"size", n, "chunks", len(chunks), "workers", opts.Concurrency)
+
+	return mergeChunks(chunks, bounds), nil
+}
+
+// sweepWindows drives s over the code read from src.r, holding at most one
+// window of code in memory at a time. Each window is extended by
+// windowLookahead bytes and swept until the next instruction starts past
+// the window; the following window is read from that instruction onwards.
+// The decoding state is carried across windows, so the results are the same
+// as sweeping the whole code at once.
+func sweepWindows[T any](src source, s sweeper[T], t *tracker) ([]T, error) {
+	buf := make([]byte, src.window+windowLookahead)
+
+	var result []T
+	for start := 0; start < src.size; {
+		n := min(len(buf), src.size-start)
+		if _, err := src.r.ReadAt(buf[:n], int64(start)); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read code at offset 0x%x: %w", start, err)
+		}
+
+		end := n
+		if start+n < src.size {
+			end = src.window
+		}
+
+		s.shift(buf[:n])
+		for s.pos() < end {
+			result, _ = s.step(result)
+		}
+
+		if err := t.advance(s.pos()); err != nil {
+			return nil, err
+		}
+		start += s.pos()
+	}
+
+	return result, nil
+}
+
+// sweptChunk holds the outcome of sweeping one chunk from its start offset
+// with a fresh sweeper.
+type sweptChunk[T any] struct {
+	// sweeper is the sweeper state after the last step of the chunk.
+	sweeper sweeper[T]
+	// steps holds the start offsets of the first syncWindow steps.
+	steps []int
+	// tags holds the start offset of the step producing each result.
+	tags    []int
+	results []T
+}
+
+// sweepChunk drives s from its start offset until it reaches end, recording
+// the results and the offsets of its leading steps. It reports the bytes
+// covered to t every progressInterval bytes and stops with the context error
+// if the context of t is done.
+func sweepChunk[T any](s sweeper[T], end int, t *tracker) (sweptChunk[T], error) {
+	c := sweptChunk[T]{sweeper: s}
+	last := s.pos()
+	for s.pos() < end {
+		p := s.pos()
+		if len(c.steps) < syncWindow {
+			c.steps = append(c.steps, p)
+		}
+		n := len(c.results)
+		c.results, _ = s.step(c.results)
+		for range len(c.results) - n {
+			c.tags = append(c.tags, p)
+		}
+
+		if p := min(s.pos(), end); p-last >= progressInterval {
+			if err := t.advance(p - last); err != nil {
+				return c, err
+			}
+			last = p
+		}
+	}
+	if err := t.advance(max(end-last, 0)); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// mergeChunks reconciles chunks swept independently into the results of a
+// serial sweep. The first chunk starts from the true initial state. Each
+// following chunk started from a fresh state at an arbitrary offset, which
+// on variable-length instruction sets may not be an instruction boundary of
+// the serial sweep. The serial sweep is therefore continued into the chunk
+// until it performs a settled step at an offset the chunk sweep also
+// visited: from there on both sweeps are in the same state, and the chunk
+// results produced after that step are reused as-is.
+func mergeChunks[T any](chunks []sweptChunk[T], bounds []int) []T {
+	result := chunks[0].results
+	s := chunks[0].sweeper
+
+	var buf []T
+	for i := 1; i < len(chunks); i++ {
+		c := &chunks[i]
+		for s.pos() < bounds[i+1] {
+			p := s.pos()
+			var settled bool
+			buf, settled = s.step(buf[:0])
+			result = append(result, buf...)
+			if !settled {
+				continue
+			}
+			if _, found := slices.BinarySearch(c.steps, p); found {
+				k := sort.Search(len(c.tags), func(k int) bool { return c.tags[k] > p })
+				result = append(result, c.results[k:]...)
+				s = c.sweeper
+				break
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,96 @@
+package resurgo_test
+
+import (
+	"debug/elf"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+// assertParallelMatchesSerial runs prologue and call site detection on code
+// serially and concurrently with the given chunk size, and asserts that both
+// produce the same output.
+func assertParallelMatchesSerial(t *testing.T, code []byte, baseAddr uint64, arch resurgo.Arch, chunkSize int) {
+	t.Helper()
+
+	serial := resurgo.Options{IncludeConditionalJumps: true}
+	parallel := resurgo.Options{IncludeConditionalJumps: true, Concurrency: 4, ChunkSize: chunkSize}
+
+	wantPrologues, err := resurgo.DetectProloguesWithOptions(code, baseAddr, arch, serial)
+	if err != nil {
+		t.Fatalf("serial DetectPrologues: %v", err)
+	}
+	gotPrologues, err := resurgo.DetectProloguesWithOptions(code, baseAddr, arch, parallel)
+	if err != nil {
+		t.Fatalf("parallel DetectPrologues: %v", err)
+	}
+	if !slices.Equal(gotPrologues, wantPrologues) {
+		t.Errorf("chunk size %d: prologues differ: got %d, want %d", chunkSize, len(gotPrologues), len(wantPrologues))
+	}
+
+	wantEdges, err := resurgo.DetectCallSitesWithOptions(code, baseAddr, arch, serial)
+	if err != nil {
+		t.Fatalf("serial DetectCallSites: %v", err)
+	}
+	gotEdges, err := resurgo.DetectCallSitesWithOptions(code, baseAddr, arch, parallel)
+	if err != nil {
+		t.Fatalf("parallel DetectCallSites: %v", err)
+	}
+	if !slices.Equal(gotEdges, wantEdges) {
+		t.Errorf("chunk size %d: call sites differ: got %d, want %d", chunkSize, len(gotEdges), len(wantEdges))
+	}
+}
+
+func TestConcurrency_Synthetic(t *testing.T) {
+	tests := []struct {
+		name  string
+		arch  resurgo.Arch
+		build func() ([]byte, uint64)
+	}{
+		{name: "amd64", arch: resurgo.ArchAMD64, build: buildSyntheticAMD64},
+		{name: "arm64", arch: resurgo.ArchARM64, build: buildSyntheticARM64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, base := tt.build()
+			for _, chunkSize := range []int{1, 3, 4, 7, 16, 33, 64, 0x100} {
+				assertParallelMatchesSerial(t, code, base, tt.arch, chunkSize)
+			}
+		})
+	}
+}
+
+func TestConcurrency_ELF(t *testing.T) {
+	for _, goarch := range []string{"amd64", "arm64"} {
+		t.Run(goarch, func(t *testing.T) {
+			binPath := filepath.Join(t.TempDir(), demoAppBinary)
+			cmd := exec.Command("go", "build", "-o", binPath, demoAppSource)
+			cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOARCH="+goarch)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("failed to compile demo-app: %v\n%s", err, out)
+			}
+
+			f, err := elf.Open(binPath)
+			if err != nil {
+				t.Fatalf("failed to open compiled binary: %v", err)
+			}
+			defer f.Close()
+
+			text := f.Section(".text")
+			code, err := text.Data()
+			if err != nil {
+				t.Fatalf("failed to read .text: %v", err)
+			}
+
+			arch := resurgo.Arch(goarch)
+			for _, chunkSize := range []int{4093, 1 << 16} {
+				assertParallelMatchesSerial(t, code, text.Addr, arch, chunkSize)
+			}
+		})
+	}
+}
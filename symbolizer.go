@@ -0,0 +1,243 @@
+package resurgo
+
+import (
+	"bufio"
+	"cmp"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Symbol is the function containing an address, as returned by
+// Symbolizer.Lookup.
+type Symbol struct {
+	// Start is the entry of the function.
+	Start uint64 `json:"start"`
+	// Offset is the distance of the address from Start.
+	Offset uint64 `json:"offset"`
+	// Name is the name of the function, or sub_ followed by its address.
+	Name       string     `json:"name"`
+	Confidence Confidence `json:"confidence"`
+}
+
+// Symbolizer maps addresses to the functions containing them. A function
+// extends up to its size, when known, or up to the next function
+// otherwise; the last function without a size has no end.
+type Symbolizer struct {
+	// The functions, sorted by start. A size of 0 is unknown, and an empty
+	// name is sub_ followed by the start.
+	starts     []uint64
+	sizes      []uint64
+	names      []string
+	confidence []Confidence
+}
+
+// NewSymbolizer returns the Symbolizer of candidates, as returned by
+// DetectFunctions, and of the function symbols of syms, as returned by
+// elf.File.Symbols. syms may be nil. As for MiniDebugInfo, a symbol names
+// and sizes the candidate at its address and raises it to high confidence,
+// and symbols without a candidate are added with high confidence.
+func NewSymbolizer(candidates []FunctionCandidate, syms []elf.Symbol) *Symbolizer {
+	candidates = slices.Clone(candidates)
+	index := make(map[uint64]int, len(candidates))
+	for i, c := range candidates {
+		if _, ok := index[c.Address]; !ok {
+			index[c.Address] = i
+		}
+	}
+	for _, s := range syms {
+		if elf.ST_TYPE(s.Info) != elf.STT_FUNC || s.Section == elf.SHN_UNDEF || s.Value == 0 {
+			continue
+		}
+		if i, ok := index[s.Value]; ok {
+			candidates[i].Name = s.Name
+			candidates[i].Size = s.Size
+			candidates[i].Confidence = ConfidenceHigh
+			continue
+		}
+		index[s.Value] = len(candidates)
+		candidates = append(candidates, FunctionCandidate{
+			Address:    s.Value,
+			Name:       s.Name,
+			Size:       s.Size,
+			Confidence: ConfidenceHigh,
+		})
+	}
+
+	candidates = sortedFunctions(candidates)
+	s := &Symbolizer{
+		starts:     make([]uint64, len(candidates)),
+		sizes:      make([]uint64, len(candidates)),
+		names:      make([]string, len(candidates)),
+		confidence: make([]Confidence, len(candidates)),
+	}
+	for i, c := range candidates {
+		s.starts[i] = c.Address
+		s.sizes[i] = c.Size
+		s.confidence[i] = c.Confidence
+		if c.Name != "" && c.Name != symbolName(FunctionCandidate{Address: c.Address}) {
+			s.names[i] = c.Name
+		}
+	}
+	return s
+}
+
+// Len returns the number of functions of s.
+func (s *Symbolizer) Len() int {
+	return len(s.starts)
+}
+
+// Lookup returns the function containing pc, and false if pc lies before
+// the first function or past the end of the function preceding it.
+func (s *Symbolizer) Lookup(pc uint64) (Symbol, bool) {
+	i, found := slices.BinarySearch(s.starts, pc)
+	if !found {
+		i--
+	}
+	return s.symbol(i, pc)
+}
+
+// symbol returns the symbol of pc in the function at position i, and false
+// if there is none or pc lies past its end.
+func (s *Symbolizer) symbol(i int, pc uint64) (Symbol, bool) {
+	if i < 0 || pc-s.starts[i] >= s.sizes[i] && s.sizes[i] != 0 {
+		return Symbol{}, false
+	}
+	start := s.starts[i]
+	name := s.names[i]
+	if name == "" {
+		name = symbolName(FunctionCandidate{Address: start})
+	}
+	return Symbol{Start: start, Offset: pc - start, Name: name, Confidence: s.confidence[i]}, true
+}
+
+// LookupAll looks up each address of pcs, like Lookup, and returns their
+// symbols in the same order; addresses without a function get the zero
+// Symbol, whose Name is empty. Addresses are sorted once and matched in a
+// single pass over the functions.
+func (s *Symbolizer) LookupAll(pcs []uint64) []Symbol {
+	order := make([]int, len(pcs))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int { return cmp.Compare(pcs[a], pcs[b]) })
+
+	symbols := make([]Symbol, len(pcs))
+	fn := -1
+	for _, i := range order {
+		pc := pcs[i]
+		for fn+1 < len(s.starts) && s.starts[fn+1] <= pc {
+			fn++
+		}
+		if sym, ok := s.symbol(fn, pc); ok {
+			symbols[i] = sym
+		}
+	}
+	return symbols
+}
+
+// The serialized form of a Symbolizer starts with symbolizerMagic and
+// symbolizerVersion. maxSymbolName bounds the length of the names read.
+const (
+	symbolizerMagic   = "RSYM"
+	symbolizerVersion = 1
+	maxSymbolName     = 1 << 16
+)
+
+// confidenceCodes are the encodings of confidence levels in the serialized
+// form of a Symbolizer, by position.
+var confidenceCodes = []Confidence{"", ConfidenceNone, ConfidenceLow, ConfidenceMedium, ConfidenceHigh}
+
+// WriteTo writes s to w in a compact binary form read by ReadSymbolizer:
+// a header, then for each function the distance from the previous start,
+// the size, the confidence and the name, as varints and length-prefixed
+// strings. Names of the form sub_ followed by the start are left out.
+func (s *Symbolizer) WriteTo(w io.Writer) (int64, error) {
+	buf := []byte(symbolizerMagic)
+	buf = append(buf, symbolizerVersion)
+	buf = binary.AppendUvarint(buf, uint64(len(s.starts)))
+	var prev uint64
+	for i, start := range s.starts {
+		buf = binary.AppendUvarint(buf, start-prev)
+		buf = binary.AppendUvarint(buf, s.sizes[i])
+		buf = append(buf, byte(max(slices.Index(confidenceCodes, s.confidence[i]), 0)))
+		buf = binary.AppendUvarint(buf, uint64(len(s.names[i])))
+		buf = append(buf, s.names[i]...)
+		prev = start
+	}
+	n, err := w.Write(buf)
+	if err != nil {
+		return int64(n), fmt.Errorf("failed to write symbolizer: %w", err)
+	}
+	return int64(n), nil
+}
+
+// ReadSymbolizer reads a Symbolizer written by Symbolizer.WriteTo from r.
+func ReadSymbolizer(r io.Reader) (*Symbolizer, error) {
+	br := bufio.NewReader(r)
+	wrap := func(err error) error {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("failed to read symbolizer: %w", err)
+	}
+
+	header := make([]byte, len(symbolizerMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, wrap(err)
+	}
+	if string(header[:len(symbolizerMagic)]) != symbolizerMagic {
+		return nil, fmt.Errorf("not a serialized symbolizer")
+	}
+	if v := header[len(symbolizerMagic)]; v != symbolizerVersion {
+		return nil, fmt.Errorf("unsupported symbolizer version: %d", v)
+	}
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, wrap(err)
+	}
+
+	s := &Symbolizer{}
+	var start uint64
+	for i := range n {
+		delta, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, wrap(err)
+		}
+		if delta == 0 && i > 0 {
+			return nil, fmt.Errorf("failed to read symbolizer: unsorted functions")
+		}
+		size, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, wrap(err)
+		}
+		code, err := br.ReadByte()
+		if err != nil {
+			return nil, wrap(err)
+		}
+		if int(code) >= len(confidenceCodes) {
+			return nil, fmt.Errorf("failed to read symbolizer: invalid confidence %d", code)
+		}
+		nameLen, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, wrap(err)
+		}
+		if nameLen > maxSymbolName {
+			return nil, fmt.Errorf("failed to read symbolizer: name too long: %d", nameLen)
+		}
+		name := make([]byte, nameLen)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return nil, wrap(err)
+		}
+
+		start += delta
+		s.starts = append(s.starts, start)
+		s.sizes = append(s.sizes, size)
+		s.confidence = append(s.confidence, confidenceCodes[code])
+		s.names = append(s.names, string(name))
+	}
+	return s, nil
+}
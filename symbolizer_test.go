@@ -0,0 +1,104 @@
+package resurgo_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"slices"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+func testSymbolizer() *resurgo.Symbolizer {
+	candidates := []resurgo.FunctionCandidate{
+		{Address: 0x1200, Confidence: resurgo.ConfidenceMedium},
+		{Address: 0x1000, Confidence: resurgo.ConfidenceHigh},
+		{Address: 0x1100, Name: "helper", Confidence: resurgo.ConfidenceLow},
+	}
+	syms := []elf.Symbol{
+		// Names and sizes the candidate at 0x1000.
+		{Name: "main", Info: elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC), Section: 14, Value: 0x1000, Size: 0x40},
+		// A function without a candidate.
+		{Name: "tail", Info: elf.ST_INFO(elf.STB_LOCAL, elf.STT_FUNC), Section: 14, Value: 0x1300, Size: 0x10},
+		// Not functions.
+		{Name: "data", Info: elf.ST_INFO(elf.STB_GLOBAL, elf.STT_OBJECT), Section: 20, Value: 0x1180},
+		{Name: "puts", Info: elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC), Section: elf.SHN_UNDEF},
+	}
+	return resurgo.NewSymbolizer(candidates, syms)
+}
+
+func TestSymbolizer_Lookup(t *testing.T) {
+	s := testSymbolizer()
+	if s.Len() != 4 {
+		t.Fatalf("expected 4 functions, got %d", s.Len())
+	}
+
+	tests := []struct {
+		pc   uint64
+		want resurgo.Symbol
+		ok   bool
+	}{
+		{pc: 0xfff},
+		{pc: 0x1000, want: resurgo.Symbol{Start: 0x1000, Name: "main", Confidence: resurgo.ConfidenceHigh}, ok: true},
+		{pc: 0x103f, want: resurgo.Symbol{Start: 0x1000, Offset: 0x3f, Name: "main", Confidence: resurgo.ConfidenceHigh}, ok: true},
+		// Past the size of main.
+		{pc: 0x1040},
+		// Unsized functions extend up to the next one.
+		{pc: 0x11ff, want: resurgo.Symbol{Start: 0x1100, Offset: 0xff, Name: "helper", Confidence: resurgo.ConfidenceLow}, ok: true},
+		{pc: 0x1210, want: resurgo.Symbol{Start: 0x1200, Offset: 0x10, Name: "sub_1200", Confidence: resurgo.ConfidenceMedium}, ok: true},
+		{pc: 0x130f, want: resurgo.Symbol{Start: 0x1300, Offset: 0xf, Name: "tail", Confidence: resurgo.ConfidenceHigh}, ok: true},
+		{pc: 0x1310},
+	}
+	for _, tt := range tests {
+		got, ok := s.Lookup(tt.pc)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("Lookup(0x%x): expected %+v (%v), got %+v (%v)", tt.pc, tt.want, tt.ok, got, ok)
+		}
+	}
+
+	pcs := make([]uint64, 0, len(tests))
+	for _, tt := range slices.Backward(tests) {
+		pcs = append(pcs, tt.pc)
+	}
+	all := s.LookupAll(pcs)
+	for i, pc := range pcs {
+		if want, _ := s.Lookup(pc); all[i] != want {
+			t.Errorf("LookupAll: 0x%x: expected %+v, got %+v", pc, want, all[i])
+		}
+	}
+}
+
+func TestSymbolizer_Serialize(t *testing.T) {
+	s := testSymbolizer()
+
+	var buf bytes.Buffer
+	n, err := s.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo: expected %d bytes written, got %d", buf.Len(), n)
+	}
+	data := slices.Clone(buf.Bytes())
+
+	got, err := resurgo.ReadSymbolizer(&buf)
+	if err != nil {
+		t.Fatalf("ReadSymbolizer: %v", err)
+	}
+	if got.Len() != s.Len() {
+		t.Fatalf("expected %d functions, got %d", s.Len(), got.Len())
+	}
+	for pc := uint64(0xff0); pc < 0x1320; pc += 8 {
+		want, wantOK := s.Lookup(pc)
+		if sym, ok := got.Lookup(pc); sym != want || ok != wantOK {
+			t.Errorf("Lookup(0x%x): expected %+v (%v), got %+v (%v)", pc, want, wantOK, sym, ok)
+		}
+	}
+
+	if _, err := resurgo.ReadSymbolizer(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Error("expected an error for truncated data")
+	}
+	if _, err := resurgo.ReadSymbolizer(bytes.NewReader([]byte("ELF\x7f\x01"))); err == nil {
+		t.Error("expected an error for a bad magic")
+	}
+}
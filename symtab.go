@@ -0,0 +1,231 @@
+package resurgo
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// symbolName returns the name of the function of c: its name, when known,
+// or sub_ followed by its address.
+func symbolName(c FunctionCandidate) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return fmt.Sprintf("sub_%x", c.Address)
+}
+
+// sortedFunctions returns a copy of candidates sorted by address, keeping
+// the first candidate at each address.
+func sortedFunctions(candidates []FunctionCandidate) []FunctionCandidate {
+	candidates = slices.Clone(candidates)
+	slices.SortStableFunc(candidates, func(a, b FunctionCandidate) int {
+		return cmp.Compare(a.Address, b.Address)
+	})
+	return slices.CompactFunc(candidates, func(a, b FunctionCandidate) bool {
+		return a.Address == b.Address
+	})
+}
+
+// functionSize returns the size of the function of candidates[i], with
+// candidates sorted by address: the candidate size when known, or the
+// distance to the next candidate or to end, whichever comes first. It is
+// zero when the candidate does not lie below end.
+func functionSize(candidates []FunctionCandidate, i int, end uint64) uint64 {
+	c := candidates[i]
+	if c.Size != 0 {
+		return c.Size
+	}
+	if i+1 < len(candidates) && candidates[i+1].Address < end {
+		end = candidates[i+1].Address
+	}
+	if end <= c.Address {
+		return 0
+	}
+	return end - c.Address
+}
+
+// WriteELFSymbols writes to w a copy of the 64-bit ELF executable or shared
+// object of the given size read from r, with a symbol table holding a
+// function symbol for each candidate, so that tools such as perf, gdb,
+// objdump and addr2line name recovered functions.
+//
+// Symbols are named after the candidate, or sub_ followed by its address,
+// and sized after the candidate, or up to the next candidate or the end of
+// the section holding it. The new .symtab, .strtab, a copy of .shstrtab and
+// the section header table are appended to the file, and the ELF header is
+// updated to point to them; every other byte is left unchanged. Binaries
+// that already have a symbol table are rejected.
+func WriteELFSymbols(w io.Writer, r io.ReaderAt, size int64, candidates []FunctionCandidate) error {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return fmt.Errorf("failed to parse ELF file: %w", err)
+	}
+	if f.Class != elf.ELFCLASS64 {
+		return fmt.Errorf("unsupported ELF class: %s", f.Class)
+	}
+	if f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN {
+		return fmt.Errorf("not an ELF executable or shared object: %s", f.Type)
+	}
+	if f.SectionByType(elf.SHT_SYMTAB) != nil {
+		return fmt.Errorf("ELF file already has a symbol table")
+	}
+
+	var hdr elf.Header64
+	if err := binary.Read(io.NewSectionReader(r, 0, size), f.ByteOrder, &hdr); err != nil {
+		return fmt.Errorf("failed to read ELF header: %w", err)
+	}
+	if len(f.Sections) > 0 && hdr.Shentsize != uint16(binary.Size(elf.Section64{})) {
+		return fmt.Errorf("unsupported section header size: %d", hdr.Shentsize)
+	}
+
+	// The section headers, as in the file.
+	shdrs := make([]elf.Section64, len(f.Sections))
+	for i := range shdrs {
+		off := int64(hdr.Shoff) + int64(i)*int64(hdr.Shentsize)
+		if err := binary.Read(io.NewSectionReader(r, off, int64(hdr.Shentsize)), f.ByteOrder, &shdrs[i]); err != nil {
+			return fmt.Errorf("failed to read section header %d: %w", i, err)
+		}
+	}
+	if len(shdrs) == 0 {
+		shdrs = append(shdrs, elf.Section64{})
+	}
+
+	// The section names, extended with the names of the new sections.
+	shstrndx := int(hdr.Shstrndx)
+	if hdr.Shstrndx == uint16(elf.SHN_XINDEX) {
+		shstrndx = int(shdrs[0].Link)
+	}
+	if shstrndx == int(elf.SHN_UNDEF) || shstrndx >= len(f.Sections) {
+		shstrndx = -1
+	}
+	var shstrtab []byte
+	if shstrndx >= 0 {
+		sh := shdrs[shstrndx]
+		shstrtab = make([]byte, sh.Size)
+		if _, err := r.ReadAt(shstrtab, int64(sh.Off)); err != nil {
+			return fmt.Errorf("failed to read .shstrtab section: %w", err)
+		}
+	} else {
+		shstrndx = len(shdrs)
+		shstrtab = []byte{0}
+		shdrs = append(shdrs, elf.Section64{Type: uint32(elf.SHT_STRTAB), Addralign: 1})
+		shdrs[shstrndx].Name = uint32(len(shstrtab))
+		shstrtab = append(shstrtab, ".shstrtab\x00"...)
+	}
+	symtabName := uint32(len(shstrtab))
+	shstrtab = append(shstrtab, ".symtab\x00"...)
+	strtabName := uint32(len(shstrtab))
+	shstrtab = append(shstrtab, ".strtab\x00"...)
+
+	symtab, strtab := elfSymbols(f, candidates)
+
+	// Layout of the appended data.
+	align := func(off, n uint64) uint64 { return (off + n - 1) &^ (n - 1) }
+	shstrtabOff := uint64(size)
+	strtabOff := shstrtabOff + uint64(len(shstrtab))
+	symtabOff := align(strtabOff+uint64(len(strtab)), 8)
+	shOff := align(symtabOff+uint64(len(symtab)), 8)
+
+	shdrs[shstrndx].Off = shstrtabOff
+	shdrs[shstrndx].Size = uint64(len(shstrtab))
+	strtabIndex := len(shdrs) + 1
+	shdrs = append(shdrs,
+		elf.Section64{
+			Name: symtabName, Type: uint32(elf.SHT_SYMTAB), Off: symtabOff, Size: uint64(len(symtab)),
+			Link: uint32(strtabIndex), Info: 1, Addralign: 8, Entsize: uint64(elf.Sym64Size),
+		},
+		elf.Section64{
+			Name: strtabName, Type: uint32(elf.SHT_STRTAB), Off: strtabOff, Size: uint64(len(strtab)),
+			Addralign: 1,
+		},
+	)
+
+	hdr.Shoff = shOff
+	hdr.Shentsize = uint16(binary.Size(elf.Section64{}))
+	hdr.Shnum = uint16(len(shdrs))
+	hdr.Shstrndx = uint16(shstrndx)
+	// Extended section numbering: the count and the index of .shstrtab
+	// move to the first section header.
+	if len(shdrs) >= int(elf.SHN_LORESERVE) {
+		hdr.Shnum = 0
+		shdrs[0].Size = uint64(len(shdrs))
+	}
+	if shstrndx >= int(elf.SHN_LORESERVE) {
+		hdr.Shstrndx = uint16(elf.SHN_XINDEX)
+		shdrs[0].Link = uint32(shstrndx)
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := binary.Write(bw, f.ByteOrder, hdr); err != nil {
+		return fmt.Errorf("failed to write ELF header: %w", err)
+	}
+	ehsize := int64(binary.Size(hdr))
+	if _, err := io.Copy(bw, io.NewSectionReader(r, ehsize, size-ehsize)); err != nil {
+		return fmt.Errorf("failed to copy ELF file: %w", err)
+	}
+	bw.Write(shstrtab)
+	bw.Write(strtab)
+	bw.Write(make([]byte, symtabOff-(strtabOff+uint64(len(strtab)))))
+	bw.Write(symtab)
+	bw.Write(make([]byte, shOff-(symtabOff+uint64(len(symtab)))))
+	for _, sh := range shdrs {
+		binary.Write(bw, f.ByteOrder, sh)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write ELF file: %w", err)
+	}
+	return nil
+}
+
+// elfSectionOf returns the index of the section of f loaded at addr,
+// preferring executable sections, or -1.
+func elfSectionOf(f *elf.File, addr uint64) int {
+	index := -1
+	for i, sec := range f.Sections {
+		if sec.Flags&elf.SHF_ALLOC == 0 || sec.Type == elf.SHT_NOBITS ||
+			addr < sec.Addr || addr-sec.Addr >= sec.Size {
+			continue
+		}
+		if sec.Flags&elf.SHF_EXECINSTR != 0 {
+			return i
+		}
+		if index < 0 {
+			index = i
+		}
+	}
+	return index
+}
+
+// elfSymbols returns the symbol table and string table encoding a global
+// function symbol for each candidate, in address order, after the null
+// symbol.
+func elfSymbols(f *elf.File, candidates []FunctionCandidate) (symtab, strtab []byte) {
+	candidates = sortedFunctions(candidates)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, f.ByteOrder, elf.Sym64{})
+	strtab = []byte{0}
+	for i, c := range candidates {
+		sym := elf.Sym64{
+			Name:  uint32(len(strtab)),
+			Info:  elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC),
+			Shndx: uint16(elf.SHN_ABS),
+			Value: c.Address,
+			Size:  c.Size,
+		}
+		if index := elfSectionOf(f, c.Address); index >= 0 && index < int(elf.SHN_LORESERVE) {
+			sym.Shndx = uint16(index)
+			sym.Size = functionSize(candidates, i, f.Sections[index].Addr+f.Sections[index].Size)
+		}
+		strtab = append(strtab, symbolName(c)...)
+		strtab = append(strtab, 0)
+		binary.Write(&buf, f.ByteOrder, sym)
+	}
+	return buf.Bytes(), strtab
+}
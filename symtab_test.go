@@ -0,0 +1,121 @@
+package resurgo_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+func TestWriteELFSymbols(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found, skipping")
+	}
+
+	dir := t.TempDir()
+	binPath := filepath.Join(dir, "demo-app")
+	cmd := exec.Command("gcc", "-O2", "-o", binPath, "testdata/demo-app.c")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile testdata/demo-app.c: %v\n%s", err, out)
+	}
+	stripped := filepath.Join(dir, "demo-app.stripped")
+	cmd = exec.Command("gcc", "-O2", "-s", "-o", stripped, "testdata/demo-app.c")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile testdata/demo-app.c: %v\n%s", err, out)
+	}
+
+	orig, err := os.ReadFile(stripped)
+	if err != nil {
+		t.Fatalf("failed to read stripped binary: %v", err)
+	}
+	candidates, err := resurgo.DetectFunctionsFromELF(bytes.NewReader(orig))
+	if err != nil {
+		t.Fatalf("DetectFunctionsFromELF: %v", err)
+	}
+	if len(candidates) < 2 {
+		t.Fatalf("expected candidates, got %d", len(candidates))
+	}
+	// The first candidate is named and sized explicitly.
+	candidates[0].Name = "first"
+	candidates[0].Size = 3
+
+	var out bytes.Buffer
+	if err := resurgo.WriteELFSymbols(&out, bytes.NewReader(orig), int64(len(orig)), candidates); err != nil {
+		t.Fatalf("WriteELFSymbols: %v", err)
+	}
+
+	// Past the ELF header, the original bytes are left unchanged.
+	const ehsize = 64
+	if !bytes.Equal(out.Bytes()[ehsize:len(orig)], orig[ehsize:]) {
+		t.Error("expected the original bytes to be unchanged")
+	}
+
+	f, err := elf.NewFile(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to parse written ELF file: %v", err)
+	}
+	origFile, err := elf.NewFile(bytes.NewReader(orig))
+	if err != nil {
+		t.Fatalf("failed to parse ELF file: %v", err)
+	}
+	for _, sec := range origFile.Sections {
+		if sec.Name == ".shstrtab" {
+			continue // extended with the new section names
+		}
+		if got := f.Section(sec.Name); got == nil || got.Offset != sec.Offset || got.Size != sec.Size {
+			t.Errorf("section %s: expected %+v, got %+v", sec.Name, sec.SectionHeader, got)
+		}
+	}
+	text := f.Section(".text")
+
+	syms, err := f.Symbols()
+	if err != nil {
+		t.Fatalf("failed to read symbols: %v", err)
+	}
+	if len(syms) != len(candidates) {
+		t.Fatalf("expected %d symbols, got %d", len(candidates), len(syms))
+	}
+	for i, s := range syms {
+		c := candidates[i]
+		name := fmt.Sprintf("sub_%x", c.Address)
+		if i == 0 {
+			name = "first"
+		}
+		if s.Name != name || s.Value != c.Address || elf.ST_TYPE(s.Info) != elf.STT_FUNC {
+			t.Errorf("symbol %d: expected %s at 0x%x, got %+v", i, name, c.Address, s)
+		}
+		if s.Section != elf.SHN_ABS && f.Sections[s.Section] != text {
+			continue
+		}
+		// Unsized symbols extend up to the next candidate.
+		want := uint64(3)
+		if i > 0 {
+			want = text.Addr + text.Size - c.Address
+			if i+1 < len(candidates) && candidates[i+1].Address < text.Addr+text.Size {
+				want = candidates[i+1].Address - c.Address
+			}
+		}
+		if s.Size != want {
+			t.Errorf("symbol %s: expected size %d, got %d", s.Name, want, s.Size)
+		}
+	}
+
+	// Binaries with a symbol table are rejected.
+	unstripped, err := os.Open(binPath)
+	if err != nil {
+		t.Fatalf("failed to open binary: %v", err)
+	}
+	defer unstripped.Close()
+	st, err := unstripped.Stat()
+	if err != nil {
+		t.Fatalf("failed to stat binary: %v", err)
+	}
+	if err := resurgo.WriteELFSymbols(&out, unstripped, st.Size(), candidates); err == nil {
+		t.Error("expected an error for a binary with a symbol table")
+	}
+}
@@ -0,0 +1,357 @@
+package resurgo
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// JumpKind classifies a jump call site once the extents of functions are
+// known.
+type JumpKind string
+
+// Recognized jump kinds.
+const (
+	// JumpTailCall is a jump to another function, taken with the stack
+	// pointer restored to its value at the entry of the jumping function.
+	JumpTailCall JumpKind = "tail-call"
+	// JumpInternal is a jump within the jumping function, such as a loop
+	// back-edge or a forward branch.
+	JumpInternal JumpKind = "internal"
+)
+
+// ClassifyJumps returns a copy of edges, detected in code, with the
+// JumpKind of the jumps set given the function candidates detected in code.
+// A function extends from a candidate of at least medium confidence or after
+// padding, or from the start of code, to the next one. A jump is internal when its target lies
+// within the extent of its source, or when the stack pointer is not restored
+// at the jump; it is a tail call otherwise. DetectFunctions classifies jumps
+// the same way, and only promotes tail-call targets to candidates.
+func ClassifyJumps(code []byte, baseAddr uint64, arch Arch, edges []CallSiteEdge, candidates []FunctionCandidate) ([]CallSiteEdge, error) {
+	edges = slices.Clone(edges)
+	sections := []codeSection{{addr: baseAddr, src: memSource(code)}}
+	if err := classifyJumps(context.Background(), sections, edges, functionEntries(candidates), arch, nil, Options{}); err != nil {
+		return nil, err
+	}
+	return edges, nil
+}
+
+// functionEntries returns the sorted addresses of the candidates of at
+// least medium confidence or after padding, which delimit function extents.
+func functionEntries(candidates []FunctionCandidate) []uint64 {
+	var entries []uint64
+	for _, c := range candidates {
+		if c.Confidence.rank() >= ConfidenceMedium.rank() || c.AfterPadding {
+			entries = append(entries, c.Address)
+		}
+	}
+	slices.Sort(entries)
+	return slices.Compact(entries)
+}
+
+// classifyEvidence classifies the jumps in ev, delimiting functions with
+// the candidates merged from the other evidence.
+func classifyEvidence(ctx context.Context, sections []codeSection, ev *evidence, arch Arch, noreturn noReturnSet, opts Options) error {
+	calls := *ev
+	calls.edges = slices.DeleteFunc(slices.Clone(ev.edges), func(e CallSiteEdge) bool {
+		return e.Type == CallSiteJump
+	})
+	entries := functionEntries(mergeCandidates(calls, functionAlignment(arch)))
+	return classifyJumps(ctx, sections, ev.edges, entries, arch, noreturn, opts)
+}
+
+// classifyJumps sets the JumpKind of the jumps in edges whose source lies in
+// sections, given the sorted function entries. The start of each section is
+// a function entry as well.
+func classifyJumps(ctx context.Context, sections []codeSection, edges []CallSiteEdge, entries []uint64, arch Arch, noreturn noReturnSet, opts Options) error {
+	var decode func(code []byte, addr uint64, st *stackState, kernel *kernelThunks) flow
+	switch arch {
+	case ArchAMD64:
+		decode = stackFlowAMD64
+	case ArchARM64:
+		decode = stackFlowARM64
+	default:
+		return fmt.Errorf("unsupported architecture: %s", arch)
+	}
+
+	// Indices of the jumps with a known target, by source address.
+	var jumps []int
+	for i, e := range edges {
+		if e.Type == CallSiteJump && e.Confidence != ConfidenceNone {
+			jumps = append(jumps, i)
+		}
+	}
+	slices.SortFunc(jumps, func(a, b int) int {
+		return cmp.Compare(edges[a].SourceAddr, edges[b].SourceAddr)
+	})
+
+	tailCalls := 0
+	for i := range sections {
+		sec := &sections[i]
+		end := sec.addr + uint64(sec.src.size)
+		lo, _ := slices.BinarySearchFunc(jumps, sec.addr, func(j int, addr uint64) int {
+			return cmp.Compare(edges[j].SourceAddr, addr)
+		})
+		hi, _ := slices.BinarySearchFunc(jumps, end, func(j int, addr uint64) int {
+			return cmp.Compare(edges[j].SourceAddr, addr)
+		})
+
+		for k := lo; k < hi; {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			// The extent of the function holding the next jump, and the
+			// jumps within it.
+			src := edges[jumps[k]].SourceAddr
+			start, fnEnd := sec.addr, end
+			if n, found := slices.BinarySearch(entries, src); found {
+				start = src
+				if n+1 < len(entries) {
+					fnEnd = min(fnEnd, entries[n+1])
+				}
+			} else {
+				if n > 0 {
+					start = max(start, entries[n-1])
+				}
+				if n < len(entries) {
+					fnEnd = min(fnEnd, entries[n])
+				}
+			}
+			next := k
+			for next < hi && edges[jumps[next]].SourceAddr < fnEnd {
+				next++
+			}
+
+			fn := functionExtent{start: start, end: fnEnd, sec: sec}
+			n, err := classifyExtent(fn, edges, jumps[k:next], decode, noreturn, opts.kernel)
+			if err != nil {
+				return err
+			}
+			tailCalls += n
+			k = next
+		}
+	}
+
+	opts.logger().Debug("classified jumps",
+		"arch", arch, "jumps", len(jumps), "tail_calls", tailCalls)
+
+	return nil
+}
+
+// classifyExtent classifies the jumps of edges at indices jumps, sorted by
+// source address, whose source lies in fn. It sweeps fn up to the last
+// jump, tracking the stack pointer, and returns the number of tail calls.
+func classifyExtent(fn functionExtent, edges []CallSiteEdge, jumps []int, decode func([]byte, uint64, *stackState, *kernelThunks) flow, noreturn noReturnSet, kernel *kernelThunks) (int, error) {
+	c := cursor{src: fn.sec.src}
+	off := fn.start - fn.sec.addr
+	last := edges[jumps[len(jumps)-1]].SourceAddr
+
+	// The stack state at the forward branch targets, picked up after
+	// instructions that do not fall through.
+	targets := make(map[uint64]stackState)
+	st := stackState{known: true}
+	terminal := false
+	tailCalls := 0
+	for addr := fn.start; addr <= last && len(jumps) > 0; {
+		if terminal {
+			st = targets[addr]
+		}
+
+		for len(jumps) > 0 && edges[jumps[0]].SourceAddr < addr {
+			// Not on an instruction boundary of this sweep.
+			jumps = jumps[1:]
+		}
+		for len(jumps) > 0 && edges[jumps[0]].SourceAddr == addr {
+			e := &edges[jumps[0]]
+			e.JumpKind = JumpTailCall
+			if (e.TargetAddr >= fn.start && e.TargetAddr < fn.end) || (st.known && st.depth != 0) {
+				e.JumpKind = JumpInternal
+			} else {
+				tailCalls++
+			}
+			jumps = jumps[1:]
+		}
+
+		code, err := c.at(int(off + addr - fn.start))
+		if err != nil {
+			return 0, err
+		}
+		f := decode(code, addr, &st, kernel)
+		if st.depth < 0 {
+			// Past the entry of the function: fn does not start there.
+			st.known = false
+		}
+		switch f.kind {
+		case flowJump, flowBranch:
+			if f.target > addr && f.target < fn.end {
+				if _, ok := targets[f.target]; !ok {
+					targets[f.target] = st
+				}
+			}
+		}
+		terminal = f.kind == flowJump || f.kind == flowReturn || f.kind == flowStop ||
+			(f.kind == flowCall && f.hasTarget && noreturn[f.target])
+		addr += uint64(f.n)
+	}
+
+	// Jumps not reached keep their target-only classification.
+	for _, j := range jumps {
+		e := &edges[j]
+		e.JumpKind = JumpTailCall
+		if e.TargetAddr >= fn.start && e.TargetAddr < fn.end {
+			e.JumpKind = JumpInternal
+		} else {
+			tailCalls++
+		}
+	}
+	return tailCalls, nil
+}
+
+// stackState tracks the stack pointer through a function.
+type stackState struct {
+	// depth is the number of bytes allocated on the stack since the entry
+	// of the function, if known is set.
+	depth int64
+	known bool
+	// fp is the depth the frame pointer was set at, if fpKnown is set.
+	fp      int64
+	fpKnown bool
+}
+
+// set sets the stack depth to depth, known if known is set.
+func (st *stackState) set(depth int64, known bool) {
+	st.depth, st.known = depth, known
+}
+
+// stackFlowAMD64 decodes the x86-64 instruction at the start of code, at
+// addr, applies its effect on the stack pointer to st and returns its
+// control transfer.
+func stackFlowAMD64(code []byte, addr uint64, st *stackState, kernel *kernelThunks) flow {
+	if len(code) >= 4 && code[0] == 0xf3 && code[1] == 0x0f && code[2] == 0x1e &&
+		(code[3] == 0xfa || code[3] == 0xfb) {
+		return flow{n: 4}
+	}
+	inst, err := x86asm.Decode(code, 64)
+	if err != nil {
+		st.set(0, false)
+		return flow{n: 1, kind: flowReturn}
+	}
+
+	switch inst.Op {
+	case x86asm.PUSH, x86asm.PUSHFQ:
+		st.depth += 8
+	case x86asm.POP, x86asm.POPFQ:
+		st.depth -= 8
+		switch inst.Args[0] {
+		case x86asm.RSP:
+			st.set(0, false)
+		case x86asm.RBP:
+			st.fpKnown = false
+		}
+	case x86asm.LEAVE:
+		st.set(st.fp-8, st.fpKnown)
+		st.fpKnown = false
+	case x86asm.SUB, x86asm.ADD:
+		if inst.Args[0] != x86asm.RSP {
+			break
+		}
+		imm, ok := inst.Args[1].(x86asm.Imm)
+		if !ok {
+			st.set(0, false)
+			break
+		}
+		if inst.Op == x86asm.SUB {
+			st.depth += int64(imm)
+		} else {
+			st.depth -= int64(imm)
+		}
+	case x86asm.MOV:
+		switch {
+		case inst.Args[0] == x86asm.RBP && inst.Args[1] == x86asm.RSP:
+			st.fp, st.fpKnown = st.depth, st.known
+		case inst.Args[0] == x86asm.RSP && inst.Args[1] == x86asm.RBP:
+			st.set(st.fp, st.fpKnown)
+		case inst.Args[0] == x86asm.RSP:
+			st.set(0, false)
+		}
+	case x86asm.LEA:
+		mem, ok := inst.Args[1].(x86asm.Mem)
+		if !ok || mem.Index != 0 {
+			if inst.Args[0] == x86asm.RSP {
+				st.set(0, false)
+			}
+			break
+		}
+		switch {
+		case inst.Args[0] == x86asm.RSP && mem.Base == x86asm.RSP:
+			st.depth -= mem.Disp
+		case inst.Args[0] == x86asm.RSP && mem.Base == x86asm.RBP:
+			st.set(st.fp-mem.Disp, st.fpKnown)
+		case inst.Args[0] == x86asm.RSP:
+			st.set(0, false)
+		case inst.Args[0] == x86asm.RBP && mem.Base == x86asm.RSP:
+			st.fp, st.fpKnown = st.depth-mem.Disp, st.known
+		}
+	case x86asm.AND:
+		// Stack realignment: the depth is only known again once restored
+		// from the frame pointer.
+		if inst.Args[0] == x86asm.RSP {
+			st.set(0, false)
+		}
+	}
+
+	return instFlowAMD64(inst, addr, kernel)
+}
+
+// stackFlowARM64 decodes the ARM64 instruction at the start of code, at
+// addr, applies its effect on the stack pointer to st and returns its
+// control transfer.
+func stackFlowARM64(code []byte, addr uint64, st *stackState, kernel *kernelThunks) flow {
+	if len(code) < 4 {
+		return flowARM64(code, addr, kernel)
+	}
+	w := binary.LittleEndian.Uint32(code)
+	const sp, fp = 31, 29
+	rd, rn := w&31, w>>5&31
+
+	switch {
+	case (w>>26 == 0x2a || w>>26 == 0x1b || w>>26 == 0x2b) && (w>>23&7 == 1 || w>>23&7 == 3) && rn == sp:
+		// stp/ldp with writeback: x, d and q register pairs.
+		scale := int64(8)
+		if w>>26 == 0x2b {
+			scale = 16
+		}
+		st.depth -= int64(int32(w<<10)>>25) * scale
+	case (w&0xffe00400 == 0xf8000400 || w&0xffe00400 == 0xf8400400) && rn == sp:
+		// str/ldr with writeback.
+		st.depth -= int64(int32(w<<11) >> 23)
+	case w>>23 == 0x122 || w>>23 == 0x1a2:
+		// add/sub immediate.
+		imm := int64(w >> 10 & 0xfff)
+		if w>>22&1 == 1 {
+			imm <<= 12
+		}
+		if w>>23 == 0x122 {
+			imm = -imm
+		}
+		// sp - imm is depth + imm, after the stack pointer or frame
+		// pointer.
+		switch {
+		case rd == sp && rn == sp:
+			st.depth += imm
+		case rd == sp && rn == fp:
+			st.set(st.fp+imm, st.fpKnown)
+		case rd == sp:
+			st.set(0, false)
+		case rd == fp && rn == sp:
+			st.fp, st.fpKnown = st.depth+imm, st.known
+		}
+	}
+
+	return flowARM64(code, addr, kernel)
+}
@@ -0,0 +1,122 @@
+package resurgo_test
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+// jumpKinds returns the JumpKind of the jumps in edges, by source address.
+func jumpKinds(edges []resurgo.CallSiteEdge) map[uint64]resurgo.JumpKind {
+	kinds := make(map[uint64]resurgo.JumpKind)
+	for _, e := range edges {
+		if e.Type == resurgo.CallSiteJump {
+			kinds[e.SourceAddr] = e.JumpKind
+		}
+	}
+	return kinds
+}
+
+func TestClassifyJumpsAMD64(t *testing.T) {
+	code := []byte{
+		0x55, 0x48, 0x89, 0xe5, // 0x00: push rbp; mov rbp, rsp
+		0x31, 0xc0, // 0x04: xor eax, eax
+		0xff, 0xc0, // 0x06: inc eax
+		0x83, 0xf8, 0x0a, // 0x08: cmp eax, 10
+		0x7d, 0x02, // 0x0b: jge 0x0f
+		0xeb, 0xf7, // 0x0d: jmp 0x06  - loop back-edge
+		0x5d,                         // 0x0f: pop rbp
+		0xe9, 0x0b, 0x00, 0x00, 0x00, // 0x10: jmp 0x20  - tail call
+		0xcc, 0xcc, 0xcc, 0xcc, 0xcc, 0xcc, 0xcc, 0xcc, 0xcc, 0xcc, 0xcc, // 0x15: int3 padding
+		0xc3,                                                                                     // 0x20: ret  - jump target without prologue
+		0xcc, 0xcc, 0xcc, 0xcc, 0xcc, 0xcc, 0xcc, 0xcc, 0xcc, 0xcc, 0xcc, 0xcc, 0xcc, 0xcc, 0xcc, // 0x21: int3 padding
+		0x53,                         // 0x30: push rbx
+		0xe9, 0xca, 0xff, 0xff, 0xff, // 0x31: jmp 0x00  - stack not restored
+		0x5b, 0xc3, // 0x36: pop rbx; ret
+	}
+
+	edges, err := resurgo.DetectCallSites(code, 0, resurgo.ArchAMD64)
+	if err != nil {
+		t.Fatalf("DetectCallSites: %v", err)
+	}
+	for _, e := range edges {
+		if e.JumpKind != "" {
+			t.Errorf("0x%x: expected unclassified jump, got %s", e.SourceAddr, e.JumpKind)
+		}
+	}
+
+	candidates, err := resurgo.DetectFunctions(code, 0, resurgo.ArchAMD64)
+	if err != nil {
+		t.Fatalf("DetectFunctions: %v", err)
+	}
+	classified, err := resurgo.ClassifyJumps(code, 0, resurgo.ArchAMD64, edges, candidates)
+	if err != nil {
+		t.Fatalf("ClassifyJumps: %v", err)
+	}
+
+	want := map[uint64]resurgo.JumpKind{
+		0x0d: resurgo.JumpInternal,
+		0x10: resurgo.JumpTailCall,
+		0x31: resurgo.JumpInternal,
+	}
+	if got := jumpKinds(classified); !maps.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	byAddr := make(map[uint64]resurgo.FunctionCandidate)
+	for _, c := range candidates {
+		byAddr[c.Address] = c
+	}
+	if _, ok := byAddr[0x06]; ok {
+		t.Error("0x06: loop head promoted to candidate")
+	}
+	if c, ok := byAddr[0x20]; !ok || !slices.Equal(c.JumpedFrom, []uint64{0x10}) {
+		t.Errorf("0x20: expected tail-call target jumped from 0x10, got %+v", c)
+	}
+	if c := byAddr[0x00]; len(c.JumpedFrom) != 0 {
+		t.Errorf("0x00: expected no tail call, got %+v", c)
+	}
+}
+
+func TestClassifyJumpsARM64(t *testing.T) {
+	code := []byte{
+		0xfd, 0x7b, 0xbf, 0xa9, // 0x00: stp x29, x30, [sp, #-16]!
+		0xfd, 0x03, 0x00, 0x91, // 0x04: mov x29, sp
+		0x02, 0x00, 0x00, 0x14, // 0x08: b 0x10  - forward branch
+		0x1f, 0x20, 0x03, 0xd5, // 0x0c: nop
+		0xfd, 0x7b, 0xc1, 0xa8, // 0x10: ldp x29, x30, [sp], #16
+		0x03, 0x00, 0x00, 0x14, // 0x14: b 0x20  - tail call
+		0x00, 0x00, 0x00, 0x00, // 0x18: udf #0
+		0x00, 0x00, 0x00, 0x00, // 0x1c: udf #0
+		0xc0, 0x03, 0x5f, 0xd6, // 0x20: ret
+	}
+
+	edges, err := resurgo.DetectCallSites(code, 0, resurgo.ArchARM64)
+	if err != nil {
+		t.Fatalf("DetectCallSites: %v", err)
+	}
+	candidates, err := resurgo.DetectFunctions(code, 0, resurgo.ArchARM64)
+	if err != nil {
+		t.Fatalf("DetectFunctions: %v", err)
+	}
+	classified, err := resurgo.ClassifyJumps(code, 0, resurgo.ArchARM64, edges, candidates)
+	if err != nil {
+		t.Fatalf("ClassifyJumps: %v", err)
+	}
+
+	want := map[uint64]resurgo.JumpKind{
+		0x08: resurgo.JumpInternal,
+		0x14: resurgo.JumpTailCall,
+	}
+	if got := jumpKinds(classified); !maps.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	for _, c := range candidates {
+		if c.Address == 0x10 && len(c.JumpedFrom) > 0 {
+			t.Errorf("0x10: forward branch promoted to tail call: %+v", c)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+/*
+ * Kernel-style code, built with the ftrace, return thunk and retpoline
+ * code generation options the Linux kernel uses on x86-64:
+ *
+ *   -pg -mfentry -mfunction-return=thunk-extern
+ *   -mindirect-branch=thunk-extern -fcf-protection=branch
+ *
+ * Built with -c it stands for a module; linked with -nostdlib it stands
+ * for a kernel image, with the thunks and entry point defined below.
+ */
+
+volatile long sink;
+long (*volatile handler)(long);
+
+__attribute__((noipa)) long step(long v)
+{
+	sink = v;
+	return v + 1;
+}
+
+__attribute__((noipa)) long walk(long *v, long n)
+{
+	long acc = 0;
+	for (long i = 0; i < n; i++)
+		acc += step(v[i]);
+	return acc;
+}
+
+__attribute__((noipa)) long dispatch(long v)
+{
+	return handler(v) + step(v);
+}
+
+__attribute__((noipa, section(".init.text"))) long init_module(void)
+{
+	long v[4] = {1, 2, 3, 4};
+	return walk(v, 4) + dispatch(5);
+}
+
+#ifdef KERNEL_IMAGE
+asm(".text\n"
+    ".globl __fentry__\n"
+    "__fentry__:\n"
+    "	ret\n"
+    ".globl __x86_return_thunk\n"
+    "__x86_return_thunk:\n"
+    "	ret\n"
+    "	int3\n"
+    ".globl __x86_indirect_thunk_rax\n"
+    "__x86_indirect_thunk_rax:\n"
+    "	jmp *%rax\n"
+    "	int3\n"
+    ".globl _start\n"
+    "_start:\n"
+    "	call init_module\n"
+    "	hlt\n");
+#endif
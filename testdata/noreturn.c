@@ -0,0 +1,27 @@
+extern int printf(const char *, ...);
+extern void exit(int);
+
+volatile int sink;
+
+__attribute__((noipa)) void fail(int code) {
+	printf("fatal: %d\n", code);
+	exit(code);
+}
+
+__attribute__((noipa)) void fail_twice(int code) {
+	sink = code;
+	fail(code + 1);
+}
+
+__attribute__((noipa)) int check(int v) {
+	if (v < 0)
+		fail(v);
+	return v * 2;
+}
+
+int main(int argc, char **argv) {
+	(void)argv;
+	if (argc > 3)
+		fail_twice(argc);
+	return check(argc);
+}
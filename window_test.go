@@ -0,0 +1,97 @@
+package resurgo_test
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/maxgio92/resurgo"
+)
+
+// maxReadReaderAt records the largest read issued through it.
+type maxReadReaderAt struct {
+	r   io.ReaderAt
+	max int
+}
+
+func (m *maxReadReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	m.max = max(m.max, len(p))
+	return m.r.ReadAt(p, off)
+}
+
+// buildDemoApp compiles the Go demo application for goarch and returns the
+// path of the binary.
+func buildDemoApp(t *testing.T, goarch string) string {
+	t.Helper()
+
+	binPath := filepath.Join(t.TempDir(), demoAppBinary)
+	cmd := exec.Command("go", "build", "-o", binPath, demoAppSource)
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOARCH="+goarch)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to compile demo-app: %v\n%s", err, out)
+	}
+	return binPath
+}
+
+func TestFromELF_WindowSize(t *testing.T) {
+	for _, goarch := range []string{"amd64", "arm64"} {
+		t.Run(goarch, func(t *testing.T) {
+			f, err := os.Open(buildDemoApp(t, goarch))
+			if err != nil {
+				t.Fatalf("failed to open compiled binary: %v", err)
+			}
+			defer f.Close()
+
+			wantPrologues, err := resurgo.DetectProloguesFromELF(f)
+			if err != nil {
+				t.Fatalf("DetectProloguesFromELF: %v", err)
+			}
+			wantEdges, err := resurgo.DetectCallSitesFromELF(f)
+			if err != nil {
+				t.Fatalf("DetectCallSitesFromELF: %v", err)
+			}
+			wantCandidates, err := resurgo.DetectFunctionsFromELF(f)
+			if err != nil {
+				t.Fatalf("DetectFunctionsFromELF: %v", err)
+			}
+
+			for _, window := range []int{1, 37, 4096} {
+				r := &maxReadReaderAt{r: f}
+				opts := resurgo.Options{WindowSize: window}
+
+				prologues, err := resurgo.DetectProloguesFromELFWithOptions(r, opts)
+				if err != nil {
+					t.Fatalf("window %d: DetectProloguesFromELFWithOptions: %v", window, err)
+				}
+				if !slices.Equal(prologues, wantPrologues) {
+					t.Errorf("window %d: prologues differ: got %d, want %d", window, len(prologues), len(wantPrologues))
+				}
+
+				edges, err := resurgo.DetectCallSitesFromELFWithOptions(r, opts)
+				if err != nil {
+					t.Fatalf("window %d: DetectCallSitesFromELFWithOptions: %v", window, err)
+				}
+				if !slices.Equal(edges, wantEdges) {
+					t.Errorf("window %d: call sites differ: got %d, want %d", window, len(edges), len(wantEdges))
+				}
+
+				candidates, err := resurgo.DetectFunctionsFromELFWithOptions(r, opts)
+				if err != nil {
+					t.Fatalf("window %d: DetectFunctionsFromELFWithOptions: %v", window, err)
+				}
+				if len(candidates) != len(wantCandidates) {
+					t.Errorf("window %d: expected %d candidates, got %d", window, len(wantCandidates), len(candidates))
+				}
+
+				// The ELF headers are read in small pieces; code reads are
+				// bounded by the window plus the lookahead.
+				if limit := 4096 + 16; r.max > limit {
+					t.Errorf("window %d: read of %d bytes exceeds %d", window, r.max, limit)
+				}
+			}
+		})
+	}
+}